@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// API_VERSION is the version of the REST API, part of its path. The API is described by
+// docs/openapi.json, which the app serves.
+const API_VERSION = "v1"
+
+// The codes of requests the API can't take, the other codes are the ones of provider.Code.
+const (
+	API_ERROR_INVALID = "invalid-request"
+	API_ERROR_RUNNING = "already-running"
+)
+
+// APIError is the body of every error the API responds with.
+type APIError struct {
+	Error APIErrorBody `json:"error"`
+}
+
+type APIErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ProviderResponse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	LoggedIn bool   `json:"loggedIn"`
+}
+
+type PlaylistResponse struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Tracks  uint   `json:"tracks"`
+	Creator string `json:"creator,omitempty"`
+}
+
+// FullPlaylistResponse is a playlist with its tracks, TrackCount is the number of tracks
+// the provider reports, which the tracks don't always add up to, e.g. without local files.
+type FullPlaylistResponse struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	TrackCount uint            `json:"trackCount"`
+	Creator    string          `json:"creator,omitempty"`
+	Tracks     []TrackResponse `json:"tracks"`
+}
+
+type TrackResponse struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists"`
+	ISRC    string   `json:"isrc,omitempty"`
+	// DurationMs is the length of the track in milliseconds, 0 when unknown
+	DurationMs  int64  `json:"durationMs,omitempty"`
+	Album       string `json:"album,omitempty"`
+	ReleaseYear int    `json:"releaseYear,omitempty"`
+	URL         string `json:"url,omitempty"`
+	// AddedAt is only known on playlists of some providers
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+}
+
+// JobResponse is a job with the progress of each playlist.
+type JobResponse struct {
+	ID            string                `json:"id"`
+	Origin        string                `json:"origin"`
+	Destination   string                `json:"destination"`
+	Mode          string                `json:"mode,omitempty"`
+	Order         string                `json:"order,omitempty"`
+	Policy        string                `json:"policy,omitempty"`
+	RemoveMissing bool                  `json:"removeMissing,omitempty"`
+	Scheduled     bool                  `json:"scheduled,omitempty"`
+	Status        string                `json:"status"`
+	Error         string                `json:"error,omitempty"`
+	ErrorCode     string                `json:"errorCode,omitempty"`
+	ResumeAt      *time.Time            `json:"resumeAt,omitempty"`
+	CreatedAt     time.Time             `json:"createdAt"`
+	UpdatedAt     time.Time             `json:"updatedAt"`
+	Playlists     []JobPlaylistResponse `json:"playlists"`
+}
+
+type JobPlaylistResponse struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	DestinationID string             `json:"destinationId,omitempty"`
+	Removed       int                `json:"removed"`
+	Tracks        []JobTrackResponse `json:"tracks"`
+}
+
+type JobTrackResponse struct {
+	TrackResponse
+	State         string  `json:"state"`
+	DestinationID string  `json:"destinationId,omitempty"`
+	Reason        string  `json:"reason,omitempty"`
+	Score         float64 `json:"score,omitempty"`
+	Review        string  `json:"review,omitempty"`
+}
+
+// apiRouter is the REST API, mounted on /api/API_VERSION. It uses the same session as
+// the pages, so clients log in on the app first.
+func (a application) apiRouter() chi.Router {
+	router := chi.NewRouter()
+	router.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusNotFound, provider.CODE_NOT_FOUND, fmt.Errorf("no endpoint %s", r.URL.Path))
+	})
+	router.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		apiError(w, http.StatusMethodNotAllowed, API_ERROR_INVALID, fmt.Errorf("%s is not allowed on %s", r.Method, r.URL.Path))
+	})
+	router.Get("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "./docs/openapi.json")
+	})
+	router.Get("/providers", a.apiProvidersHandler)
+	router.Get("/providers/{provider}/playlists", a.apiPlaylistsHandler)
+	router.Get("/providers/{provider}/playlists/{id}", a.apiPlaylistHandler)
+	router.Get("/jobs", a.apiJobsHandler)
+	router.Post("/jobs", a.apiCreateJobHandler)
+	router.Get("/jobs/{id}", a.apiJobHandler)
+	router.Post("/jobs/{id}/cancel", a.apiCancelJobHandler)
+	router.Get("/jobs/{id}/events", a.apiJobEventsHandler)
+	return router
+}
+
+func (a application) apiProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	providers := []ProviderResponse{}
+	for _, name := range []string{PROVIDER_SPOTIFY, PROVIDER_GOOGLE} {
+		p, err := a.getProvider(name, r, w)
+		if err != nil {
+			apiFail(w, err)
+			return
+		}
+		providers = append(providers, ProviderResponse{ID: name, Name: p.Name(), LoggedIn: p.IsLoggedIn()})
+	}
+	writeJSON(w, http.StatusOK, providers)
+}
+
+func (a application) apiPlaylistsHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.apiProvider(w, r)
+	if !ok {
+		return
+	}
+	playlists, err := p.GetPlaylists(r.Context())
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	response := []PlaylistResponse{}
+	for _, playlist := range playlists {
+		response = append(response, toPlaylistResponse(playlist))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a application) apiPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.apiProvider(w, r)
+	if !ok {
+		return
+	}
+	playlist, err := p.GetFullPlaylist(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	response := FullPlaylistResponse{
+		ID:         string(playlist.ID),
+		Name:       playlist.Name,
+		TrackCount: playlist.Playlist.Tracks,
+		Creator:    playlist.Creator,
+		Tracks:     []TrackResponse{},
+	}
+	for _, t := range playlist.Tracks {
+		response.Tracks = append(response.Tracks, toTrackResponse(t))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// apiProvider returns the provider of the path, responding with an error when the user
+// isn't logged in on it.
+func (a application) apiProvider(w http.ResponseWriter, r *http.Request) (provider.Provider, bool) {
+	name := chi.URLParam(r, "provider")
+	if name != PROVIDER_GOOGLE && name != PROVIDER_SPOTIFY {
+		apiError(w, http.StatusNotFound, provider.CODE_NOT_FOUND, fmt.Errorf("invalid provider %s", name))
+		return nil, false
+	}
+	p, err := a.getProvider(name, r, w)
+	if err != nil {
+		apiFail(w, err)
+		return nil, false
+	}
+	if !p.IsLoggedIn() {
+		apiFail(w, provider.NewError(provider.ErrUnauthorized, fmt.Errorf("not logged in on %s", p.Name())))
+		return nil, false
+	}
+	return p, true
+}
+
+// apiJobsHandler lists the jobs of the user of the session, the jobs of other users are
+// never found by the API.
+func (a application) apiJobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := a.ownedJobs(r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	response := []JobResponse{}
+	for _, j := range jobs {
+		response = append(response, toJobResponse(j))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// apiCreateJobHandler starts a transfer from a TransferPayload, it runs in the background
+// and is followed on the job events or by polling the job.
+func (a application) apiCreateJobHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TransferPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		apiError(w, http.StatusBadRequest, API_ERROR_INVALID, err)
+		return
+	}
+	if payload.DryRun {
+		apiError(w, http.StatusBadRequest, API_ERROR_INVALID, errors.New("dry runs are only supported on /transfer"))
+		return
+	}
+	if err := validatePayload(&payload); err != nil {
+		apiError(w, http.StatusBadRequest, API_ERROR_INVALID, err)
+		return
+	}
+	j, err := a.startJob(&payload, r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/%s/jobs/%s", API_VERSION, j.ID))
+	writeJSON(w, http.StatusCreated, toJobResponse(*j))
+}
+
+func (a application) apiJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toJobResponse(*j))
+}
+
+func (a application) apiCancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	if err := a.cancelJob(j.ID); err != nil {
+		apiFail(w, err)
+		return
+	}
+	j, err = a.jobs.Get(j.ID)
+	if err != nil {
+		apiFail(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toJobResponse(*j))
+}
+
+// apiJobEventsHandler is jobEventsHandler with the errors of the API.
+func (a application) apiJobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := a.getOwnedJob(r); err != nil {
+		apiFail(w, err)
+		return
+	}
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			apiError(w, http.StatusBadRequest, API_ERROR_INVALID, fmt.Errorf("invalid Last-Event-ID %s", value))
+			return
+		}
+	}
+	a.jobEventsHandler(w, r)
+}
+
+func toPlaylistResponse(p provider.Playlist) PlaylistResponse {
+	return PlaylistResponse{ID: string(p.ID), Name: p.Name, Tracks: p.Tracks, Creator: p.Creator}
+}
+
+func toTrackResponse(t provider.Track) TrackResponse {
+	response := TrackResponse{
+		ID:          t.ID,
+		Name:        t.Name,
+		Artists:     t.Artists,
+		ISRC:        t.ISRC,
+		DurationMs:  t.Duration.Milliseconds(),
+		Album:       t.Album,
+		ReleaseYear: t.ReleaseYear,
+		URL:         t.URL,
+	}
+	if !t.AddedAt.IsZero() {
+		response.AddedAt = &t.AddedAt
+	}
+	return response
+}
+
+func toJobResponse(j job.Job) JobResponse {
+	response := JobResponse{
+		ID:            j.ID,
+		Origin:        j.Origin,
+		Destination:   j.Destination,
+		Mode:          j.Mode,
+		Order:         j.Order,
+		Policy:        j.Policy,
+		RemoveMissing: j.RemoveMissing,
+		Scheduled:     j.Scheduled,
+		Status:        j.Status,
+		Error:         j.Error,
+		ErrorCode:     j.ErrorCode,
+		CreatedAt:     j.CreatedAt,
+		UpdatedAt:     j.UpdatedAt,
+		Playlists:     []JobPlaylistResponse{},
+	}
+	if !j.ResumeAt.IsZero() {
+		response.ResumeAt = &j.ResumeAt
+	}
+	for _, p := range j.Playlists {
+		playlist := JobPlaylistResponse{
+			ID:            string(p.ID),
+			Name:          p.Name,
+			DestinationID: p.DestinationID,
+			Removed:       p.Removed,
+			Tracks:        []JobTrackResponse{},
+		}
+		for _, t := range p.Tracks {
+			playlist.Tracks = append(playlist.Tracks, JobTrackResponse{
+				TrackResponse: toTrackResponse(t.Track),
+				State:         t.State,
+				DestinationID: t.DestinationID,
+				Reason:        t.Reason,
+				Score:         t.Score,
+				Review:        t.Review,
+			})
+		}
+		response.Playlists = append(response.Playlists, playlist)
+	}
+	return response
+}
+
+// apiFail responds with the status and code of err, which is a job or provider error.
+func apiFail(w http.ResponseWriter, err error) {
+	code := provider.Code(err)
+	if errors.Is(err, job.ErrNotFound) {
+		apiError(w, http.StatusNotFound, provider.CODE_NOT_FOUND, err)
+	} else if errors.Is(err, transfer.ErrAlreadyRunning) {
+		apiError(w, http.StatusConflict, API_ERROR_RUNNING, err)
+	} else if code == provider.CODE_NOT_FOUND {
+		apiError(w, http.StatusNotFound, code, err)
+	} else if code == provider.CODE_UNAUTHORIZED {
+		apiError(w, http.StatusUnauthorized, code, err)
+	} else if code == provider.CODE_QUOTA_EXCEEDED || code == provider.CODE_RATE_LIMITED {
+		apiError(w, http.StatusTooManyRequests, code, err)
+	} else if code == provider.CODE_TRANSIENT {
+		apiError(w, http.StatusBadGateway, code, err)
+	} else {
+		apiError(w, http.StatusInternalServerError, code, err)
+	}
+}
+
+func apiError(w http.ResponseWriter, status int, code string, err error) {
+	writeJSON(w, status, APIError{Error: APIErrorBody{Code: code, Message: err.Error()}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
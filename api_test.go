@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+)
+
+func TestAPIShouldOnlyListTheJobsOfTheUser(t *testing.T) {
+	a := newTestApplication(t)
+	own := saveJob(t, a, "alice", job.STATUS_PAUSED)
+	saveJob(t, a, "bob", job.STATUS_PAUSED)
+
+	res, body := serve(a, httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil), loggedIn(t, a, "alice"))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the jobs but got %d: %s", res.StatusCode, body)
+	}
+	var jobs []JobResponse
+	if err := json.Unmarshal([]byte(body), &jobs); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != own.ID {
+		t.Fatalf("expected only the job of the user but got %+v", jobs)
+	}
+}
+
+func TestAPIShouldNotFindTheJobsOfOtherUsers(t *testing.T) {
+	a := newTestApplication(t)
+	other := saveJob(t, a, "bob", job.STATUS_PAUSED)
+	cookies := loggedIn(t, a, "alice")
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+other.ID, nil),
+		httptest.NewRequest(http.MethodPost, "/api/v1/jobs/"+other.ID+"/cancel", nil),
+		httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+other.ID+"/events", nil),
+	}
+	for _, r := range requests {
+		res, body := serve(a, r, cookies)
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected %s to not be found but got %d: %s", r.URL.Path, res.StatusCode, body)
+		}
+		var apiErr APIError
+		if err := json.Unmarshal([]byte(body), &apiErr); err != nil || apiErr.Error.Code != provider.CODE_NOT_FOUND {
+			t.Fatalf("expected a not found error but got %s", body)
+		}
+		if strings.Contains(body, "bob") {
+			t.Fatalf("expected nothing about the job to be sent but got %s", body)
+		}
+	}
+	j, err := a.jobs.Get(other.ID)
+	if err != nil || j.Status != job.STATUS_PAUSED {
+		t.Fatalf("expected the job to be left as it was but got %+v, %v", j, err)
+	}
+}
+
+func TestAPIShouldCancelTheJobsOfTheUser(t *testing.T) {
+	a := newTestApplication(t)
+	own := saveJob(t, a, "alice", job.STATUS_PAUSED)
+
+	res, body := serve(a, httptest.NewRequest(http.MethodPost, "/api/v1/jobs/"+own.ID+"/cancel", nil), loggedIn(t, a, "alice"))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the job but got %d: %s", res.StatusCode, body)
+	}
+	var j JobResponse
+	if err := json.Unmarshal([]byte(body), &j); err != nil || j.Status != job.STATUS_CANCELLED {
+		t.Fatalf("expected the job to be cancelled but got %s", body)
+	}
+}
+
+func TestAPIShouldRejectInvalidJobs(t *testing.T) {
+	a := newTestApplication(t)
+	cookies := loggedIn(t, a, "alice")
+	bodies := []string{
+		`not json`,
+		`{"origin": "spotify", "destination": "google", "playlists": []}`,
+		`{"origin": "spotify", "destination": "spotify", "playlists": [{"id": "1", "name": "playlist"}]}`,
+		`{"origin": "spotify", "destination": "google", "dryRun": true, "playlists": [{"id": "1", "name": "playlist"}]}`,
+	}
+	for _, body := range bodies {
+		res, response := serve(a, httptest.NewRequest(http.MethodPost, "/api/v1/jobs", strings.NewReader(body)), cookies)
+		var apiErr APIError
+		if res.StatusCode != http.StatusBadRequest || json.Unmarshal([]byte(response), &apiErr) != nil || apiErr.Error.Code != API_ERROR_INVALID {
+			t.Fatalf("expected %s to be rejected but got %d: %s", body, res.StatusCode, response)
+		}
+	}
+}
+
+func TestAPIShouldRejectInvalidLastEventIDs(t *testing.T) {
+	a := newTestApplication(t)
+	own := saveJob(t, a, "alice", job.STATUS_PAUSED)
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+own.ID+"/events", nil)
+	r.Header.Set("Last-Event-ID", "last")
+	res, body := serve(a, r, loggedIn(t, a, "alice"))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the Last-Event-ID to be rejected but got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestAPIShouldRespondWithJSONErrorsOnUnknownEndpoints(t *testing.T) {
+	a := newTestApplication(t)
+
+	res, body := serve(a, httptest.NewRequest(http.MethodGet, "/api/v1/nothing", nil), nil)
+	var apiErr APIError
+	if res.StatusCode != http.StatusNotFound || json.Unmarshal([]byte(body), &apiErr) != nil || apiErr.Error.Code != provider.CODE_NOT_FOUND {
+		t.Fatalf("expected a not found error but got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestTrackResponseShouldOmitUnknownAddedAt(t *testing.T) {
+	data, _ := json.Marshal(toTrackResponse(provider.Track{ID: "1", Name: "Song"}))
+	if strings.Contains(string(data), "addedAt") {
+		t.Fatalf("expected no addedAt but got %s", data)
+	}
+	added := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	data, _ = json.Marshal(toTrackResponse(provider.Track{ID: "1", Name: "Song", AddedAt: added}))
+	if !strings.Contains(string(data), `"addedAt":"2023-04-05T06:07:08Z"`) {
+		t.Fatalf("expected the addedAt but got %s", data)
+	}
+}
+
+func TestFullPlaylistResponseShouldHaveTheTrackCountAndTracks(t *testing.T) {
+	data, _ := json.Marshal(FullPlaylistResponse{ID: "1", Name: "playlist", TrackCount: 3, Tracks: []TrackResponse{{ID: "track"}}})
+	var response map[string]any
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if response["trackCount"] != float64(3) {
+		t.Fatalf("expected the track count but got %s", data)
+	}
+	if tracks, ok := response["tracks"].([]any); !ok || len(tracks) != 1 {
+		t.Fatalf("expected the tracks but got %s", data)
+	}
+}
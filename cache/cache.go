@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/paulombcosta/waltz/normalize"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const MATCHES_BUCKET = "matches"
+
+// MatchCache remembers which track on a destination provider matched a source track,
+// so the same song doesn't have to be searched again.
+type MatchCache interface {
+	Get(destination string, track provider.Track) (provider.TrackID, bool, error)
+	Put(destination string, track provider.Track, id provider.TrackID) error
+}
+
+// Key identifies a track independently of the provider it comes from: by its ISRC
+// when it's known, otherwise by its normalized artists and title.
+func Key(track provider.Track) string {
+	if track.ISRC != "" {
+		return "isrc:" + strings.ToUpper(track.ISRC)
+	}
+	artists := normalize.Artists(track.Artists, track.Name)
+	return fmt.Sprintf("name:%s - %s", normalize.Key(strings.Join(artists, " ")), normalize.Key(normalize.Title(track.Name)))
+}
+
+func New(s *store.Store) StoreMatchCache {
+	return StoreMatchCache{store: s}
+}
+
+type StoreMatchCache struct {
+	store *store.Store
+}
+
+func (c StoreMatchCache) Get(destination string, track provider.Track) (provider.TrackID, bool, error) {
+	var id provider.TrackID
+	found, err := c.store.Get(MATCHES_BUCKET, storeKey(destination, track), &id)
+	if err != nil {
+		return "", false, err
+	}
+	return id, found, nil
+}
+
+func (c StoreMatchCache) Put(destination string, track provider.Track, id provider.TrackID) error {
+	return c.store.Put(MATCHES_BUCKET, storeKey(destination, track), id)
+}
+
+func storeKey(destination string, track provider.Track) string {
+	return destination + "/" + Key(track)
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+func openStore(t *testing.T) *store.Store {
+	s, err := store.Open(filepath.Join(t.TempDir(), "waltz.db"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestKeyPrefersISRC(t *testing.T) {
+	track := provider.Track{Name: "Song", Artists: []string{"Artist"}, ISRC: "usum71703861"}
+	expected := "isrc:USUM71703861"
+	if actual := Key(track); actual != expected {
+		t.Fatalf("expected key to be %s but it is %s", expected, actual)
+	}
+}
+
+func TestKeyIgnoresTitleDecorations(t *testing.T) {
+	a := provider.Track{Name: "Song - Remastered 2011", Artists: []string{"Beyoncé"}}
+	b := provider.Track{Name: "song", Artists: []string{"Beyonce"}}
+	if Key(a) != Key(b) {
+		t.Fatalf("expected %s and %s to be the same key", Key(a), Key(b))
+	}
+}
+
+func TestGetReturnsStoredMatch(t *testing.T) {
+	c := New(openStore(t))
+	track := provider.Track{Name: "Song", Artists: []string{"Artist"}}
+
+	_, found, err := c.Get("YouTube", track)
+	if err != nil || found {
+		t.Fatalf("expected no match, found %t, err %v", found, err)
+	}
+	if err := c.Put("YouTube", track, "video"); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	id, found, err := c.Get("YouTube", track)
+	if err != nil || !found || id != "video" {
+		t.Fatalf("expected video to be found, got %s, found %t, err %v", id, found, err)
+	}
+	_, found, _ = c.Get("Spotify", track)
+	if found {
+		t.Fatalf("expected matches to be kept per destination")
+	}
+}
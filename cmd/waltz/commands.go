@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// The formats playlists are exported as.
+const (
+	FORMAT_JSON = "json"
+	FORMAT_CSV  = "csv"
+)
+
+func (c *cli) listPlaylists(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("playlists list", flag.ExitOnError)
+	name := flags.String("provider", PROVIDER_SPOTIFY, "spotify or google")
+	_ = flags.Parse(args)
+	p, err := c.provider(*name)
+	if err != nil {
+		return err
+	}
+	playlists, err := p.GetPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTRACKS")
+	for _, playlist := range playlists {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", playlist.ID, playlist.Name, playlist.Tracks)
+	}
+	return w.Flush()
+}
+
+// jobFlags are the flags of the commands that start jobs.
+type jobFlags struct {
+	flags   *flag.FlagSet
+	from    *string
+	to      *string
+	order   *string
+	workers *int
+	all     *bool
+}
+
+func newJobFlags(name string) jobFlags {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	return jobFlags{
+		flags:   flags,
+		from:    flags.String("from", PROVIDER_SPOTIFY, "provider to copy from, spotify or google"),
+		to:      flags.String("to", PROVIDER_GOOGLE, "provider to copy to, spotify or google"),
+		order:   flags.String("order", transfer.ORDER_APPEND, "append or mirror, how new tracks are placed on existing playlists"),
+		workers: flags.Int("workers", transfer.DEFAULT_WORKERS, "how many tracks are matched at the same time"),
+		all:     flags.Bool("all", false, "take every playlist of the origin"),
+	}
+}
+
+// parse reads the flags and returns the providers and the playlists of the job.
+func (f jobFlags) parse(ctx context.Context, c *cli, args []string) (provider.Provider, provider.Provider, []provider.Playlist, error) {
+	_ = f.flags.Parse(args)
+	if *f.from == *f.to {
+		return nil, nil, nil, errors.New("origin and destination must be different providers")
+	}
+	if *f.order != transfer.ORDER_APPEND && *f.order != transfer.ORDER_MIRROR {
+		return nil, nil, nil, fmt.Errorf("invalid order %s", *f.order)
+	}
+	origin, err := c.provider(*f.from)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	destination, err := c.provider(*f.to)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	playlists, err := findPlaylists(ctx, origin, f.flags.Args(), *f.all)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return origin, destination, playlists, nil
+}
+
+func (c *cli) transfer(ctx context.Context, args []string) error {
+	f := newJobFlags("transfer")
+	dryRun := f.flags.Bool("dry-run", false, "print what the transfer would do without changing the destination")
+	origin, destination, playlists, err := f.parse(ctx, c, args)
+	if err != nil {
+		return err
+	}
+	runner, err := c.runner(*f.workers)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		return runner.Plan(ctx, playlists, origin, destination, publisher())
+	}
+	j := job.New(*f.from, *f.to, playlists)
+	j.Mode = transfer.MODE_TRANSFER
+	j.Order = *f.order
+	return c.start(ctx, runner, j, origin, destination)
+}
+
+func (c *cli) sync(ctx context.Context, args []string) error {
+	f := newJobFlags("sync")
+	removeMissing := f.flags.Bool("remove-missing", false, "remove the tracks removed from the origin")
+	twoWay := f.flags.Bool("two-way", false, "sync both ways, adding and removing on each playlist what changed on the other")
+	policy := f.flags.String("policy", transfer.POLICY_SOURCE_WINS, "source-wins, union or manual, how conflicts of two-way syncs are resolved")
+	origin, destination, playlists, err := f.parse(ctx, c, args)
+	if err != nil {
+		return err
+	}
+	if *policy != transfer.POLICY_SOURCE_WINS && *policy != transfer.POLICY_UNION && *policy != transfer.POLICY_MANUAL {
+		return fmt.Errorf("invalid policy %s", *policy)
+	}
+	runner, err := c.runner(*f.workers)
+	if err != nil {
+		return err
+	}
+	j := job.New(*f.from, *f.to, playlists)
+	j.Mode = transfer.MODE_SYNC
+	j.Order = *f.order
+	j.RemoveMissing = *removeMissing
+	if *twoWay {
+		j.Mode = transfer.MODE_TWO_WAY
+		j.Policy = *policy
+	}
+	return c.start(ctx, runner, j, origin, destination)
+}
+
+func (c *cli) resume(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("resume", flag.ExitOnError)
+	workers := flags.Int("workers", transfer.DEFAULT_WORKERS, "how many tracks are matched at the same time")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: waltz resume [-workers n] <job>")
+	}
+	db, err := c.store()
+	if err != nil {
+		return err
+	}
+	j, err := job.NewStore(db).Get(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if j.Status != job.STATUS_PAUSED && j.Status != job.STATUS_FAILED {
+		return fmt.Errorf("cannot resume a %s job", j.Status)
+	}
+	origin, destination, err := c.jobProviders(j)
+	if err != nil {
+		return err
+	}
+	runner, err := c.runner(*workers)
+	if err != nil {
+		return err
+	}
+	return c.start(ctx, runner, j, origin, destination)
+}
+
+// start runs the job until it stops, interrupting the command cancels it.
+func (c *cli) start(ctx context.Context, runner *transfer.Runner, j *job.Job, origin provider.Provider, destination provider.Provider) error {
+	out := publisher()
+	_ = out.Publish(transfer.PROGRESS_JOB, j.ID)
+	err := runner.Start(ctx, j, origin, destination, out)
+	if errors.Is(err, transfer.ErrPaused) {
+		return fmt.Errorf("%w, run: waltz resume %s", err, j.ID)
+	} else if err != nil && !errors.Is(err, transfer.ErrCancelled) {
+		out.Fail(err)
+	}
+	return err
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	name := flags.String("provider", PROVIDER_SPOTIFY, "spotify or google")
+	format := flags.String("format", FORMAT_JSON, "json or csv")
+	output := flags.String("o", "", "file to write to, the standard output when empty")
+	all := flags.Bool("all", false, "export every playlist")
+	_ = flags.Parse(args)
+	if *format != FORMAT_JSON && *format != FORMAT_CSV {
+		return fmt.Errorf("invalid format %s", *format)
+	}
+	p, err := c.provider(*name)
+	if err != nil {
+		return err
+	}
+	playlists, err := findPlaylists(ctx, p, flags.Args(), *all)
+	if err != nil {
+		return err
+	}
+	fullPlaylists := []provider.FullPlaylist{}
+	for _, playlist := range playlists {
+		fullPlaylist, err := p.GetFullPlaylist(ctx, string(playlist.ID))
+		if err != nil {
+			return err
+		}
+		fullPlaylists = append(fullPlaylists, *fullPlaylist)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if *format == FORMAT_CSV {
+		return writeCSV(w, fullPlaylists)
+	}
+	return writeJSON(w, fullPlaylists)
+}
+
+// findPlaylists returns the playlists with the given IDs or names, or all of them.
+func findPlaylists(ctx context.Context, p provider.Provider, names []string, all bool) ([]provider.Playlist, error) {
+	if len(names) == 0 && !all {
+		return nil, errors.New("no playlists given, pass their IDs or names or -all")
+	}
+	playlists, err := p.GetPlaylists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if all {
+		return playlists, nil
+	}
+	found := []provider.Playlist{}
+	for _, name := range names {
+		matches := []provider.Playlist{}
+		for _, playlist := range playlists {
+			if string(playlist.ID) == name || playlist.Name == name {
+				matches = append(matches, playlist)
+			}
+		}
+		if len(matches) == 0 {
+			return nil, provider.NewError(provider.ErrNotFound, fmt.Errorf("no playlist %q on %s", name, p.Name()))
+		}
+		if len(matches) > 1 {
+			return nil, fmt.Errorf("%d playlists are named %q on %s, pass the ID instead", len(matches), name, p.Name())
+		}
+		found = append(found, matches[0])
+	}
+	return found, nil
+}
+
+type exportedPlaylist struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name"`
+	Tracks []exportedTrack `json:"tracks"`
+}
+
+type exportedTrack struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Artists     []string `json:"artists"`
+	Album       string   `json:"album,omitempty"`
+	ISRC        string   `json:"isrc,omitempty"`
+	DurationMs  int64    `json:"durationMs,omitempty"`
+	ReleaseYear int      `json:"releaseYear,omitempty"`
+	URL         string   `json:"url,omitempty"`
+	// AddedAt is only known on playlists of some providers
+	AddedAt *time.Time `json:"addedAt,omitempty"`
+}
+
+func writeJSON(w io.Writer, playlists []provider.FullPlaylist) error {
+	exported := []exportedPlaylist{}
+	for _, p := range playlists {
+		playlist := exportedPlaylist{ID: string(p.ID), Name: p.Name, Tracks: []exportedTrack{}}
+		for _, t := range p.Tracks {
+			track := exportedTrack{
+				ID:          t.ID,
+				Name:        t.Name,
+				Artists:     t.Artists,
+				Album:       t.Album,
+				ISRC:        t.ISRC,
+				DurationMs:  t.Duration.Milliseconds(),
+				ReleaseYear: t.ReleaseYear,
+				URL:         t.URL,
+			}
+			if !t.AddedAt.IsZero() {
+				addedAt := t.AddedAt
+				track.AddedAt = &addedAt
+			}
+			playlist.Tracks = append(playlist.Tracks, track)
+		}
+		exported = append(exported, playlist)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(exported)
+}
+
+// writeCSV writes a row for each track, with the playlist it's on.
+func writeCSV(w io.Writer, playlists []provider.FullPlaylist) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write([]string{"playlist", "id", "name", "artists", "album", "isrc", "duration_ms", "release_year", "url"})
+	for _, p := range playlists {
+		for _, t := range p.Tracks {
+			_ = writer.Write([]string{
+				p.Name,
+				t.ID,
+				t.Name,
+				strings.Join(t.Artists, ", "),
+				t.Album,
+				t.ISRC,
+				strconv.FormatInt(t.Duration.Milliseconds(), 10),
+				strconv.Itoa(t.ReleaseYear),
+				t.URL,
+			})
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/token"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestCli(t *testing.T) *cli {
+	key, _ := token.ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	credentials, err := token.NewCredentialsFile(filepath.Join(t.TempDir(), "credentials.json"), key)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	c := &cli{credentials: credentials, user: token.DEFAULT_USER, dbPath: filepath.Join(t.TempDir(), "waltz.db")}
+	t.Cleanup(c.close)
+	return c
+}
+
+func TestJobFlagsShouldRejectInvalidOptions(t *testing.T) {
+	c := newTestCli(t)
+	invalid := [][]string{
+		{"-from", "google", "-to", "google", "playlist"},
+		{"-order", "shuffle", "playlist"},
+	}
+	for _, args := range invalid {
+		if _, _, _, err := newJobFlags("transfer").parse(context.Background(), c, args); err == nil {
+			t.Fatalf("expected %v to be rejected", args)
+		}
+	}
+}
+
+func TestJobFlagsShouldRequireALogin(t *testing.T) {
+	c := newTestCli(t)
+
+	_, _, _, err := newJobFlags("sync").parse(context.Background(), c, []string{"-from", "google", "-to", "spotify", "playlist"})
+	if !errors.Is(err, provider.ErrUnauthorized) || !strings.Contains(err.Error(), "waltz login google") {
+		t.Fatalf("expected to be told to log in but got %v", err)
+	}
+}
+
+func TestFindPlaylistsShouldTakeIDsAndNames(t *testing.T) {
+	p := provider.NewMockProvider(t)
+	p.EXPECT().Name().Return("Spotify").Maybe()
+	p.EXPECT().GetPlaylists(mock.Anything).Return([]provider.Playlist{
+		{ID: "1", Name: "First"},
+		{ID: "2", Name: "Twice"},
+		{ID: "3", Name: "Twice"},
+	}, nil)
+	ctx := context.Background()
+
+	found, err := findPlaylists(ctx, p, []string{"First", "3"}, false)
+	if err != nil || len(found) != 2 || found[0].ID != "1" || found[1].ID != "3" {
+		t.Fatalf("expected the playlists by name and ID but got %+v, %v", found, err)
+	}
+	if found, err := findPlaylists(ctx, p, nil, true); err != nil || len(found) != 3 {
+		t.Fatalf("expected every playlist but got %+v, %v", found, err)
+	}
+	if _, err := findPlaylists(ctx, p, []string{"Twice"}, false); err == nil {
+		t.Fatal("expected names of many playlists to be rejected")
+	}
+	if _, err := findPlaylists(ctx, p, []string{"Missing"}, false); !errors.Is(err, provider.ErrNotFound) {
+		t.Fatalf("expected a missing playlist to not be found but got %v", err)
+	}
+	if _, err := findPlaylists(ctx, p, nil, false); err == nil {
+		t.Fatal("expected playlists to be required")
+	}
+}
+
+func exportedPlaylists() []provider.FullPlaylist {
+	return []provider.FullPlaylist{{
+		Playlist: provider.Playlist{ID: "1", Name: "Playlist"},
+		Tracks: []provider.Track{
+			{ID: "a", Name: "Song", Artists: []string{"Artist", "Other"}, Duration: 3 * time.Second,
+				AddedAt: time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)},
+			{ID: "b", Name: "Video", Artists: []string{"Channel"}},
+		},
+	}}
+}
+
+func TestWriteJSONShouldOmitUnknownAddedAt(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeJSON(&out, exportedPlaylists()); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	exported := out.String()
+	if strings.Count(exported, `"addedAt"`) != 1 || !strings.Contains(exported, `"addedAt": "2023-04-05T06:07:08Z"`) {
+		t.Fatalf("expected only the known addedAt but got %s", exported)
+	}
+	if strings.Contains(exported, "0001-01-01") {
+		t.Fatalf("expected no zero times but got %s", exported)
+	}
+}
+
+func TestWriteCSVShouldWriteARowForEachTrack(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeCSV(&out, exportedPlaylists()); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	rows := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(rows) != 3 || !strings.HasPrefix(rows[0], "playlist,id,name") {
+		t.Fatalf("expected a header and a row for each track but got %q", rows)
+	}
+	if rows[1] != `Playlist,a,Song,"Artist, Other",,,3000,0,` {
+		t.Fatalf("unexpected row %q", rows[1])
+	}
+}
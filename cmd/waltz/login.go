@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/markbates/goth"
+	"github.com/paulombcosta/waltz/job"
+	"golang.org/x/oauth2"
+)
+
+type loginResult struct {
+	tokens *oauth2.Token
+	err    error
+}
+
+// login authorizes the app on the provider with a redirect to a server listening on the
+// loopback interface, so http://127.0.0.1:<port>/callback has to be a redirect URL of the
+// app. The browser can be on another machine, e.g. through an SSH tunnel to the port.
+func (c *cli) login(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	port := flags.Int("port", DEFAULT_LOGIN_PORT, "port of the loopback redirect")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: waltz login [-port port] <spotify|google>")
+	}
+	name := flags.Arg(0)
+	if name != PROVIDER_GOOGLE && name != PROVIDER_SPOTIFY {
+		return fmt.Errorf("invalid provider %s", name)
+	}
+	useProviders(*port)
+	p, err := goth.GetProvider(name)
+	if err != nil {
+		return err
+	}
+	state := job.NewID()
+	session, err := p.BeginAuth(state)
+	if err != nil {
+		return err
+	}
+	authURL, err := session.GetAuthURL()
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", *port))
+	if err != nil {
+		return err
+	}
+	results := make(chan loginResult, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		tokens, err := authorize(p, session, state, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintf(w, "Logged in on %s, you can close this page.\n", p.Name())
+		}
+		select {
+		case results <- loginResult{tokens: tokens, err: err}:
+		default:
+		}
+	})}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Close()
+
+	fmt.Printf("Open this link to log in on %s:\n\n%s\n\n", name, authURL)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case result := <-results:
+		if result.err != nil {
+			return result.err
+		}
+		if err := c.credentials.TokenProvider(c.user, name).Set(result.tokens); err != nil {
+			return err
+		}
+		fmt.Printf("Logged in, the tokens of %s were saved on %s\n", c.user, c.credentials.Path)
+		return nil
+	}
+}
+
+// authorize exchanges the code of the redirect for the tokens.
+func authorize(p goth.Provider, session goth.Session, state string, r *http.Request) (*oauth2.Token, error) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		return nil, fmt.Errorf("login failed: %s", query.Get("error"))
+	}
+	if query.Get("state") != state {
+		return nil, errors.New("login failed: the state doesn't match")
+	}
+	if _, err := session.Authorize(p, query); err != nil {
+		return nil, err
+	}
+	user, err := p.FetchUser(session)
+	if err != nil {
+		return nil, err
+	}
+	if user.RefreshToken == "" {
+		return nil, errors.New("login failed: no refresh token was granted")
+	}
+	return &oauth2.Token{AccessToken: user.AccessToken, RefreshToken: user.RefreshToken, Expiry: user.ExpiresAt}, nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/markbates/goth"
+)
+
+// fakeProvider logs in as user, its other methods aren't used by authorize.
+type fakeProvider struct {
+	goth.Provider
+	user goth.User
+}
+
+func (p fakeProvider) FetchUser(session goth.Session) (goth.User, error) {
+	return p.user, nil
+}
+
+type fakeSession struct{}
+
+func (s fakeSession) GetAuthURL() (string, error) {
+	return "", nil
+}
+
+func (s fakeSession) Marshal() string {
+	return ""
+}
+
+func (s fakeSession) Authorize(p goth.Provider, params goth.Params) (string, error) {
+	return "access", nil
+}
+
+func TestAuthorizeShouldReturnTheTokens(t *testing.T) {
+	p := fakeProvider{user: goth.User{AccessToken: "access", RefreshToken: "refresh"}}
+
+	tokens, err := authorize(p, fakeSession{}, "state", httptest.NewRequest("GET", "/callback?state=state&code=code", nil))
+	if err != nil || tokens.AccessToken != "access" || tokens.RefreshToken != "refresh" {
+		t.Fatalf("expected the tokens of the user but got %v, %v", tokens, err)
+	}
+}
+
+func TestAuthorizeShouldRejectFailedLogins(t *testing.T) {
+	p := fakeProvider{user: goth.User{AccessToken: "access", RefreshToken: "refresh"}}
+	urls := []string{
+		"/callback?state=state&error=access_denied",
+		"/callback?state=other&code=code",
+	}
+	for _, url := range urls {
+		if _, err := authorize(p, fakeSession{}, "state", httptest.NewRequest("GET", url, nil)); err == nil {
+			t.Fatalf("expected %s to be rejected", url)
+		}
+	}
+}
+
+func TestAuthorizeShouldRequireARefreshToken(t *testing.T) {
+	// e.g. when Google isn't asked for consent again
+	p := fakeProvider{user: goth.User{AccessToken: "access"}}
+
+	_, err := authorize(p, fakeSession{}, "state", httptest.NewRequest("GET", "/callback?state=state&code=code", nil))
+	if err == nil {
+		t.Fatal("expected logins without a refresh token to be rejected, the saved one would be lost")
+	}
+}
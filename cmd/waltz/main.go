@@ -0,0 +1,250 @@
+// Command waltz transfers and syncs playlists from the command line, e.g. on servers
+// without a browser. It logs in with a loopback redirect and keeps the tokens on a
+// credentials file.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+
+	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/google"
+	spotifyProvider "github.com/markbates/goth/providers/spotify"
+
+	"github.com/paulombcosta/waltz/cache"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/provider/spotify"
+	"github.com/paulombcosta/waltz/provider/youtube"
+	"github.com/paulombcosta/waltz/quota"
+	"github.com/paulombcosta/waltz/ratelimit"
+	"github.com/paulombcosta/waltz/store"
+	"github.com/paulombcosta/waltz/token"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+const (
+	PROVIDER_GOOGLE  = "google"
+	PROVIDER_SPOTIFY = "spotify"
+)
+
+// DEFAULT_LOGIN_PORT is the port of the loopback redirect, its URL has to be registered
+// on both apps, see login.
+const DEFAULT_LOGIN_PORT = 8089
+
+const usage = `Usage: waltz <command> [flags] [arguments]
+
+Commands:
+  login <spotify|google>      log in and save the tokens on the credentials file
+  playlists list              list the playlists of a provider
+  transfer [playlist...]      copy playlists to the other provider
+  sync [playlist...]          sync playlists, only copying what changed since the last sync
+  resume <job>                resume a paused or failed job
+  export [playlist...]        write playlists and their tracks as JSON or CSV
+
+Playlists are given by ID or name. Run "waltz <command> -h" for the flags of a command.
+
+Environment:
+  WALTZ_CREDENTIALS           credentials file, defaults to waltz/credentials.json in the config directory
+  WALTZ_CREDENTIALS_KEY       base64 key of 32 bytes the credentials file is encrypted with, made with
+                              "openssl rand -base64 32", required
+  WALTZ_USER                  whose tokens on the credentials file are used, defaults to "default"
+  WALTZ_DB                    database of jobs, syncs and matches, defaults to waltz/waltz.db in the config directory
+  SPOTIFY_ID, SPOTIFY_SECRET, GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET
+                              the credentials of the apps, as for the server
+`
+
+type cli struct {
+	credentials *token.CredentialsFile
+	user        string
+	dbPath      string
+	db          *store.Store
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	c, err := newCli()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer c.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	command, args := os.Args[1], os.Args[2:]
+	if command == "login" {
+		err = c.login(ctx, args)
+	} else if command == "playlists" && len(args) > 0 && args[0] == "list" {
+		err = c.listPlaylists(ctx, args[1:])
+	} else if command == "transfer" {
+		err = c.transfer(ctx, args)
+	} else if command == "sync" {
+		err = c.sync(ctx, args)
+	} else if command == "resume" {
+		err = c.resume(ctx, args)
+	} else if command == "export" {
+		err = c.export(ctx, args)
+	} else if command == "help" || command == "-h" || command == "--help" {
+		fmt.Print(usage)
+	} else {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, transfer.ErrPaused) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(3)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func newCli() (*cli, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	credentials := filepath.Join(configDir, "waltz", "credentials.json")
+	if value := os.Getenv("WALTZ_CREDENTIALS"); value != "" {
+		credentials = value
+	}
+	if os.Getenv("WALTZ_CREDENTIALS_KEY") == "" {
+		return nil, errors.New("WALTZ_CREDENTIALS_KEY is not set, make a key with `openssl rand -base64 32`")
+	}
+	key, err := token.ParseKey(os.Getenv("WALTZ_CREDENTIALS_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WALTZ_CREDENTIALS_KEY: %w", err)
+	}
+	c := &cli{user: token.DEFAULT_USER, dbPath: filepath.Join(configDir, "waltz", "waltz.db")}
+	c.credentials, err = token.NewCredentialsFile(credentials, key)
+	if err != nil {
+		return nil, err
+	}
+	if value := os.Getenv("WALTZ_USER"); value != "" {
+		c.user = value
+	}
+	if value := os.Getenv("WALTZ_DB"); value != "" {
+		c.dbPath = value
+	}
+	return c, nil
+}
+
+// useProviders sets up the OAuth apps, which are also used to refresh the tokens.
+func useProviders(port int) {
+	callback := fmt.Sprintf("http://127.0.0.1:%d/callback", port)
+	googleProvider := google.New(
+		os.Getenv("GOOGLE_CLIENT_ID"),
+		os.Getenv("GOOGLE_CLIENT_SECRET"),
+		callback, "email", "https://www.googleapis.com/auth/youtube")
+	// Google only grants a refresh token again when asked for consent
+	googleProvider.SetPrompt("consent")
+	goth.UseProviders(
+		googleProvider,
+		spotifyProvider.New(
+			os.Getenv("SPOTIFY_ID"),
+			os.Getenv("SPOTIFY_SECRET"),
+			callback,
+			"user-read-private", "playlist-read-private",
+			"playlist-modify-private", "playlist-modify-public"),
+	)
+}
+
+// store opens the database on the first use, it can't be shared with a running server.
+func (c *cli) store() (*store.Store, error) {
+	if c.db != nil {
+		return c.db, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.dbPath), 0700); err != nil {
+		return nil, err
+	}
+	db, err := store.Open(c.dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s, is a server or another command using it? %w", c.dbPath, err)
+	}
+	c.db = db
+	return db, nil
+}
+
+func (c *cli) close() {
+	if c.db != nil {
+		c.db.Close()
+	}
+}
+
+// provider returns the provider with the tokens of the credentials file.
+func (c *cli) provider(name string) (provider.Provider, error) {
+	useProviders(DEFAULT_LOGIN_PORT)
+	tokenProvider := c.credentials.TokenProvider(c.user, name)
+	tokens, err := tokenProvider.GetToken()
+	if err != nil {
+		return nil, err
+	}
+	if tokens == nil {
+		return nil, provider.NewError(provider.ErrUnauthorized,
+			fmt.Errorf("not logged in on %s, run: waltz login %s", name, name))
+	}
+	if name == PROVIDER_GOOGLE {
+		db, err := c.store()
+		if err != nil {
+			return nil, err
+		}
+		budget := quota.DEFAULT_BUDGET
+		if value := os.Getenv("YOUTUBE_QUOTA_BUDGET"); value != "" {
+			budget, err = strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid YOUTUBE_QUOTA_BUDGET: %w", err)
+			}
+		}
+		return youtube.New(tokenProvider).
+			WithQuotaMeter(quota.NewMeter(db, PROVIDER_GOOGLE, budget)).
+			WithRateLimiter(ratelimit.NewYoutubeLimiter()), nil
+	} else if name == PROVIDER_SPOTIFY {
+		return spotify.New(tokenProvider).WithRateLimiter(ratelimit.NewSpotifyLimiter()), nil
+	} else {
+		return nil, fmt.Errorf("invalid provider %s", name)
+	}
+}
+
+// jobProviders is the transfer.ProviderFactory of the command line.
+func (c *cli) jobProviders(j *job.Job) (provider.Provider, provider.Provider, error) {
+	origin, err := c.provider(j.Origin)
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := c.provider(j.Destination)
+	if err != nil {
+		return nil, nil, err
+	}
+	return origin, destination, nil
+}
+
+func (c *cli) runner(workers int) (*transfer.Runner, error) {
+	db, err := c.store()
+	if err != nil {
+		return nil, err
+	}
+	return transfer.NewRunner(job.NewStore(db), c.jobProviders, cache.New(db)).
+		WithWorkers(workers).
+		WithPlans(transfer.NewPlanStore(db)).
+		WithSyncs(transfer.NewSyncStore(db)).
+		WithTwoWaySyncs(transfer.NewTwoWayStore(db)).
+		WithHistory(transfer.NewRunStore(db)), nil
+}
+
+// publisher prints to the standard output, rewriting the progress line when it's a terminal.
+func publisher() *transfer.TerminalProgressPublisher {
+	interactive := false
+	if info, err := os.Stdout.Stat(); err == nil {
+		interactive = info.Mode()&os.ModeCharDevice != 0
+	}
+	return transfer.NewTerminalProgressPublisher(os.Stdout, interactive)
+}
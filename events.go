@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// errJobFinished is returned when following a job that finished and has no events left
+// to send, e.g. because they were published before the server restarted.
+var errJobFinished = errors.New("job finished")
+
+// startJob starts the transfer in the background, it keeps running when the request
+// that started it is gone and its progress is followed on the job events.
+func (a application) startJob(payload *TransferPayload, r *http.Request) (*job.Job, error) {
+	origin, err := a.getLoggedInProvider(payload.Origin, r)
+	if err != nil {
+		return nil, err
+	}
+	destination, err := a.getLoggedInProvider(payload.Destination, r)
+	if err != nil {
+		return nil, err
+	}
+	j := job.New(payload.Origin, payload.Destination, payload.ToProviderPlaylist())
+	j.Order = payload.Order
+	j.Mode = payload.Mode
+	j.RemoveMissing = payload.RemoveMissing
+	j.Policy = payload.Policy
+	j.Owner = a.sessionManager.GetUser(r)
+	if a.runner.Busy(j) {
+		return nil, fmt.Errorf("another job is syncing one of the playlists: %w", transfer.ErrAlreadyRunning)
+	}
+	// saved before it starts, so it can be followed right away
+	if err := a.jobs.Save(j); err != nil {
+		return nil, err
+	}
+	go func() {
+		err := a.runner.Start(context.Background(), j, origin, destination, transfer.LogProgressPublisher{JobID: j.ID})
+		if errors.Is(err, transfer.ErrAlreadyRunning) {
+			// another job took the playlists since it was checked, it never ran
+			j.Status = job.STATUS_FAILED
+			j.Error = err.Error()
+			if err := a.jobs.Save(j); err != nil {
+				log.Printf("failed to save job %s: %s", j.ID, err)
+			}
+			a.events.Publisher(j.ID).Fail(err)
+		}
+		if err != nil {
+			log.Printf("job %s stopped: %s", j.ID, err)
+		}
+	}()
+	return j, nil
+}
+
+// jobEventsHandler streams the progress of a job of the user with Server-Sent Events,
+// starting after the Last-Event-ID sent by clients that reconnect, or from its first
+// event kept.
+func (a application) jobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	id := j.ID
+	var lastEventID int64
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		lastEventID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %s", value), http.StatusBadRequest)
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		started = true
+	}
+	err = a.followJob(r.Context(), id, lastEventID, start, func(e transfer.BroadcastEvent) error {
+		data, err := json.Marshal(e.ProgressEvent)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if errors.Is(err, errJobFinished) && !started {
+		// tells clients to stop reconnecting
+		w.WriteHeader(http.StatusNoContent)
+	} else if err != nil && !errors.Is(err, errJobFinished) && !errors.Is(err, context.Canceled) {
+		log.Printf("stopped streaming job %s: %s", id, err)
+	}
+}
+
+// followJob sends the events of the job after lastEventID until one that ends it, ctx is
+// done or send fails. start is called before the first event, unless the job already
+// finished and there's nothing to send. Subscribers that fall behind subscribe again
+// from their last event.
+func (a application) followJob(ctx context.Context, id string, lastEventID int64, start func(), send func(e transfer.BroadcastEvent) error) error {
+	started := false
+	for {
+		missed, events, unsubscribe := a.events.Subscribe(id, lastEventID)
+		if len(missed) == 0 {
+			j, err := a.jobs.Get(id)
+			if err == nil && j.Finished() {
+				unsubscribe()
+				return errJobFinished
+			}
+		}
+		if !started {
+			start()
+			started = true
+		}
+		done, err := followEvents(ctx, missed, events, &lastEventID, send)
+		unsubscribe()
+		if done || err != nil {
+			return err
+		}
+	}
+}
+
+// followEvents sends the missed events and then the ones on the channel, until the
+// channel is closed. It returns true once an event that ends the job was sent.
+func followEvents(ctx context.Context, missed []transfer.BroadcastEvent, events <-chan transfer.BroadcastEvent, lastEventID *int64, send func(e transfer.BroadcastEvent) error) (bool, error) {
+	for i, e := range missed {
+		if err := send(e); err != nil {
+			return false, err
+		}
+		*lastEventID = e.ID
+		// jobs that failed can be resumed, so only the last event ends them
+		if i == len(missed)-1 && transfer.Final(e.ProgressEvent) {
+			return true, nil
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return false, nil
+			}
+			if err := send(e); err != nil {
+				return false, err
+			}
+			*lastEventID = e.ID
+			if transfer.Final(e.ProgressEvent) {
+				return true, nil
+			}
+		}
+	}
+}
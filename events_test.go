@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// eventIDs returns the IDs of the Server-Sent Events on the body.
+func eventIDs(t *testing.T, body string) []int64 {
+	ids := []int64{}
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, "id: "); ok {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				t.Fatalf("invalid event ID %s", value)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// publishedIDs returns the IDs of the events of the job kept by the broadcaster.
+func publishedIDs(a application, jobID string) []int64 {
+	missed, _, unsubscribe := a.events.Subscribe(jobID, 0)
+	unsubscribe()
+	ids := []int64{}
+	for _, e := range missed {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestJobEventsShouldReplayEventsAfterTheLastEventID(t *testing.T) {
+	a := newTestApplication(t)
+	j := saveJob(t, a, "alice", job.STATUS_RUNNING)
+	publisher := a.events.Publisher(j.ID)
+	_ = publisher.Publish(transfer.PROGRESS_STARTED_PLAYLSIT, "playlist")
+	_ = publisher.Publish(transfer.PROGRESS_PLAYLIST_DONE, "")
+	_ = publisher.Publish(transfer.PROGRESS_TRANSFER_DONE, "")
+	published := publishedIDs(a, j.ID)
+	cookies := loggedIn(t, a, "alice")
+
+	res, body := serve(a, httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID+"/events", nil), cookies)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream but got %d: %s", res.StatusCode, body)
+	}
+	if ids := eventIDs(t, body); len(ids) != 3 || ids[0] != published[0] || ids[2] != published[2] {
+		t.Fatalf("expected every event %v but got %v", published, ids)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID+"/events", nil)
+	r.Header.Set("Last-Event-ID", strconv.FormatInt(published[0], 10))
+	res, body = serve(a, r, cookies)
+	if ids := eventIDs(t, body); res.StatusCode != http.StatusOK || len(ids) != 2 || ids[0] != published[1] || ids[1] != published[2] {
+		t.Fatalf("expected the events after %d but got %d: %s", published[0], res.StatusCode, body)
+	}
+	if !strings.Contains(body, `"type":"`+transfer.PROGRESS_TRANSFER_DONE+`"`) {
+		t.Fatalf("expected the stream to end with the last event but got %s", body)
+	}
+}
+
+func TestJobEventsShouldEndWithNoContentOnFinishedJobs(t *testing.T) {
+	a := newTestApplication(t)
+	j := saveJob(t, a, "alice", job.STATUS_DONE)
+	_ = a.events.Publisher(j.ID).Publish(transfer.PROGRESS_TRANSFER_DONE, "")
+	last := publishedIDs(a, j.ID)[0]
+	cookies := loggedIn(t, a, "alice")
+
+	r := httptest.NewRequest(http.MethodGet, "/jobs/"+j.ID+"/events", nil)
+	r.Header.Set("Last-Event-ID", strconv.FormatInt(last, 10))
+	res, body := serve(a, r, cookies)
+	if res.StatusCode != http.StatusNoContent || body != "" {
+		t.Fatalf("expected no content after the last event but got %d: %s", res.StatusCode, body)
+	}
+
+	// e.g. after the server restarted and the events are gone
+	finished := saveJob(t, a, "alice", job.STATUS_CANCELLED)
+	res, body = serve(a, httptest.NewRequest(http.MethodGet, "/jobs/"+finished.ID+"/events", nil), cookies)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected no content without events but got %d: %s", res.StatusCode, body)
+	}
+}
+
+func TestJobEventsShouldNotFindUnknownJobs(t *testing.T) {
+	a := newTestApplication(t)
+	other := saveJob(t, a, "bob", job.STATUS_RUNNING)
+	_ = a.events.Publisher(other.ID).Publish(transfer.PROGRESS_TRANSFER_DONE, "")
+	cookies := loggedIn(t, a, "alice")
+
+	for _, id := range []string{"unknown", other.ID} {
+		res, body := serve(a, httptest.NewRequest(http.MethodGet, "/jobs/"+id+"/events", nil), cookies)
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected job %s to not be found but got %d: %s", id, res.StatusCode, body)
+		}
+	}
+}
+
+func TestFollowJobShouldSubscribeAgainAfterFallingBehind(t *testing.T) {
+	a := newTestApplication(t)
+	j := saveJob(t, a, "alice", job.STATUS_RUNNING)
+	publisher := a.events.Publisher(j.ID)
+	_ = publisher.Publish(transfer.PROGRESS_STARTED_PLAYLSIT, "playlist")
+
+	subscribed := make(chan bool)
+	release := make(chan bool)
+	go func() {
+		<-subscribed
+		// more than the subscriber can fall behind, while it's stuck on the first event
+		for i := 0; i < transfer.BROADCAST_BUFFER+10; i++ {
+			_ = publisher.Publish(transfer.PROGRESS_CACHE_HIT, "")
+		}
+		_ = publisher.Publish(transfer.PROGRESS_TRANSFER_DONE, "")
+		close(release)
+	}()
+	received := []int64{}
+	err := a.followJob(context.Background(), j.ID, 0, func() { close(subscribed) }, func(e transfer.BroadcastEvent) error {
+		if len(received) == 0 {
+			<-release
+		}
+		received = append(received, e.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	published := publishedIDs(a, j.ID)
+	if len(received) != len(published) {
+		t.Fatalf("expected all %d events but got %d", len(published), len(received))
+	}
+	for i := range published {
+		if received[i] != published[i] {
+			t.Fatalf("expected the events in order without gaps but got %v", received)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
@@ -31,10 +33,30 @@ type PlaylistsContent struct {
 type PageState struct {
 	LoggedInSpotify  bool
 	LoggedInYoutube  bool
+	Origin           string
+	OriginName       string
+	Destination      string
+	DestinationName  string
 	PlaylistsContent PlaylistsContent
 }
 
+const MESSAGE_CANCEL = "cancel"
+
 type TransferPayload struct {
+	// Type is empty for transfer requests, or MESSAGE_CANCEL to stop the running transfer
+	Type        string `json:"type,omitempty"`
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	// Order is either transfer.ORDER_APPEND or transfer.ORDER_MIRROR
+	Order string `json:"order,omitempty"`
+	// Mode is either transfer.MODE_TRANSFER, transfer.MODE_SYNC or transfer.MODE_TWO_WAY
+	Mode string `json:"mode,omitempty"`
+	// RemoveMissing removes the tracks removed from the origin, when syncing
+	RemoveMissing bool `json:"removeMissing,omitempty"`
+	// Policy resolves the conflicts of two-way syncs, see transfer.POLICY_SOURCE_WINS
+	Policy string `json:"policy,omitempty"`
+	// DryRun asks for the plan of the transfer instead of running it
+	DryRun    bool               `json:"dryRun,omitempty"`
 	Playlists []TransferPlaylist `json:"playlists"`
 }
 
@@ -64,43 +86,107 @@ func (a application) transferHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close()
 	publisher := transfer.NewWebSocketProgressPublisher(c)
-	for {
-		_, message, err := c.ReadMessage()
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		publisher.Error(err.Error())
+		return
+	}
+	payload, err := parseMessage(message)
+	if err != nil {
+		publisher.Error(err.Error())
+		return
+	}
+	if err := validatePayload(payload); err != nil {
+		publisher.Error(err.Error())
+		return
+	}
+
+	if payload.DryRun {
+		origin, err := a.getLoggedInProvider(payload.Origin, r)
 		if err != nil {
-			publisher.Error(err.Error())
-			break
+			publisher.Fail(err)
+			return
 		}
-		payload, err := parseMessage(message)
+		destination, err := a.getLoggedInProvider(payload.Destination, r)
 		if err != nil {
-			publisher.Error(err.Error())
-			break
-		}
-		if len(payload.Playlists) == 0 {
-			publisher.Error("failure: no playlists selected")
-			break
+			publisher.Fail(err)
+			return
 		}
-		origin, err := a.getProvider(PROVIDER_SPOTIFY, r, w)
-		if err != nil {
-			publisher.Error(err.Error())
-			break
+		ctx, cancel := context.WithCancel(r.Context())
+		go func() {
+			waitForCancel(c)
+			cancel()
+		}()
+		err = a.runner.Plan(ctx, payload.ToProviderPlaylist(), origin, destination, publisher)
+		cancel()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			publisher.Fail(err)
 		}
+		return
+	}
 
-		destination, err := a.getProvider(PROVIDER_GOOGLE, r, w)
-		if err != nil {
-			publisher.Error(err.Error())
-			break
+	j, err := a.startJob(payload, r)
+	if err != nil {
+		publisher.Fail(err)
+		return
+	}
+	_ = publisher.Publish(transfer.PROGRESS_JOB, j.ID)
+
+	// the job runs on its own, the socket only follows it and can cancel it, closing
+	// the socket leaves the job running
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		if !waitForCancel(c) {
+			cancel()
+		} else if err := a.cancelJob(j.ID); err != nil {
+			log.Printf("failed to cancel job %s: %s", j.ID, err)
 		}
+	}()
+	err = a.followJob(ctx, j.ID, 0, func() {}, func(e transfer.BroadcastEvent) error {
+		return publisher.PublishEvent(e.ProgressEvent)
+	})
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("stopped following job %s: %s", j.ID, err)
+	}
+}
 
-		err = transfer.Transfer().
-			Playlists(payload.ToProviderPlaylist()).
-			From(origin).
-			To(destination).
-			WithProgressPublisher(publisher).
-			Build().Start()
+// validatePayload checks the options of a transfer request.
+func validatePayload(payload *TransferPayload) error {
+	if len(payload.Playlists) == 0 {
+		return errors.New("failure: no playlists selected")
+	}
+	for _, name := range []string{payload.Origin, payload.Destination} {
+		if name != PROVIDER_GOOGLE && name != PROVIDER_SPOTIFY {
+			return fmt.Errorf("failure: invalid provider %s", name)
+		}
+	}
+	if payload.Origin == payload.Destination {
+		return errors.New("failure: origin and destination must be different providers")
+	}
+	if payload.Order != "" && payload.Order != transfer.ORDER_APPEND && payload.Order != transfer.ORDER_MIRROR {
+		return fmt.Errorf("failure: invalid order %s", payload.Order)
+	}
+	if payload.Mode != "" && payload.Mode != transfer.MODE_TRANSFER && payload.Mode != transfer.MODE_SYNC && payload.Mode != transfer.MODE_TWO_WAY {
+		return fmt.Errorf("failure: invalid mode %s", payload.Mode)
+	}
+	if payload.Policy != "" && payload.Policy != transfer.POLICY_SOURCE_WINS && payload.Policy != transfer.POLICY_UNION && payload.Policy != transfer.POLICY_MANUAL {
+		return fmt.Errorf("failure: invalid policy %s", payload.Policy)
+	}
+	return nil
+}
 
+// waitForCancel reads the socket until a cancel message arrives, returning true, or
+// until the socket closes.
+func waitForCancel(c *websocket.Conn) bool {
+	for {
+		_, message, err := c.ReadMessage()
 		if err != nil {
-			publisher.Error(err.Error())
-			break
+			return false
+		}
+		payload, err := parseMessage(message)
+		if err == nil && payload.Type == MESSAGE_CANCEL {
+			return true
 		}
 	}
 }
@@ -115,16 +201,53 @@ func parseMessage(payload []byte) (*TransferPayload, error) {
 }
 
 func (a application) getProvider(name string, r *http.Request, w http.ResponseWriter) (provider.Provider, error) {
-	tokenProvider := token.New(name, r, w, a.sessionManager)
+	return a.newProvider(name, token.New(name, r, w, a.sessionManager))
+}
+
+func (a application) newProvider(name string, tokenProvider provider.TokenProvider) (provider.Provider, error) {
 	if name == PROVIDER_GOOGLE {
-		return youtube.New(tokenProvider), nil
+		return youtube.New(tokenProvider).
+			WithQuotaMeter(a.youtubeQuota).
+			WithRateLimiter(a.limiters[PROVIDER_GOOGLE]), nil
 	} else if name == PROVIDER_SPOTIFY {
-		return spotify.New(tokenProvider), nil
+		return spotify.New(tokenProvider).WithRateLimiter(a.limiters[PROVIDER_SPOTIFY]), nil
 	} else {
 		return nil, fmt.Errorf("invalid provider %s", name)
 	}
 }
 
+// getLoggedInProvider returns a provider using the tokens the user of the session saved
+// when logging in, so it keeps working after the request is done, e.g. for transfers that
+// resume on the next day, and the tokens it refreshes are saved back.
+func (a application) getLoggedInProvider(name string, r *http.Request) (provider.Provider, error) {
+	if name != PROVIDER_GOOGLE && name != PROVIDER_SPOTIFY {
+		return nil, fmt.Errorf("invalid provider %s", name)
+	}
+	user := a.sessionManager.GetUser(r)
+	if user == "" {
+		return nil, provider.NewError(provider.ErrUnauthorized, fmt.Errorf("not logged in on %s", name))
+	}
+	p, err := a.newProvider(name, a.tokens.TokenProvider(user, name))
+	if err != nil {
+		return nil, err
+	}
+	if !p.IsLoggedIn() {
+		return nil, provider.NewError(provider.ErrUnauthorized, fmt.Errorf("not logged in on %s", p.Name()))
+	}
+	return p, nil
+}
+
+// otherProvider returns the provider on the opposite end of a transfer.
+func otherProvider(name string) (string, error) {
+	if name == PROVIDER_GOOGLE {
+		return PROVIDER_SPOTIFY, nil
+	} else if name == PROVIDER_SPOTIFY {
+		return PROVIDER_GOOGLE, nil
+	} else {
+		return "", fmt.Errorf("invalid provider %s", name)
+	}
+}
+
 func (a application) homepageHandler(w http.ResponseWriter, r *http.Request) {
 	pageState := PageState{
 		LoggedInSpotify:  false,
@@ -151,7 +274,26 @@ func (a application) homepageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if pageState.LoggedInSpotify && pageState.LoggedInYoutube {
-		playlists, err := spotifyProvider.GetPlaylists()
+		origin := r.URL.Query().Get("source")
+		if origin == "" {
+			origin = PROVIDER_SPOTIFY
+		}
+		destination, err := otherProvider(origin)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		originProvider := spotifyProvider
+		destinationProvider := youtubeProvider
+		if origin == PROVIDER_GOOGLE {
+			originProvider, destinationProvider = youtubeProvider, spotifyProvider
+		}
+		pageState.Origin = origin
+		pageState.OriginName = originProvider.Name()
+		pageState.Destination = destination
+		pageState.DestinationName = destinationProvider.Name()
+
+		playlists, err := originProvider.GetPlaylists(r.Context())
 		var content PlaylistsContent
 		if err != nil {
 			content = PlaylistsContent{
@@ -208,12 +350,40 @@ func (a application) authCallbackHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	tokens := oauth2.Token{AccessToken: user.AccessToken, RefreshToken: user.RefreshToken}
+	// the account of the first login identifies the user, logging in on the other
+	// provider adds it to the same user
+	owner := a.sessionManager.GetUser(r)
+	if owner == "" {
+		owner = user.Provider + ":" + user.UserID
+		if err := a.sessionManager.SetUser(owner, r, w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	stored := a.tokens.TokenProvider(owner, provider)
+	tokens := oauth2.Token{AccessToken: user.AccessToken, RefreshToken: user.RefreshToken, Expiry: user.ExpiresAt}
+	if tokens.RefreshToken == "" {
+		// logging in again doesn't always grant a refresh token, the saved one still works
+		saved, err := stored.GetToken()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if saved != nil {
+			tokens.RefreshToken = saved.RefreshToken
+		}
+	}
 	err = a.sessionManager.UpdateTokens(provider, &tokens, r, w)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// scheduled syncs run without a session
+	err = stored.Set(&tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
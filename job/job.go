@@ -0,0 +1,238 @@
+package job
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const JOBS_BUCKET = "jobs"
+
+const (
+	STATUS_RUNNING   = "running"
+	STATUS_PAUSED    = "paused"
+	STATUS_DONE      = "done"
+	STATUS_FAILED    = "failed"
+	STATUS_CANCELLED = "cancelled"
+)
+
+const (
+	TRACK_PENDING = "pending"
+	TRACK_MATCHED = "matched"
+	TRACK_ADDED   = "added"
+	TRACK_FAILED  = "failed"
+	TRACK_SKIPPED = "skipped"
+)
+
+// Review states of tracks without a match, or with a match below match.REVIEW_THRESHOLD.
+const (
+	REVIEW_PENDING = "pending"
+	// REVIEW_RESOLVED tracks have a resolution that wasn't applied yet
+	REVIEW_RESOLVED = "resolved"
+	REVIEW_IGNORED  = "ignored"
+	REVIEW_APPLIED  = "applied"
+)
+
+// RESOLUTION_IGNORE is the resolution of tracks to review that are left as they are
+const RESOLUTION_IGNORE = "ignore"
+
+var ErrNotFound = errors.New("job not found")
+
+// Job is a transfer that is saved after every operation, so it can be resumed
+// from where it stopped, e.g. on the next day when the quota is exhausted.
+type Job struct {
+	ID          string `json:"id"`
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	// Order is how new tracks are placed on the destination, see transfer.ORDER_APPEND
+	Order string `json:"order,omitempty"`
+	// Mode is whether the playlists are copied or synced, see transfer.MODE_TRANSFER
+	Mode string `json:"mode,omitempty"`
+	// RemoveMissing removes the tracks removed from the origin, when syncing
+	RemoveMissing bool `json:"removeMissing,omitempty"`
+	// Policy resolves the conflicts of two-way syncs, see transfer.POLICY_SOURCE_WINS
+	Policy string `json:"policy,omitempty"`
+	// Scheduled is set on the jobs started by the sync schedule
+	Scheduled bool `json:"scheduled,omitempty"`
+	// Owner is the user who started the job, whose saved tokens it runs with when the
+	// tokens it was started with are gone
+	Owner  string `json:"owner,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// ErrorCode identifies the kind of error, see provider.Code
+	ErrorCode string     `json:"errorCode,omitempty"`
+	ResumeAt  time.Time  `json:"resumeAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Playlists []Playlist `json:"playlists"`
+}
+
+type Playlist struct {
+	provider.Playlist
+	DestinationID string `json:"destinationId,omitempty"`
+	// Loaded is set once the tracks were read from the origin
+	Loaded bool    `json:"loaded"`
+	Tracks []Track `json:"tracks"`
+	// Removed is how many tracks were removed from the destination, when syncing
+	Removed int `json:"removed,omitempty"`
+}
+
+type Track struct {
+	provider.Track
+	State         string `json:"state"`
+	DestinationID string `json:"destinationId,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	// Score is how confident the match is, from 0 to 1
+	Score float64 `json:"score,omitempty"`
+	// Review is set on the tracks to review, see REVIEW_PENDING
+	Review string `json:"review,omitempty"`
+	// Candidates are the best search results on the destination, for the tracks to review
+	Candidates []Candidate `json:"candidates,omitempty"`
+	// Resolution is the destination track chosen when reviewing
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// Resolve sets the destination track chosen for a track to review, or ignores it.
+func (t *Track) Resolve(resolution string) {
+	if resolution == RESOLUTION_IGNORE {
+		t.Review = REVIEW_IGNORED
+		t.Resolution = ""
+	} else {
+		t.Review = REVIEW_RESOLVED
+		t.Resolution = resolution
+	}
+}
+
+type Candidate struct {
+	provider.Track
+	Score float64 `json:"score"`
+}
+
+func New(origin string, destination string, playlists []provider.Playlist) *Job {
+	j := &Job{
+		ID:          NewID(),
+		Origin:      origin,
+		Destination: destination,
+		Status:      STATUS_RUNNING,
+		CreatedAt:   time.Now(),
+		Playlists:   []Playlist{},
+	}
+	for _, p := range playlists {
+		j.Playlists = append(j.Playlists, Playlist{Playlist: p, Tracks: []Track{}})
+	}
+	return j
+}
+
+// Finished is true when the job won't run again.
+func (j Job) Finished() bool {
+	return j.Status == STATUS_DONE || j.Status == STATUS_FAILED || j.Status == STATUS_CANCELLED
+}
+
+// Count returns how many tracks are in the given state.
+func (j Job) Count(state string) int {
+	count := 0
+	for _, p := range j.Playlists {
+		for _, t := range p.Tracks {
+			if t.State == state {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Reviews counts the tracks in the given review state.
+func (j Job) Reviews(state string) int {
+	count := 0
+	for _, p := range j.Playlists {
+		for _, t := range p.Tracks {
+			if t.Review == state {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// Removed is how many tracks were removed from the destination.
+func (j Job) Removed() int {
+	removed := 0
+	for _, p := range j.Playlists {
+		removed += p.Removed
+	}
+	return removed
+}
+
+// Total is the number of tracks read from the origin so far.
+func (j Job) Total() int {
+	total := 0
+	for _, p := range j.Playlists {
+		total += len(p.Tracks)
+	}
+	return total
+}
+
+// NewID returns a random identifier, for jobs and anything else that needs one.
+func NewID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type Repository interface {
+	Save(j *Job) error
+	Get(id string) (*Job, error)
+	List() ([]Job, error)
+}
+
+func NewStore(s *store.Store) Store {
+	return Store{store: s}
+}
+
+// Store saves jobs on the application database.
+type Store struct {
+	store *store.Store
+}
+
+func (s Store) Save(j *Job) error {
+	j.UpdatedAt = time.Now()
+	return s.store.Put(JOBS_BUCKET, j.ID, j)
+}
+
+func (s Store) Get(id string) (*Job, error) {
+	var j Job
+	found, err := s.store.Get(JOBS_BUCKET, id, &j)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &j, nil
+}
+
+// List returns every job, the most recent first.
+func (s Store) List() ([]Job, error) {
+	jobs := []Job{}
+	err := s.store.ForEach(JOBS_BUCKET, func(key string, value []byte) error {
+		var j Job
+		if err := json.Unmarshal(value, &j); err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		return jobs[a].CreatedAt.After(jobs[b].CreatedAt)
+	})
+	return jobs, nil
+}
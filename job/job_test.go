@@ -0,0 +1,67 @@
+package job
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+func newStore(t *testing.T) Store {
+	s, err := store.Open(filepath.Join(t.TempDir(), "waltz.db"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return NewStore(s)
+}
+
+func TestStoreSavesJobProgress(t *testing.T) {
+	jobs := newStore(t)
+	j := New("spotify", "google", []provider.Playlist{{ID: "1", Name: "playlist"}})
+	j.Playlists[0].Tracks = []Track{
+		{Track: provider.Track{Name: "Song"}, State: TRACK_ADDED, DestinationID: "video"},
+		{Track: provider.Track{Name: "Other"}, State: TRACK_PENDING},
+	}
+	if err := jobs.Save(j); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	stored, err := jobs.Get(j.ID)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if stored.Count(TRACK_ADDED) != 1 || stored.Count(TRACK_PENDING) != 1 || stored.Total() != 2 {
+		t.Fatalf("unexpected tracks %+v", stored.Playlists[0].Tracks)
+	}
+	if stored.Playlists[0].Tracks[0].DestinationID != "video" {
+		t.Fatalf("expected destination id to be saved")
+	}
+}
+
+func TestStoreGetUnknownJob(t *testing.T) {
+	_, err := newStore(t).Get("unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found but got %v", err)
+	}
+}
+
+func TestStoreListsMostRecentFirst(t *testing.T) {
+	jobs := newStore(t)
+	older := New("spotify", "google", nil)
+	older.CreatedAt = time.Now().Add(-time.Hour)
+	newer := New("google", "spotify", nil)
+	_ = jobs.Save(older)
+	_ = jobs.Save(newer)
+
+	list, err := jobs.List()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(list) != 2 || list[0].ID != newer.ID || list[1].ID != older.ID {
+		t.Fatalf("unexpected order %+v", list)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// jobProviders builds the providers of a job from the tokens its owner saved when logging
+// in, so jobs resume and scheduled syncs run while nobody is logged in.
+func (a application) jobProviders(j *job.Job) (provider.Provider, provider.Provider, error) {
+	providers := []provider.Provider{}
+	for _, name := range []string{j.Origin, j.Destination} {
+		stored := a.tokens.TokenProvider(j.Owner, name)
+		tokens, err := stored.GetToken()
+		if err != nil {
+			return nil, nil, err
+		}
+		if j.Owner == "" || tokens == nil {
+			return nil, nil, provider.NewError(provider.ErrUnauthorized,
+				fmt.Errorf("no credentials for %s, log in and resume the job from the jobs page", name))
+		}
+		p, err := a.newProvider(name, stored)
+		if err != nil {
+			return nil, nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers[0], providers[1], nil
+}
+
+type JobsPageState struct {
+	Jobs []job.Job
+	Now  time.Time
+}
+
+func (a application) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	jobs, err := a.ownedJobs(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl := template.Must(loadPage("jobs"))
+	err = tmpl.Execute(w, JobsPageState{Jobs: jobs, Now: time.Now()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (a application) cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	err = a.cancelJob(j.ID)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/jobs", http.StatusSeeOther)
+}
+
+// cancelJob cancels the job unless it already finished, a running transfer notices it
+// on its next checkpoint and stops.
+func (a application) cancelJob(id string) error {
+	j, err := a.jobs.Get(id)
+	if err != nil {
+		return err
+	}
+	if j.Finished() {
+		return nil
+	}
+	paused := j.Status == job.STATUS_PAUSED
+	j.Status = job.STATUS_CANCELLED
+	if err := a.jobs.Save(j); err != nil {
+		return err
+	}
+	if paused {
+		// nothing is running to tell who follows the job
+		_ = a.events.Publisher(j.ID).Publish(transfer.PROGRESS_CANCELLED, "")
+	}
+	return nil
+}
+
+// resumeJobHandler resumes a paused or failed job with the credentials of the current session.
+func (a application) resumeJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	if j.Status != job.STATUS_PAUSED && j.Status != job.STATUS_FAILED {
+		http.Error(w, fmt.Sprintf("cannot resume a %s job", j.Status), http.StatusConflict)
+		return
+	}
+	for _, name := range []string{j.Origin, j.Destination} {
+		if _, err := a.getLoggedInProvider(name, r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	j.Status = job.STATUS_PAUSED
+	j.ResumeAt = time.Now()
+	err = a.jobs.Save(j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	go a.runner.ResumeDue(context.Background())
+	http.Redirect(w, r, "/jobs", http.StatusSeeOther)
+}
+
+// ownedJobs returns the jobs of the user of the session, the most recent first.
+func (a application) ownedJobs(r *http.Request) ([]job.Job, error) {
+	jobs, err := a.jobs.List()
+	if err != nil {
+		return nil, err
+	}
+	user := a.sessionManager.GetUser(r)
+	owned := []job.Job{}
+	for _, j := range jobs {
+		if user != "" && j.Owner == user {
+			owned = append(owned, j)
+		}
+	}
+	return owned, nil
+}
+
+// getOwnedJob returns the job of the request as long as the user of the session owns it,
+// since it runs with their saved tokens. The jobs of other users aren't found.
+func (a application) getOwnedJob(r *http.Request) (*job.Job, error) {
+	j, err := a.jobs.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, err
+	}
+	if user := a.sessionManager.GetUser(r); user == "" || j.Owner != user {
+		return nil, job.ErrNotFound
+	}
+	return j, nil
+}
+
+func jobError(w http.ResponseWriter, err error) {
+	if errors.Is(err, job.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulombcosta/waltz/job"
+)
+
+func TestJobsPageShouldOnlyListTheJobsOfTheUser(t *testing.T) {
+	a := newTestApplication(t)
+	own := saveJob(t, a, "alice", job.STATUS_PAUSED)
+	other := saveJob(t, a, "bob", job.STATUS_PAUSED)
+
+	res, body := serve(a, httptest.NewRequest(http.MethodGet, "/jobs", nil), loggedIn(t, a, "alice"))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the jobs page but got %d: %s", res.StatusCode, body)
+	}
+	if !strings.Contains(body, own.ID) || strings.Contains(body, other.ID) {
+		t.Fatalf("expected only the jobs of the user to be listed but got %s", body)
+	}
+
+	_, body = serve(a, httptest.NewRequest(http.MethodGet, "/jobs", nil), nil)
+	if strings.Contains(body, own.ID) || strings.Contains(body, other.ID) {
+		t.Fatalf("expected no jobs to be listed without logging in but got %s", body)
+	}
+}
+
+func TestJobsOfOtherUsersShouldNotBeFound(t *testing.T) {
+	a := newTestApplication(t)
+	other := saveJob(t, a, "bob", job.STATUS_PAUSED)
+	cookies := loggedIn(t, a, "alice")
+
+	for _, path := range []string{"/jobs/" + other.ID + "/cancel", "/jobs/" + other.ID + "/resume"} {
+		res, body := serve(a, httptest.NewRequest(http.MethodPost, path, nil), cookies)
+		if res.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected %s to not be found but got %d: %s", path, res.StatusCode, body)
+		}
+	}
+	j, err := a.jobs.Get(other.ID)
+	if err != nil || j.Status != job.STATUS_PAUSED {
+		t.Fatalf("expected the job to be left as it was but got %+v, %v", j, err)
+	}
+}
+
+func TestUsersShouldCancelTheirJobs(t *testing.T) {
+	a := newTestApplication(t)
+	own := saveJob(t, a, "alice", job.STATUS_PAUSED)
+
+	res, body := serve(a, httptest.NewRequest(http.MethodPost, "/jobs/"+own.ID+"/cancel", nil), loggedIn(t, a, "alice"))
+	if res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected a redirect to the jobs page but got %d: %s", res.StatusCode, body)
+	}
+	j, err := a.jobs.Get(own.ID)
+	if err != nil || j.Status != job.STATUS_CANCELLED {
+		t.Fatalf("expected the job to be cancelled but got %+v, %v", j, err)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 
@@ -13,44 +16,187 @@ import (
 	"github.com/markbates/goth/providers/google"
 	spotifyProvider "github.com/markbates/goth/providers/spotify"
 
+	"github.com/paulombcosta/waltz/cache"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/quota"
+	"github.com/paulombcosta/waltz/ratelimit"
+	"github.com/paulombcosta/waltz/schedule"
 	"github.com/paulombcosta/waltz/session"
+	"github.com/paulombcosta/waltz/store"
+	"github.com/paulombcosta/waltz/token"
+	"github.com/paulombcosta/waltz/transfer"
 	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 )
 
 type application struct {
 	sessionManager session.SessionManager
+	store          *store.Store
+	youtubeQuota   *quota.Meter
+	// limiters are shared by every provider of the same API, to respect its rate limit
+	limiters map[string]*rate.Limiter
+	jobs     job.Store
+	plans    transfer.PlanStore
+	syncs    transfer.SyncStore
+	twoWays  transfer.TwoWayStore
+	runs     transfer.RunStore
+	events   *transfer.Broadcaster
+	// tokens has the tokens of each user, so their jobs and scheduled syncs run while
+	// they aren't logged in
+	tokens    *token.CredentialsFile
+	runner    *transfer.Runner
+	scheduler *transfer.Scheduler
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	googleProvider := google.New(
+		os.Getenv("GOOGLE_CLIENT_ID"),
+		os.Getenv("GOOGLE_CLIENT_SECRET"),
+		"http://localhost:8080/auth/callback?provider=google", "email", "https://www.googleapis.com/auth/youtube")
+	// Google only grants a refresh token again when asked for consent
+	googleProvider.SetPrompt("consent")
 	goth.UseProviders(
-		google.New(
-			os.Getenv("GOOGLE_CLIENT_ID"),
-			os.Getenv("GOOGLE_CLIENT_SECRET"),
-			"http://localhost:8080/auth/callback?provider=google", "email", "https://www.googleapis.com/auth/youtube"),
+		googleProvider,
 		spotifyProvider.New(
 			os.Getenv("SPOTIFY_ID"),
 			os.Getenv("SPOTIFY_SECRET"),
 			"http://localhost:8080/auth/callback?provider=spotify",
-			"user-read-private", "playlist-read-private"),
+			"user-read-private", "playlist-read-private",
+			"playlist-modify-private", "playlist-modify-public"),
 	)
 
-	router := chi.NewRouter()
+	dbPath := os.Getenv("WALTZ_DB")
+	if dbPath == "" {
+		dbPath = "waltz.db"
+	}
+	db, err := store.Open(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	if err := token.DropPlainTokens(db); err != nil {
+		log.Fatal(err)
+	}
 
-	fileServer := http.FileServer(http.Dir("./ui/static"))
+	credentialsPath := os.Getenv("WALTZ_CREDENTIALS")
+	if credentialsPath == "" {
+		credentialsPath = "credentials.json"
+	}
+	if os.Getenv("WALTZ_CREDENTIALS_KEY") == "" {
+		log.Fatal("WALTZ_CREDENTIALS_KEY is not set, make a key with `openssl rand -base64 32`")
+	}
+	credentialsKey, err := token.ParseKey(os.Getenv("WALTZ_CREDENTIALS_KEY"))
+	if err != nil {
+		log.Fatalf("invalid WALTZ_CREDENTIALS_KEY: %s", err)
+	}
+	tokens, err := token.NewCredentialsFile(credentialsPath, credentialsKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	budget := quota.DEFAULT_BUDGET
+	if value := os.Getenv("YOUTUBE_QUOTA_BUDGET"); value != "" {
+		budget, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("invalid YOUTUBE_QUOTA_BUDGET: %s", err)
+		}
+	}
 
-	sessionManager := session.New()
+	workers := transfer.DEFAULT_WORKERS
+	if value := os.Getenv("WALTZ_WORKERS"); value != "" {
+		workers, err = strconv.Atoi(value)
+		if err != nil {
+			log.Fatalf("invalid WALTZ_WORKERS: %s", err)
+		}
+	}
+
+	sessionKey, err := token.ParseKey(os.Getenv("WALTZ_SESSION_KEY"))
+	if err != nil {
+		log.Fatalf("invalid WALTZ_SESSION_KEY, make one with `openssl rand -base64 32`: %s", err)
+	}
+	sessionEncryptionKey, err := token.ParseKey(os.Getenv("WALTZ_SESSION_ENCRYPTION_KEY"))
+	if err != nil {
+		log.Fatalf("invalid WALTZ_SESSION_ENCRYPTION_KEY, make one with `openssl rand -base64 32`: %s", err)
+	}
+	sessionManager, err := session.New(sessionKey, sessionEncryptionKey)
+	if err != nil {
+		log.Fatal(err)
+	}
 	app := application{
 		sessionManager: sessionManager,
+		store:          db,
+		youtubeQuota:   quota.NewMeter(db, PROVIDER_GOOGLE, budget),
+		limiters: map[string]*rate.Limiter{
+			PROVIDER_GOOGLE:  ratelimit.NewYoutubeLimiter(),
+			PROVIDER_SPOTIFY: ratelimit.NewSpotifyLimiter(),
+		},
+		jobs:    job.NewStore(db),
+		plans:   transfer.NewPlanStore(db),
+		syncs:   transfer.NewSyncStore(db),
+		twoWays: transfer.NewTwoWayStore(db),
+		runs:    transfer.NewRunStore(db),
+		events:  transfer.NewBroadcaster(),
+		tokens:  tokens,
+	}
+	app.runner = transfer.NewRunner(app.jobs, app.jobProviders, cache.New(db)).
+		WithWorkers(workers).
+		WithPlans(app.plans).
+		WithSyncs(app.syncs).
+		WithTwoWaySyncs(app.twoWays).
+		WithHistory(app.runs).
+		WithBroadcaster(app.events)
+	go app.runner.Run(context.Background())
+
+	syncSchedule := schedule.DEFAULT_SCHEDULE
+	if value := os.Getenv("WALTZ_SYNC_SCHEDULE"); value != "" {
+		syncSchedule = value
+	}
+	timezone := schedule.DEFAULT_TIMEZONE
+	if value := os.Getenv("WALTZ_SYNC_TIMEZONE"); value != "" {
+		timezone = value
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Fatalf("invalid WALTZ_SYNC_TIMEZONE: %s", err)
+	}
+	parsedSchedule, err := schedule.Parse(syncSchedule, location)
+	if err != nil {
+		log.Fatalf("invalid WALTZ_SYNC_SCHEDULE: %s", err)
 	}
+	app.scheduler = transfer.NewScheduler(app.runner, app.syncs, parsedSchedule)
+	go app.scheduler.Run(context.Background())
 
-	router.Get("/", http.HandlerFunc(app.homepageHandler))
+	log.Println("starting server on :8080")
+	log.Panic(http.ListenAndServe(":8080", app.routes()))
+}
+
+// routes returns the router of the pages, the API and the static files.
+func (a application) routes() http.Handler {
+	router := chi.NewRouter()
+	fileServer := http.FileServer(http.Dir("./ui/static"))
+
+	router.Get("/", http.HandlerFunc(a.homepageHandler))
 	router.Get("/auth", gothic.BeginAuthHandler)
-	router.Handle("/auth/callback", http.HandlerFunc(app.authCallbackHandler))
+	router.Handle("/auth/callback", http.HandlerFunc(a.authCallbackHandler))
 	router.Handle("/static/*", http.StripPrefix("/static", fileServer))
-	router.HandleFunc("/transfer", http.HandlerFunc(app.transferHandler))
-	log.Println("starting server on :8080")
-	log.Panic(http.ListenAndServe(":8080", router))
+	router.HandleFunc("/transfer", http.HandlerFunc(a.transferHandler))
+	router.Get("/plans/{id}", http.HandlerFunc(a.planHandler))
+	router.Get("/jobs", http.HandlerFunc(a.jobsHandler))
+	router.Get("/syncs", http.HandlerFunc(a.syncsHandler))
+	router.Post("/syncs/{origin}/{destination}/{playlist}/schedule", http.HandlerFunc(a.scheduleSyncHandler))
+	router.Post("/syncs/{origin}/{destination}/{playlist}/run", http.HandlerFunc(a.runSyncHandler))
+	router.Post("/two-way-syncs/{a}/{b}/{playlist}/conflicts", http.HandlerFunc(a.resolveConflictHandler))
+	// kept for the clients written before the API, its responses still have the job ID
+	router.Post("/jobs", http.HandlerFunc(a.apiCreateJobHandler))
+	router.Get("/jobs/{id}/events", http.HandlerFunc(a.jobEventsHandler))
+	router.Post("/jobs/{id}/cancel", http.HandlerFunc(a.cancelJobHandler))
+	router.Post("/jobs/{id}/resume", http.HandlerFunc(a.resumeJobHandler))
+	router.Get("/jobs/{id}/review", http.HandlerFunc(a.reviewHandler))
+	router.Post("/jobs/{id}/review", http.HandlerFunc(a.saveReviewHandler))
+	router.Post("/jobs/{id}/review/apply", http.HandlerFunc(a.applyReviewHandler))
+	router.Mount("/api/"+API_VERSION, a.apiRouter())
+	return router
 }
 
 func init() {
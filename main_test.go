@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/session"
+	"github.com/paulombcosta/waltz/store"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+func newTestApplication(t *testing.T) application {
+	db, err := store.Open(filepath.Join(t.TempDir(), "waltz.db"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sessionManager, err := session.New(bytes.Repeat([]byte("a"), session.KEY_SIZE), bytes.Repeat([]byte("e"), session.KEY_SIZE))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return application{
+		sessionManager: sessionManager,
+		store:          db,
+		jobs:           job.NewStore(db),
+		plans:          transfer.NewPlanStore(db),
+		syncs:          transfer.NewSyncStore(db),
+		twoWays:        transfer.NewTwoWayStore(db),
+		runs:           transfer.NewRunStore(db),
+		events:         transfer.NewBroadcaster(),
+	}
+}
+
+// saveJob saves a job of the owner with a playlist named after it.
+func saveJob(t *testing.T, a application, owner string, status string) *job.Job {
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: owner + "'s playlist"}})
+	j.Owner = owner
+	j.Status = status
+	if err := a.jobs.Save(j); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return j
+}
+
+// loggedIn returns the cookies of a session the user logged in on.
+func loggedIn(t *testing.T, a application, user string) []*http.Cookie {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	if err := a.sessionManager.SetUser(user, r, w); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	return w.Result().Cookies()
+}
+
+// serve sends the request to the routes of the application with the cookies.
+func serve(a application, r *http.Request, cookies []*http.Cookie) (*http.Response, string) {
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	a.routes().ServeHTTP(w, r)
+	body, _ := io.ReadAll(w.Result().Body)
+	return w.Result(), string(body)
+}
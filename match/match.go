@@ -0,0 +1,214 @@
+package match
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulombcosta/waltz/normalize"
+	"github.com/paulombcosta/waltz/provider"
+)
+
+const (
+	DEFAULT_CANDIDATES = 5
+	DEFAULT_THRESHOLD  = 0.6
+	// REVIEW_THRESHOLD is the score below which matches are accepted but listed for review
+	REVIEW_THRESHOLD = 0.75
+)
+
+const (
+	titleWeight    = 0.55
+	artistWeight   = 0.3
+	durationWeight = 0.15
+	// officialBonus is added on top of the weighted score for uploads that look official
+	officialBonus = 0.1
+	// versionPenalty multiplies the score of candidates that are a different version of the song
+	versionPenalty = 0.5
+)
+
+// versionWords mark alternative versions that should only match when the source has them too.
+var versionWords = []string{
+	"live", "cover", "remix", "karaoke", "instrumental", "acoustic",
+	"nightcore", "slowed", "sped", "reverb", "8d", "mashup",
+}
+
+type Result struct {
+	Track   provider.Track
+	Score   float64
+	Matched bool
+	// Candidates are the scored search results, the best first
+	Candidates []Result
+}
+
+// LowConfidence is true for results that should be reviewed, either without a match or
+// with one below REVIEW_THRESHOLD.
+func (r Result) LowConfidence() bool {
+	return !r.Matched || r.Score < REVIEW_THRESHOLD
+}
+
+type Matcher interface {
+	Match(ctx context.Context, destination provider.Provider, track provider.Track) (Result, error)
+}
+
+type ScoringMatcher struct {
+	// Candidates is how many search results are scored for every track
+	Candidates int
+	// Threshold is the minimum score, from 0 to 1, needed to accept a candidate
+	Threshold float64
+}
+
+func New() ScoringMatcher {
+	return ScoringMatcher{Candidates: DEFAULT_CANDIDATES, Threshold: DEFAULT_THRESHOLD}
+}
+
+// Match searches the destination for the track and returns the best scored candidate.
+// The result is only marked as matched when the score reaches the threshold, the best
+// candidate is still returned otherwise so it can be reported.
+func (m ScoringMatcher) Match(ctx context.Context, destination provider.Provider, track provider.Track) (Result, error) {
+	candidates, err := destination.SearchTracks(ctx, track.FullName(), m.Candidates)
+	if err != nil {
+		return Result{}, err
+	}
+	scored := []Result{}
+	for _, c := range candidates {
+		scored = append(scored, Result{Track: c, Score: Score(track, c)})
+	}
+	sort.SliceStable(scored, func(i, j int) bool {
+		return scored[i].Score > scored[j].Score
+	})
+	best := Result{}
+	if len(scored) > 0 {
+		best = scored[0]
+	}
+	best.Matched = best.Track.ID != "" && best.Score >= m.Threshold
+	best.Candidates = scored
+	return best, nil
+}
+
+// Score rates from 0 to 1 how likely it is that candidate is the same recording as source.
+func Score(source provider.Track, candidate provider.Track) float64 {
+	sourceTitle := tokenize(normalize.Title(source.Name))
+	candidateTitle := tokenize(normalize.Title(candidate.Name))
+	sourceArtists := normalize.Artists(source.Artists, source.Name)
+
+	// YouTube titles usually carry the artist as well, e.g. "Artist - Song"
+	artistTokens := map[string]bool{}
+	for _, a := range sourceArtists {
+		for _, t := range tokenize(a) {
+			artistTokens[t] = true
+		}
+	}
+	candidateTitleWithoutArtists := []string{}
+	for _, t := range candidateTitle {
+		if !artistTokens[t] {
+			candidateTitleWithoutArtists = append(candidateTitleWithoutArtists, t)
+		}
+	}
+
+	score := titleWeight*titleSimilarity(sourceTitle, candidateTitleWithoutArtists) +
+		artistWeight*artistOverlap(sourceArtists, candidate) +
+		durationWeight*durationSimilarity(source.Duration, candidate.Duration)
+
+	if looksOfficial(candidate) {
+		score += officialBonus
+	}
+	if isDifferentVersion(sourceTitle, candidateTitle) {
+		score *= versionPenalty
+	}
+	if score > 1 {
+		score = 1
+	}
+	return score
+}
+
+// titleSimilarity is the Sørensen–Dice coefficient between the title tokens.
+func titleSimilarity(source []string, candidate []string) float64 {
+	if len(source) == 0 || len(candidate) == 0 {
+		return 0
+	}
+	candidateSet := map[string]int{}
+	for _, t := range candidate {
+		candidateSet[t]++
+	}
+	common := 0
+	for _, t := range source {
+		if candidateSet[t] > 0 {
+			candidateSet[t]--
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(source)+len(candidate))
+}
+
+// artistOverlap is the fraction of source artists found in the candidate artists or title.
+func artistOverlap(artists []string, candidate provider.Track) float64 {
+	if len(artists) == 0 {
+		return 0
+	}
+	candidateArtists := normalize.Artists(candidate.Artists, candidate.Name)
+	haystack := " " + normalize.Key(strings.Join(candidateArtists, " ")+" "+candidate.Name) + " "
+	found := 0
+	for _, a := range artists {
+		needle := normalize.Key(a)
+		if needle == "" {
+			continue
+		}
+		if strings.Contains(haystack, " "+needle+" ") {
+			found++
+		}
+	}
+	return float64(found) / float64(len(artists))
+}
+
+// durationSimilarity is neutral when any of the durations is unknown.
+func durationSimilarity(source time.Duration, candidate time.Duration) float64 {
+	if source == 0 || candidate == 0 {
+		return 0.5
+	}
+	delta := source - candidate
+	if delta < 0 {
+		delta = -delta
+	}
+	switch {
+	case delta <= 3*time.Second:
+		return 1
+	case delta <= 10*time.Second:
+		return 0.7
+	case delta <= 30*time.Second:
+		return 0.3
+	default:
+		return 0
+	}
+}
+
+func looksOfficial(candidate provider.Track) bool {
+	for _, a := range candidate.Artists {
+		if strings.HasSuffix(a, " - Topic") || strings.HasSuffix(strings.ToLower(a), "vevo") {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(candidate.Name), "official")
+}
+
+func isDifferentVersion(source []string, candidate []string) bool {
+	sourceSet := map[string]bool{}
+	for _, t := range source {
+		sourceSet[t] = true
+	}
+	candidateSet := map[string]bool{}
+	for _, t := range candidate {
+		candidateSet[t] = true
+	}
+	for _, w := range versionWords {
+		if candidateSet[w] && !sourceSet[w] {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize splits the normalized value into words.
+func tokenize(value string) []string {
+	return strings.Fields(normalize.Key(value))
+}
@@ -0,0 +1,129 @@
+package match
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/stretchr/testify/mock"
+)
+
+var source = provider.Track{
+	Name:     "Bohemian Rhapsody",
+	Artists:  []string{"Queen"},
+	Duration: 5*time.Minute + 54*time.Second,
+}
+
+func TestScoreOfficialUploadIsAccepted(t *testing.T) {
+	candidate := provider.Track{
+		ID:       "1",
+		Name:     "Queen – Bohemian Rhapsody (Official Video Remastered)",
+		Artists:  []string{"Queen Official"},
+		Duration: 5*time.Minute + 59*time.Second,
+	}
+	score := Score(source, candidate)
+	if score < DEFAULT_THRESHOLD {
+		t.Fatalf("expected score to be above threshold but it is %f", score)
+	}
+}
+
+func TestScoreTopicChannelIsAccepted(t *testing.T) {
+	candidate := provider.Track{
+		ID:       "1",
+		Name:     "Bohemian Rhapsody",
+		Artists:  []string{"Queen - Topic"},
+		Duration: 5*time.Minute + 55*time.Second,
+	}
+	score := Score(source, candidate)
+	if score < 0.9 {
+		t.Fatalf("expected score to be almost certain but it is %f", score)
+	}
+}
+
+func TestScoreCoverIsRejected(t *testing.T) {
+	candidate := provider.Track{
+		ID:       "1",
+		Name:     "Bohemian Rhapsody - Piano Cover",
+		Artists:  []string{"Some Pianist"},
+		Duration: 4 * time.Minute,
+	}
+	score := Score(source, candidate)
+	if score >= DEFAULT_THRESHOLD {
+		t.Fatalf("expected score to be below threshold but it is %f", score)
+	}
+}
+
+func TestScoreLiveVersionIsRejected(t *testing.T) {
+	candidate := provider.Track{
+		ID:       "1",
+		Name:     "Queen - Bohemian Rhapsody (Live Aid 1985)",
+		Artists:  []string{"Queen Official"},
+		Duration: 2*time.Minute + 30*time.Second,
+	}
+	score := Score(source, candidate)
+	if score >= DEFAULT_THRESHOLD {
+		t.Fatalf("expected score to be below threshold but it is %f", score)
+	}
+}
+
+func TestScoreUnrelatedTrackIsRejected(t *testing.T) {
+	candidate := provider.Track{
+		ID:      "1",
+		Name:    "Never Gonna Give You Up",
+		Artists: []string{"Rick Astley"},
+	}
+	score := Score(source, candidate)
+	if score >= DEFAULT_THRESHOLD {
+		t.Fatalf("expected score to be below threshold but it is %f", score)
+	}
+}
+
+func TestMatchPicksBestCandidate(t *testing.T) {
+	destination := provider.NewMockProvider(t)
+	candidates := []provider.Track{
+		{ID: "cover", Name: "Bohemian Rhapsody (Cover)", Artists: []string{"Someone"}},
+		{ID: "official", Name: "Bohemian Rhapsody", Artists: []string{"Queen - Topic"}},
+	}
+	destination.EXPECT().SearchTracks(mock.Anything, "Queen - Bohemian Rhapsody", DEFAULT_CANDIDATES).Return(candidates, nil).Once()
+
+	result, err := New().Match(context.Background(), destination, source)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if !result.Matched || result.Track.ID != "official" {
+		t.Fatalf("expected official to be matched but got %+v", result)
+	}
+	if len(result.Candidates) != 2 || result.Candidates[0].Track.ID != "official" || result.Candidates[1].Track.ID != "cover" {
+		t.Fatalf("expected every candidate to be kept, the best first, but got %+v", result.Candidates)
+	}
+}
+
+func TestMatchBelowThresholdIsNotMatched(t *testing.T) {
+	destination := provider.NewMockProvider(t)
+	candidates := []provider.Track{
+		{ID: "other", Name: "Never Gonna Give You Up", Artists: []string{"Rick Astley"}},
+	}
+	destination.EXPECT().SearchTracks(mock.Anything, "Queen - Bohemian Rhapsody", DEFAULT_CANDIDATES).Return(candidates, nil).Once()
+
+	result, err := New().Match(context.Background(), destination, source)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if result.Matched {
+		t.Fatalf("expected no match but got %+v", result)
+	}
+}
+
+func TestMatchWithoutCandidatesIsNotMatched(t *testing.T) {
+	destination := provider.NewMockProvider(t)
+	destination.EXPECT().SearchTracks(mock.Anything, "Queen - Bohemian Rhapsody", DEFAULT_CANDIDATES).Return([]provider.Track{}, nil).Once()
+
+	result, err := New().Match(context.Background(), destination, source)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if result.Matched {
+		t.Fatalf("expected no match but got %+v", result)
+	}
+}
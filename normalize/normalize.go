@@ -0,0 +1,138 @@
+package normalize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// decorations are bracketed or dash separated title suffixes that don't change the recording.
+// Alternative versions like live, acoustic or remixes are kept on purpose.
+var decorations = []*regexp.Regexp{
+	regexp.MustCompile(`^(?:\d{4}\s+)?(?:digital(?:ly)?\s+)?remaster(?:ed)?(?:\s+version)?(?:\s+\d{4})?$`),
+	regexp.MustCompile(`^(?:radio|single|album)\s+(?:edit|version)$`),
+	regexp.MustCompile(`^(?:official\s+)?(?:music\s+|lyrics?\s+|hd\s+|4k\s+)?(?:video|audio|visuali[sz]er)(?:\s+(?:hd|hq|4k))?$`),
+	regexp.MustCompile(`^(?:official\s+)?lyrics?(?:\s+video)?$`),
+	regexp.MustCompile(`^(?:with\s+)?lyrics$`),
+	regexp.MustCompile(`^(?:hd|hq|4k|1080p|720p)$`),
+	regexp.MustCompile(`^(?:explicit|clean|mono|stereo)(?:\s+version)?$`),
+}
+
+var (
+	bracketed = regexp.MustCompile(`\s*[\(\[]([^\(\)\[\]]*)[\)\]]`)
+	// featured credits in brackets, e.g. "Song (feat. Artist)"
+	bracketedFeatured = regexp.MustCompile(`(?i)\s*[\(\[]\s*(?:feat\.?|ft\.?|featuring)\s+([^\)\]]+)[\)\]]`)
+	// featured credits appended to the title, e.g. "Song feat. Artist - Radio Edit". A capitalized
+	// "Featuring" without brackets is more likely part of the title than a credit.
+	trailingFeatured = regexp.MustCompile(`\s+(?:(?i:feat\.|ft\.)|featuring)\s+(.+?)(\s+-\s+.*)?$`)
+	artistSeparator  = regexp.MustCompile(`\s*(?:,|&|\s+and\s+)\s*`)
+	spaces           = regexp.MustCompile(`\s+`)
+	channelSuffix    = regexp.MustCompile(`(?i)(?:\s+-\s+topic|vevo|\s+official)$`)
+)
+
+// Title removes decorations and featured artist credits from a track title,
+// keeping its original case so it can still be used in search queries.
+func Title(title string) string {
+	title = bracketedFeatured.ReplaceAllString(title, "")
+	title = trailingFeatured.ReplaceAllString(title, "$2")
+	title = bracketed.ReplaceAllStringFunc(title, func(group string) string {
+		content := bracketed.FindStringSubmatch(group)[1]
+		if isDecoration(content) {
+			return ""
+		}
+		return group
+	})
+	// dash separated suffixes, e.g. "Song - Remastered 2011", possibly chained
+	for {
+		i := strings.LastIndex(title, " - ")
+		if i < 0 || !isDecoration(title[i+3:]) {
+			break
+		}
+		title = title[:i]
+	}
+	title = spaces.ReplaceAllString(title, " ")
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(title), "-|"))
+}
+
+// FeaturedArtists returns the artists credited as featured in the title.
+func FeaturedArtists(title string) []string {
+	artists := []string{}
+	credits := []string{}
+	for _, m := range bracketedFeatured.FindAllStringSubmatch(title, -1) {
+		credits = append(credits, m[1])
+	}
+	if m := trailingFeatured.FindStringSubmatch(bracketedFeatured.ReplaceAllString(title, "")); m != nil {
+		credits = append(credits, m[1])
+	}
+	for _, credit := range credits {
+		for _, a := range artistSeparator.Split(credit, -1) {
+			a = strings.TrimSpace(a)
+			if a != "" {
+				artists = append(artists, a)
+			}
+		}
+	}
+	return artists
+}
+
+// Artists merges the featured artists in the title into the artists list,
+// without repeating the ones already present.
+func Artists(artists []string, title string) []string {
+	merged := []string{}
+	seen := map[string]bool{}
+	for _, a := range append(append([]string{}, artists...), FeaturedArtists(title)...) {
+		a = Artist(a)
+		key := Key(a)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, a)
+	}
+	return merged
+}
+
+// Artist removes the suffixes YouTube adds to channel names, e.g. "Artist - Topic" or "ArtistVEVO".
+func Artist(name string) string {
+	return strings.TrimSpace(channelSuffix.ReplaceAllString(strings.TrimSpace(name), ""))
+}
+
+// Key folds unicode, case and punctuation so two values can be compared.
+func Key(value string) string {
+	value = strings.ReplaceAll(value, "&", " and ")
+	value = strings.ToLower(fold(value))
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return r
+		}
+		if r == '\'' || r == '’' {
+			return -1
+		}
+		return ' '
+	}, value)
+	return strings.TrimSpace(spaces.ReplaceAllString(value, " "))
+}
+
+// fold removes diacritics, e.g. "Beyoncé" becomes "Beyonce".
+func fold(value string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, value)
+	if err != nil {
+		return value
+	}
+	return folded
+}
+
+func isDecoration(value string) bool {
+	value = strings.ToLower(strings.TrimSpace(value))
+	for _, d := range decorations {
+		if d.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package normalize
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected string
+	}{
+		// spotify
+		{"Here Comes The Sun - Remastered 2009", "Here Comes The Sun"},
+		{"Come Together - 2019 Mix", "Come Together - 2019 Mix"},
+		{"Wish You Were Here - 2011 Remaster", "Wish You Were Here"},
+		{"Bohemian Rhapsody - Remastered 2011", "Bohemian Rhapsody"},
+		{"Dreams - 2004 Remaster", "Dreams"},
+		{"Heroes - 2017 Remastered Version", "Heroes"},
+		{"Take On Me - Digitally Remastered", "Take On Me"},
+		{"Blinding Lights - Radio Edit", "Blinding Lights"},
+		{"Levels - Radio Edit", "Levels"},
+		{"Hey Jude - Single Version", "Hey Jude"},
+		{"Lose Yourself - Explicit Version", "Lose Yourself"},
+		{"Stay (with Justin Bieber)", "Stay (with Justin Bieber)"},
+		{"Señorita (feat. Camila Cabello)", "Señorita"},
+		{"Old Town Road (feat. Billy Ray Cyrus) - Remix", "Old Town Road - Remix"},
+		{"Sunflower - Spider-Man: Into the Spider-Verse", "Sunflower - Spider-Man: Into the Spider-Verse"},
+		{"Get Lucky (feat. Pharrell Williams & Nile Rodgers) - Radio Edit", "Get Lucky"},
+		{"Hotline Bling feat. Someone", "Hotline Bling"},
+		{"Thinking Out Loud - Live at Wembley", "Thinking Out Loud - Live at Wembley"},
+		{"Hurt - Acoustic", "Hurt - Acoustic"},
+		{"Yesterday - Remastered 2009 - Mono", "Yesterday"},
+		// youtube
+		{"Rick Astley - Never Gonna Give You Up (Official Music Video)", "Rick Astley - Never Gonna Give You Up"},
+		{"Queen – Bohemian Rhapsody (Official Video Remastered)", "Queen – Bohemian Rhapsody (Official Video Remastered)"},
+		{"a-ha - Take On Me (Official Video) [Remastered in 4K]", "a-ha - Take On Me [Remastered in 4K]"},
+		{"Daft Punk - Get Lucky (Official Audio) ft. Pharrell Williams, Nile Rodgers", "Daft Punk - Get Lucky"},
+		{"Adele - Hello [HD]", "Adele - Hello"},
+		{"Eminem - Lose Yourself (Lyrics)", "Eminem - Lose Yourself"},
+		{"Eminem - Lose Yourself [Lyric Video]", "Eminem - Lose Yourself"},
+		{"Avicii - Wake Me Up (Official Lyric Video)", "Avicii - Wake Me Up"},
+		{"Billie Eilish - bad guy (Official Audio)", "Billie Eilish - bad guy"},
+		{"The Weeknd - Blinding Lights (Visualizer)", "The Weeknd - Blinding Lights"},
+		{"Nirvana - Smells Like Teen Spirit (Official Music Video) [HQ]", "Nirvana - Smells Like Teen Spirit"},
+		{"Coldplay - Yellow (Live In Buenos Aires)", "Coldplay - Yellow (Live In Buenos Aires)"},
+		{"Bohemian Rhapsody", "Bohemian Rhapsody"},
+		{"  Spaces   Everywhere  ", "Spaces Everywhere"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		actual := Title(test.title)
+		if actual != test.expected {
+			t.Errorf("expected %q to be normalized as %q but got %q", test.title, test.expected, actual)
+		}
+	}
+}
+
+func TestFeaturedArtists(t *testing.T) {
+	tests := []struct {
+		title    string
+		expected []string
+	}{
+		{"Señorita (feat. Camila Cabello)", []string{"Camila Cabello"}},
+		{"Get Lucky (feat. Pharrell Williams & Nile Rodgers) - Radio Edit", []string{"Pharrell Williams", "Nile Rodgers"}},
+		{"Get Lucky (Official Audio) ft. Pharrell Williams, Nile Rodgers", []string{"Pharrell Williams", "Nile Rodgers"}},
+		{"Umbrella [Ft. Jay-Z]", []string{"Jay-Z"}},
+		{"Empire State of Mind featuring Alicia Keys", []string{"Alicia Keys"}},
+		{"No Featuring Here", []string{}},
+		{"Left and Right (feat. Jung Kook of BTS)", []string{"Jung Kook of BTS"}},
+		{"Song (feat. A, B and C)", []string{"A", "B", "C"}},
+	}
+	for _, test := range tests {
+		actual := FeaturedArtists(test.title)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected featured artists of %q to be %v but got %v", test.title, test.expected, actual)
+		}
+	}
+}
+
+func TestArtists(t *testing.T) {
+	tests := []struct {
+		artists  []string
+		title    string
+		expected []string
+	}{
+		{[]string{"Shawn Mendes"}, "Señorita (feat. Camila Cabello)", []string{"Shawn Mendes", "Camila Cabello"}},
+		{[]string{"Daft Punk", "Pharrell Williams"}, "Get Lucky (feat. Pharrell Williams)", []string{"Daft Punk", "Pharrell Williams"}},
+		{[]string{"Queen - Topic"}, "Bohemian Rhapsody", []string{"Queen"}},
+		{[]string{"AdeleVEVO"}, "Hello", []string{"Adele"}},
+		{[]string{}, "Song", []string{}},
+	}
+	for _, test := range tests {
+		actual := Artists(test.artists, test.title)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected artists of %v %q to be %v but got %v", test.artists, test.title, test.expected, actual)
+		}
+	}
+}
+
+func TestArtist(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Queen - Topic", "Queen"},
+		{"TaylorSwiftVEVO", "TaylorSwift"},
+		{"Queen Official", "Queen"},
+		{"Metallica", "Metallica"},
+		{" Spaced ", "Spaced"},
+	}
+	for _, test := range tests {
+		actual := Artist(test.name)
+		if actual != test.expected {
+			t.Errorf("expected %q to be normalized as %q but got %q", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"Beyoncé", "beyonce"},
+		{"Sigur Rós", "sigur ros"},
+		{"Mötley Crüe", "motley crue"},
+		{"Simon & Garfunkel", "simon and garfunkel"},
+		{"Don't Stop Me Now", "dont stop me now"},
+		{"Don’t Stop Me Now", "dont stop me now"},
+		{"AC/DC", "ac dc"},
+		{"HELLO, World!", "hello world"},
+		{"Queen – Bohemian Rhapsody", "queen bohemian rhapsody"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		actual := Key(test.value)
+		if actual != test.expected {
+			t.Errorf("expected key of %q to be %q but got %q", test.value, test.expected, actual)
+		}
+	}
+}
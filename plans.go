@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+func (a application) planHandler(w http.ResponseWriter, r *http.Request) {
+	plan, err := a.plans.Get(chi.URLParam(r, "id"))
+	if errors.Is(err, transfer.ErrPlanNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tmpl := template.Must(loadPage("plan"))
+	err = tmpl.Execute(w, plan)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
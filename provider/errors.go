@@ -0,0 +1,133 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Providers map the errors of their APIs onto these, so callers can decide what to do
+// without knowing which API failed.
+var (
+	// ErrNotFound is returned when a track or playlist doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when the tokens are invalid, the user has to log in again
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrQuotaExceeded is returned when the daily quota is spent, it resets on the next day
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited is returned when too many requests were made, they can be retried later
+	ErrRateLimited = errors.New("rate limited")
+	// ErrTransient is returned for server and network failures that may work when retried
+	ErrTransient = errors.New("transient error")
+)
+
+const (
+	CODE_NOT_FOUND      = "not-found"
+	CODE_UNAUTHORIZED   = "unauthorized"
+	CODE_QUOTA_EXCEEDED = "quota-exceeded"
+	CODE_RATE_LIMITED   = "rate-limited"
+	CODE_TRANSIENT      = "transient"
+	CODE_CANCELLED      = "cancelled"
+	CODE_UNKNOWN        = "unknown"
+)
+
+// Error is an API error classified as one of the errors above. It matches both the
+// class and the original error with errors.Is and errors.As.
+type Error struct {
+	Kind error
+	Err  error
+	// RetryAfter is how long the API asked to wait before retrying, zero when it didn't say
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func NewError(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+// ClassifyStatus maps an HTTP status code onto one of the provider errors, it's nil
+// for codes without a class, e.g. a bad request.
+func ClassifyStatus(status int) error {
+	if status == http.StatusUnauthorized {
+		return ErrUnauthorized
+	} else if status == http.StatusNotFound {
+		return ErrNotFound
+	} else if status == http.StatusTooManyRequests {
+		return ErrRateLimited
+	} else if status >= http.StatusInternalServerError {
+		return ErrTransient
+	} else {
+		return nil
+	}
+}
+
+// IsNetworkError is true for connection failures and timeouts, but not when the
+// request was cancelled.
+func IsNetworkError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// url.Error is itself a net.Error, even when the failure didn't come from the network
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// RetryAfter returns how long to wait before retrying err, when the API said so.
+func RetryAfter(err error) time.Duration {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.RetryAfter
+	}
+	return 0
+}
+
+// ParseRetryAfter reads the Retry-After header, which is either a number of seconds
+// or a date. It's zero when the header is missing or invalid.
+func ParseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(time.Now()) {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// Code identifies the class of err for clients, e.g. to show a different message for each.
+func Code(err error) string {
+	if errors.Is(err, ErrNotFound) {
+		return CODE_NOT_FOUND
+	} else if errors.Is(err, ErrUnauthorized) {
+		return CODE_UNAUTHORIZED
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		return CODE_QUOTA_EXCEEDED
+	} else if errors.Is(err, ErrRateLimited) {
+		return CODE_RATE_LIMITED
+	} else if errors.Is(err, ErrTransient) {
+		return CODE_TRANSIENT
+	} else if errors.Is(err, context.Canceled) {
+		return CODE_CANCELLED
+	} else {
+		return CODE_UNKNOWN
+	}
+}
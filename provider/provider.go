@@ -1,9 +1,12 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/paulombcosta/waltz/normalize"
 	"golang.org/x/oauth2"
 )
 
@@ -15,16 +18,54 @@ type TokenProvider interface {
 	RefreshToken() (*oauth2.Token, error)
 }
 
+// QuotaReporter is implemented by providers that track how much of their daily API quota is left.
+type QuotaReporter interface {
+	RemainingQuota() (int, bool)
+}
+
+// PLAYLISTS_PAGE_SIZE is how many playlists GetPlaylists lists per call
+const PLAYLISTS_PAGE_SIZE = 50
+
+// Operations of a transfer, used to estimate its cost.
+const (
+	// OPERATION_READ reads the tracks of a playlist
+	OPERATION_READ = "read"
+	// OPERATION_FIND lists a page of the playlists of the user, looking for a playlist by
+	// its name
+	OPERATION_FIND   = "find"
+	OPERATION_SEARCH = "search"
+	OPERATION_CREATE = "create"
+	OPERATION_ADD    = "add"
+	OPERATION_REMOVE = "remove"
+)
+
+// CostEstimator is implemented by providers whose calls are charged against a quota, so
+// the cost of a transfer can be known before running it.
+type CostEstimator interface {
+	// EstimateCost is the quota cost of doing the operation count times, for reads
+	// count is the number of tracks read.
+	EstimateCost(operation string, count int) int
+}
+
 //go:generate mockery --name Provider
 type Provider interface {
 	Name() string
 	IsLoggedIn() bool
-	GetPlaylists() ([]Playlist, error)
-	CreatePlaylist(name string) (PlaylistID, error)
-	FindTrack(name string) (TrackID, error)
-	FindPlaylistByName(name string) (PlaylistID, error)
-	GetFullPlaylist(id string) (*FullPlaylist, error)
-	AddToPlaylist(playlistId string, trackId string) error
+	GetPlaylists(ctx context.Context) ([]Playlist, error)
+	CreatePlaylist(ctx context.Context, name string) (PlaylistID, error)
+	FindTrack(ctx context.Context, name string) (TrackID, error)
+	SearchTracks(ctx context.Context, query string, limit int) ([]Track, error)
+	FindPlaylistByName(ctx context.Context, name string) (PlaylistID, error)
+	GetFullPlaylist(ctx context.Context, id string) (*FullPlaylist, error)
+	AddToPlaylist(ctx context.Context, playlistId string, trackId string) error
+	// InsertIntoPlaylist adds the track so it ends up at position, counting from 0
+	InsertIntoPlaylist(ctx context.Context, playlistId string, trackId string, position int) error
+	// MovePlaylistItem moves the item at position from so it ends up at position to
+	MovePlaylistItem(ctx context.Context, playlistId string, from int, to int) error
+	// RemoveFromPlaylist removes every occurrence of the track from the playlist
+	RemoveFromPlaylist(ctx context.Context, playlistId string, trackId string) error
+	// RemoveLastFromPlaylist removes only the last occurrence of the track from the playlist
+	RemoveLastFromPlaylist(ctx context.Context, playlistId string, trackId string) error
 }
 
 type FullPlaylist struct {
@@ -36,11 +77,21 @@ type Track struct {
 	ID      string
 	Name    string
 	Artists []string
+	// ISRC is the International Standard Recording Code, when the provider knows it
+	ISRC        string
+	Duration    time.Duration
+	Album       string
+	ReleaseYear int
+	URL         string
+	// AddedAt is when the track was added to the playlist it was read from, when known
+	AddedAt time.Time
 }
 
+// FullName is the track as "Artists - Title", without decorations like "- Remastered"
+// and with featured artists moved from the title to the artists.
 func (t Track) FullName() string {
-	artists := strings.Join(t.Artists, ", ")
-	return fmt.Sprintf("%s - %s", artists, t.Name)
+	artists := strings.Join(normalize.Artists(t.Artists, t.Name), ", ")
+	return fmt.Sprintf("%s - %s", artists, normalize.Title(t.Name))
 }
 
 type Playlist struct {
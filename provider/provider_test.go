@@ -1,7 +1,14 @@
 package provider
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
 	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
 func TestTrackFullName(t *testing.T) {
@@ -15,3 +22,117 @@ func TestTrackFullName(t *testing.T) {
 		t.Fatalf("expected %s but got %s", expected, actual)
 	}
 }
+
+func TestTrackFullNameIsNormalized(t *testing.T) {
+	tests := []struct {
+		track    Track
+		expected string
+	}{
+		{Track{Name: "Song - Remastered 2011", Artists: []string{"Paulo"}}, "Paulo - Song"},
+		{Track{Name: "Song (feat. Other)", Artists: []string{"Paulo"}}, "Paulo, Other - Song"},
+		{Track{Name: "Song (Official Video)", Artists: []string{"Paulo - Topic"}}, "Paulo - Song"},
+	}
+	for _, test := range tests {
+		actual := test.track.FullName()
+		if actual != test.expected {
+			t.Errorf("expected %s but got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{NewError(ErrNotFound, errors.New("no playlist")), CODE_NOT_FOUND},
+		{fmt.Errorf("adding track: %w", NewError(ErrUnauthorized, errors.New("expired"))), CODE_UNAUTHORIZED},
+		{NewError(ErrQuotaExceeded, errors.New("quota")), CODE_QUOTA_EXCEEDED},
+		{NewError(ErrRateLimited, errors.New("slow down")), CODE_RATE_LIMITED},
+		{NewError(ErrTransient, errors.New("backend error")), CODE_TRANSIENT},
+		{errors.New("something else"), CODE_UNKNOWN},
+	}
+	for _, test := range tests {
+		if actual := Code(test.err); actual != test.expected {
+			t.Errorf("expected code of %v to be %s but got %s", test.err, test.expected, actual)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"soon", 0},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set("Retry-After", test.value)
+		if actual := ParseRetryAfter(header); actual != test.expected {
+			t.Errorf("expected %q to be %s but got %s", test.value, test.expected, actual)
+		}
+	}
+}
+
+type countingTokenProvider struct {
+	tokens    *oauth2.Token
+	refreshes int
+}
+
+func (t *countingTokenProvider) GetToken() (*oauth2.Token, error) {
+	return t.tokens, nil
+}
+
+func (t *countingTokenProvider) RefreshToken() (*oauth2.Token, error) {
+	t.refreshes++
+	t.tokens = &oauth2.Token{AccessToken: "refreshed", Expiry: time.Now().Add(time.Hour)}
+	return t.tokens, nil
+}
+
+func TestTokenSourceShouldOnlyRefreshTokensAboutToExpire(t *testing.T) {
+	tokenProvider := &countingTokenProvider{tokens: &oauth2.Token{AccessToken: "fresh", Expiry: time.Now().Add(time.Hour)}}
+	source := NewTokenSource(tokenProvider)
+	for i := 0; i < 2; i++ {
+		tokens, err := source.Token()
+		if err != nil || tokens.AccessToken != "fresh" || tokenProvider.refreshes != 0 {
+			t.Fatalf("expected the fresh token to be reused but got %v, %v", tokens, err)
+		}
+	}
+
+	tokenProvider.tokens = &oauth2.Token{AccessToken: "expiring", Expiry: time.Now().Add(TOKEN_EXPIRY_DELTA / 2)}
+	source = NewTokenSource(tokenProvider)
+	tokens, err := source.Token()
+	if err != nil || tokens.AccessToken != "refreshed" || tokenProvider.refreshes != 1 {
+		t.Fatalf("expected the expiring token to be refreshed but got %v, %v", tokens, err)
+	}
+
+	source = NewTokenSource(&countingTokenProvider{})
+	if _, err := source.Token(); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected to be unauthorized without tokens but got %v", err)
+	}
+}
+
+func TestClassifyTokenError(t *testing.T) {
+	retrieveError := func(status int, body string) error {
+		return &oauth2.RetrieveError{Response: &http.Response{StatusCode: status, Header: http.Header{}}, Body: []byte(body)}
+	}
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{retrieveError(http.StatusBadRequest, `{"error": "invalid_grant"}`), ErrUnauthorized},
+		{retrieveError(http.StatusBadRequest, "error=invalid_grant"), ErrUnauthorized},
+		{retrieveError(http.StatusUnauthorized, `{"error": "invalid_client"}`), ErrUnauthorized},
+		{retrieveError(http.StatusServiceUnavailable, ""), ErrTransient},
+		{retrieveError(http.StatusTooManyRequests, ""), ErrRateLimited},
+		{fmt.Errorf("refresh: %w", &net.OpError{Op: "dial", Err: errors.New("timeout")}), ErrTransient},
+	}
+	for _, test := range tests {
+		if actual := ClassifyTokenError(test.err); !errors.Is(actual, test.expected) {
+			t.Errorf("expected %v to be %v but got %v", test.err, test.expected, actual)
+		}
+	}
+}
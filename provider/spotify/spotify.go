@@ -3,69 +3,328 @@ package spotify
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/ratelimit"
 	"github.com/zmb3/spotify/v2"
-	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
 )
 
 type SpotifyProvider struct {
-	tokenProvider provider.TokenProvider
+	// tokens is shared by every copy of the provider, so workers reuse the same token and
+	// refresh it once
+	tokens  oauth2.TokenSource
+	limiter *rate.Limiter
+	// options are passed to the underlying client, tests use it to point to a fake server
+	options []spotify.ClientOption
 }
 
 func New(tokenProvider provider.TokenProvider) *SpotifyProvider {
-	return &SpotifyProvider{tokenProvider: tokenProvider}
+	return &SpotifyProvider{tokens: provider.NewTokenSource(tokenProvider)}
+}
+
+// WithRateLimiter waits for the limiter before every API call, it's shared by every
+// provider using the same API so they stay under its rate limit together.
+func (s SpotifyProvider) WithRateLimiter(limiter *rate.Limiter) *SpotifyProvider {
+	s.limiter = limiter
+	return &s
 }
 
 func (s SpotifyProvider) IsLoggedIn() bool {
-	_, err := s.tokenProvider.RefreshToken()
+	_, err := s.tokens.Token()
 	return err == nil
 }
 
-func (s SpotifyProvider) CreatePlaylist(name string) (provider.PlaylistID, error) {
-	return "", errors.New("not implemented")
+func (s SpotifyProvider) CreatePlaylist(ctx context.Context, name string) (provider.PlaylistID, error) {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	user, err := client.CurrentUser(ctx)
+	if err != nil {
+		return "", mapError(err)
+	}
+	playlist, err := client.CreatePlaylistForUser(
+		ctx, user.ID, name, "Playlist imported by Waltz", true, false)
+	if err != nil {
+		return "", mapError(err)
+	}
+	return provider.PlaylistID(playlist.ID.String()), nil
+}
+
+func (s SpotifyProvider) FindTrack(ctx context.Context, name string) (provider.TrackID, error) {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return "", err
+	}
+	result, err := client.Search(ctx, name, spotify.SearchTypeTrack, spotify.Limit(1))
+	if err != nil {
+		return "", mapError(err)
+	}
+	if result.Tracks == nil || len(result.Tracks.Tracks) == 0 {
+		return "", provider.NewError(provider.ErrNotFound, fmt.Errorf("no track found for %q", name))
+	}
+	return provider.TrackID(result.Tracks.Tracks[0].ID.String()), nil
 }
 
-func (s SpotifyProvider) FindTrack(name string) (provider.TrackID, error) {
-	return "", errors.New("not implemented")
+func (s SpotifyProvider) SearchTracks(ctx context.Context, query string, limit int) ([]provider.Track, error) {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := client.Search(ctx, query, spotify.SearchTypeTrack, spotify.Limit(limit))
+	if err != nil {
+		return nil, mapError(err)
+	}
+	tracks := []provider.Track{}
+	if result.Tracks == nil {
+		return tracks, nil
+	}
+	for i := range result.Tracks.Tracks {
+		tracks = append(tracks, toProviderTrack(&result.Tracks.Tracks[i]))
+	}
+	return tracks, nil
 }
 
-func (s SpotifyProvider) FindPlaylistByName(name string) (provider.PlaylistID, error) {
-	return "", errors.New("not implemented")
+func (s SpotifyProvider) FindPlaylistByName(ctx context.Context, name string) (provider.PlaylistID, error) {
+	playlists, err := s.GetPlaylists(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range playlists {
+		if p.Name == name {
+			return p.ID, nil
+		}
+	}
+	return "", provider.NewError(provider.ErrNotFound, fmt.Errorf("no playlist named %q", name))
 }
 
-func (s SpotifyProvider) AddToPlaylist(playlistId string, trackId string) error {
-	return errors.New("not implemented")
+func (s SpotifyProvider) AddToPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.AddTracksToPlaylist(ctx, spotify.ID(playlistId), spotify.ID(trackId))
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
 }
 
-func (s SpotifyProvider) GetFullPlaylist(id string) (*provider.FullPlaylist, error) {
-	client, err := s.getSpotifyClient()
+// InsertIntoPlaylist appends the track and then moves it to the position, as tracks
+// can only be added at the end. The position counts the tracks GetFullPlaylist returns.
+func (s SpotifyProvider) InsertIntoPlaylist(ctx context.Context, playlistId string, trackId string, position int) error {
+	client, err := s.getSpotifyClient(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	positions, total, err := getPlaylistPositions(ctx, client, spotify.ID(playlistId))
+	if err != nil {
+		return mapError(err)
+	}
+	snapshot, err := client.AddTracksToPlaylist(ctx, spotify.ID(playlistId), spotify.ID(trackId))
+	if err != nil {
+		return mapError(err)
+	}
+	if position >= len(positions) {
+		return nil
+	}
+	_, err = client.ReorderPlaylistTracks(ctx, spotify.ID(playlistId), spotify.PlaylistReorderOptions{
+		RangeStart:   total,
+		InsertBefore: positions[position].position,
+		SnapshotID:   snapshot,
+	})
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+// MovePlaylistItem counts the positions on the tracks GetFullPlaylist returns, the local
+// files and episodes between them stay where they are.
+func (s SpotifyProvider) MovePlaylistItem(ctx context.Context, playlistId string, from int, to int) error {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return err
+	}
+	positions, total, err := getPlaylistPositions(ctx, client, spotify.ID(playlistId))
+	if err != nil {
+		return mapError(err)
+	}
+	if from >= len(positions) {
+		return provider.NewError(provider.ErrNotFound,
+			fmt.Errorf("playlist %s has no track at %d", playlistId, from))
+	}
+	// the position is counted before the item is moved
+	insertBefore := total
+	if to < from {
+		insertBefore = positions[to].position
+	} else if to < len(positions) {
+		insertBefore = positions[to].position + 1
+	}
+	_, err = client.ReorderPlaylistTracks(ctx, spotify.ID(playlistId), spotify.PlaylistReorderOptions{
+		RangeStart:   positions[from].position,
+		InsertBefore: insertBefore,
+	})
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+func (s SpotifyProvider) RemoveFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.RemoveTracksFromPlaylist(ctx, spotify.ID(playlistId), spotify.ID(trackId))
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+// RemoveLastFromPlaylist removes the track at its last position, positions count the
+// local files and episodes GetFullPlaylist leaves out.
+func (s SpotifyProvider) RemoveLastFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := s.getSpotifyClient(ctx)
+	if err != nil {
+		return err
+	}
+	playlist, err := client.GetPlaylist(ctx, spotify.ID(playlistId), spotify.Fields("snapshot_id"))
+	if err != nil {
+		return mapError(err)
+	}
+	positions, _, err := getPlaylistPositions(ctx, client, spotify.ID(playlistId))
+	if err != nil {
+		return mapError(err)
+	}
+	last := -1
+	for _, p := range positions {
+		if p.trackID == trackId {
+			last = p.position
+		}
+	}
+	if last < 0 {
+		return provider.NewError(provider.ErrNotFound,
+			fmt.Errorf("playlist %s has no track %s", playlistId, trackId))
+	}
+	_, err = client.RemoveTracksFromPlaylistOpt(ctx, spotify.ID(playlistId),
+		[]spotify.TrackToRemove{spotify.NewTrackToRemove(trackId, []int{last})}, playlist.SnapshotID)
+	if err != nil {
+		return mapError(err)
 	}
-	fullPlaylist, err := client.GetPlaylist(context.Background(), spotify.ID(id))
+	return nil
+}
+
+func (s SpotifyProvider) GetFullPlaylist(ctx context.Context, id string) (*provider.FullPlaylist, error) {
+	client, err := s.getSpotifyClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	trackPage := fullPlaylist.Tracks
+	fullPlaylist, err := client.GetPlaylist(ctx, spotify.ID(id))
+	if err != nil {
+		return nil, mapError(err)
+	}
 	tracks := []provider.Track{}
-	for _, t := range trackPage.Tracks {
-		artists := []string{}
-		for _, a := range t.Track.Artists {
-			artists = append(artists, a.Name)
+	offset := 0
+	var page *spotify.PlaylistItemPage
+	for {
+		page, err = getPaginatedPlaylistItems(ctx, client, spotify.ID(id), offset)
+		if err != nil {
+			return nil, mapError(err)
+		}
+		for _, item := range page.Items {
+			if !isTrack(item) {
+				continue
+			}
+			track := toProviderTrack(item.Track.Track)
+			track.AddedAt, _ = time.Parse(time.RFC3339, item.AddedAt)
+			tracks = append(tracks, track)
 		}
-		tracks = append(tracks, provider.Track{
-			Name:    t.Track.Name,
-			Artists: artists,
-		})
+		if page.Next == "" || len(page.Items) == 0 {
+			break
+		}
+		offset = offset + len(page.Items)
 	}
 	return &provider.FullPlaylist{
+		Playlist: provider.Playlist{
+			ID:      provider.PlaylistID(fullPlaylist.ID.String()),
+			Name:    fullPlaylist.Name,
+			Tracks:  uint(fullPlaylist.Tracks.Total),
+			Creator: fullPlaylist.Owner.DisplayName,
+		},
 		Tracks: tracks,
 	}, nil
 }
 
-func (s SpotifyProvider) GetPlaylists() ([]provider.Playlist, error) {
-	client, err := s.getSpotifyClient()
+var trackIDRegex = regexp.MustCompile(`^[A-Za-z0-9]{22}$`)
+
+// ParseTrackID returns the ID of the track a link points to, e.g.
+// https://open.spotify.com/track/ID, spotify:track:ID or the ID itself.
+func ParseTrackID(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	id := link
+	if strings.HasPrefix(link, "spotify:track:") {
+		id = strings.TrimPrefix(link, "spotify:track:")
+	} else if strings.Contains(link, "/") {
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", fmt.Errorf("invalid Spotify link %q: %w", link, err)
+		}
+		// the path may start with a locale, e.g. /intl-es/track/ID
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		id = ""
+		if u.Hostname() == "open.spotify.com" && len(parts) >= 2 && parts[len(parts)-2] == "track" {
+			id = parts[len(parts)-1]
+		}
+	}
+	if !trackIDRegex.MatchString(id) {
+		return "", fmt.Errorf("invalid Spotify link %q", link)
+	}
+	return id, nil
+}
+
+func toProviderTrack(t *spotify.FullTrack) provider.Track {
+	artists := []string{}
+	for _, a := range t.Artists {
+		artists = append(artists, a.Name)
+	}
+	return provider.Track{
+		ID:          t.ID.String(),
+		Name:        t.Name,
+		Artists:     artists,
+		ISRC:        t.ExternalIDs["isrc"],
+		Duration:    t.TimeDuration(),
+		Album:       t.Album.Name,
+		ReleaseYear: releaseYear(t.Album.ReleaseDate),
+		URL:         t.ExternalURLs["spotify"],
+	}
+}
+
+// releaseYear extracts the year from a release date, which depending on its
+// precision is formatted as "1981", "1981-12" or "1981-12-15".
+func releaseYear(releaseDate string) int {
+	if len(releaseDate) < 4 {
+		return 0
+	}
+	year, err := strconv.Atoi(releaseDate[:4])
+	if err != nil {
+		return 0
+	}
+	return year
+}
+
+func (s SpotifyProvider) GetPlaylists(ctx context.Context) ([]provider.Playlist, error) {
+	client, err := s.getSpotifyClient(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -73,9 +332,9 @@ func (s SpotifyProvider) GetPlaylists() ([]provider.Playlist, error) {
 	offset := 0
 	var page *spotify.SimplePlaylistPage
 	for {
-		page, err = getPaginatedPlaylists(client, context.Background(), offset)
+		page, err = getPaginatedPlaylists(ctx, client, offset)
 		if err != nil {
-			return nil, err
+			return nil, mapError(err)
 		}
 		for _, p := range page.Playlists {
 			playlists = append(playlists, provider.Playlist{
@@ -97,27 +356,111 @@ func (s SpotifyProvider) Name() string {
 	return "Spotify"
 }
 
-func getPaginatedPlaylists(client *spotify.Client, ctx context.Context, offset int) (*spotify.SimplePlaylistPage, error) {
+func getPaginatedPlaylists(ctx context.Context, client *spotify.Client, offset int) (*spotify.SimplePlaylistPage, error) {
 	if offset == 0 {
-		return client.CurrentUsersPlaylists(context.Background(), spotify.Limit(50))
+		return client.CurrentUsersPlaylists(ctx, spotify.Limit(provider.PLAYLISTS_PAGE_SIZE))
 	} else {
-		return client.CurrentUsersPlaylists(context.Background(), spotify.Limit(50), spotify.Offset(offset))
+		return client.CurrentUsersPlaylists(ctx, spotify.Limit(provider.PLAYLISTS_PAGE_SIZE), spotify.Offset(offset))
 	}
 }
 
-func (s SpotifyProvider) getSpotifyClient() (*spotify.Client, error) {
-	token, err := s.tokenProvider.GetToken()
-	if err != nil {
-		return nil, err
-	}
-	if token != nil {
-		newTokens, err := s.tokenProvider.RefreshToken()
+// isTrack is false for local files and podcast episodes, which can't be matched on other
+// providers.
+func isTrack(item spotify.PlaylistItem) bool {
+	return !item.IsLocal && item.Track.Track != nil
+}
+
+// playlistPosition is where a track is on the playlist, counting the local files and
+// episodes GetFullPlaylist leaves out.
+type playlistPosition struct {
+	trackID  string
+	position int
+}
+
+// getPlaylistPositions returns the positions of the tracks GetFullPlaylist returns, in
+// the same order, and how many items the playlist has.
+func getPlaylistPositions(ctx context.Context, client *spotify.Client, id spotify.ID) ([]playlistPosition, int, error) {
+	positions := []playlistPosition{}
+	offset := 0
+	for {
+		page, err := getPaginatedPlaylistItems(ctx, client, id, offset)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
-		client := spotify.New(spotifyauth.New().Client(context.Background(), newTokens))
-		return client, nil
+		for i, item := range page.Items {
+			if isTrack(item) {
+				positions = append(positions, playlistPosition{trackID: item.Track.Track.ID.String(), position: offset + i})
+			}
+		}
+		offset = offset + len(page.Items)
+		if page.Next == "" || len(page.Items) == 0 {
+			return positions, offset, nil
+		}
+	}
+}
+
+func getPaginatedPlaylistItems(ctx context.Context, client *spotify.Client, id spotify.ID, offset int) (*spotify.PlaylistItemPage, error) {
+	if offset == 0 {
+		return client.GetPlaylistItems(ctx, id, spotify.Limit(100))
 	} else {
-		return nil, nil
+		return client.GetPlaylistItems(ctx, id, spotify.Limit(100), spotify.Offset(offset))
+	}
+}
+
+func (s SpotifyProvider) getSpotifyClient(ctx context.Context) (*spotify.Client, error) {
+	source := s.tokens
+	// fails before the first call when not logged in
+	if _, err := source.Token(); err != nil {
+		return nil, err
+	}
+	httpClient := oauth2.NewClient(ctx, source)
+	httpClient.Transport = rateLimitTransport{Base: httpClient.Transport}
+	if s.limiter != nil {
+		httpClient.Transport = ratelimit.Transport{Base: httpClient.Transport, Limiter: s.limiter}
+	}
+	return spotify.New(httpClient, s.options...), nil
+}
+
+// mapError classifies the errors of the API as provider errors, so transfers know
+// whether to skip, retry, pause or ask to log in again.
+func mapError(err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var providerErr *provider.Error
+	if errors.As(err, &providerErr) {
+		return providerErr
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return provider.ClassifyTokenError(err)
+	}
+	var apiErr spotify.Error
+	if errors.As(err, &apiErr) {
+		if kind := provider.ClassifyStatus(apiErr.Status); kind != nil {
+			return provider.NewError(kind, err)
+		}
+		return err
+	}
+	if provider.IsNetworkError(err) {
+		return provider.NewError(provider.ErrTransient, err)
+	}
+	return err
+}
+
+// rateLimitTransport turns 429 responses into errors that keep the Retry-After header,
+// which the client drops from its own errors.
+type rateLimitTransport struct {
+	Base http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(r)
+	if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+		return resp, err
 	}
+	resp.Body.Close()
+	rateLimited := provider.NewError(provider.ErrRateLimited, fmt.Errorf("spotify: HTTP %d", resp.StatusCode))
+	rateLimited.RetryAfter = provider.ParseRetryAfter(resp.Header)
+	return nil, rateLimited
 }
@@ -0,0 +1,331 @@
+package spotify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/zmb3/spotify/v2"
+	"golang.org/x/oauth2"
+)
+
+type staticTokenProvider struct{}
+
+func (p staticTokenProvider) GetToken() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func (p staticTokenProvider) RefreshToken() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func trackItem(id string) string {
+	return fmt.Sprintf(`{"is_local": false, "track": {"type": "track", "id": "%s", "name": "Song %s",
+		"artists": [{"name": "Artist"}]}}`, id, id)
+}
+
+func newFakeSpotify(t *testing.T, routes map[string]string) *SpotifyProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path+"?"+r.URL.Query().Get("offset")]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.String())
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return &SpotifyProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []spotify.ClientOption{spotify.WithBaseURL(server.URL + "/")},
+	}
+}
+
+const playlistResponse = `{"id": "playlist-id", "name": "My Playlist",
+	"owner": {"display_name": "Paulo"}, "tracks": {"total": 3}}`
+
+func TestGetFullPlaylistReadsEveryPage(t *testing.T) {
+	p := newFakeSpotify(t, map[string]string{
+		"/playlists/playlist-id?": playlistResponse,
+		"/playlists/playlist-id/tracks?": fmt.Sprintf(
+			`{"items": [%s, %s], "next": "next-page", "total": 3}`, trackItem("1"), trackItem("2")),
+		"/playlists/playlist-id/tracks?2": fmt.Sprintf(
+			`{"items": [%s], "next": "", "total": 3}`, trackItem("3")),
+	})
+
+	playlist, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(playlist.Tracks) != 3 {
+		t.Fatalf("expected 3 tracks but got %d", len(playlist.Tracks))
+	}
+	for i, track := range playlist.Tracks {
+		expected := fmt.Sprint(i + 1)
+		if track.ID != expected {
+			t.Fatalf("expected track %d to have id %s but it is %s", i, expected, track.ID)
+		}
+	}
+}
+
+func TestGetFullPlaylistFillsPlaylistMetadata(t *testing.T) {
+	p := newFakeSpotify(t, map[string]string{
+		"/playlists/playlist-id?":        playlistResponse,
+		"/playlists/playlist-id/tracks?": `{"items": [], "next": "", "total": 0}`,
+	})
+
+	playlist, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if playlist.ID != "playlist-id" {
+		t.Fatalf("expected id to be playlist-id but it is %s", playlist.ID)
+	}
+	if playlist.Name != "My Playlist" {
+		t.Fatalf("expected name to be My Playlist but it is %s", playlist.Name)
+	}
+	if playlist.Creator != "Paulo" {
+		t.Fatalf("expected creator to be Paulo but it is %s", playlist.Creator)
+	}
+}
+
+func TestGetFullPlaylistSkipsLocalFilesAndEpisodes(t *testing.T) {
+	localFile := `{"is_local": true, "track": {"type": "track", "id": "", "name": "Local", "artists": []}}`
+	episode := `{"is_local": false, "track": {"type": "episode", "id": "episode", "name": "Podcast"}}`
+	unavailable := `{"is_local": false, "track": null}`
+	p := newFakeSpotify(t, map[string]string{
+		"/playlists/playlist-id?": playlistResponse,
+		"/playlists/playlist-id/tracks?": fmt.Sprintf(
+			`{"items": [%s, %s, %s, %s], "next": "", "total": 4}`, localFile, episode, unavailable, trackItem("1")),
+	})
+
+	playlist, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(playlist.Tracks) != 1 || playlist.Tracks[0].ID != "1" {
+		t.Fatalf("expected only track 1 but got %v", playlist.Tracks)
+	}
+}
+
+func TestGetFullPlaylistFillsTrackMetadata(t *testing.T) {
+	track := `{"is_local": false, "track": {"type": "track", "id": "1", "name": "Song",
+		"artists": [{"name": "Artist"}], "duration_ms": 205000, "external_ids": {"isrc": "USUM71703861"},
+		"external_urls": {"spotify": "https://open.spotify.com/track/1"},
+		"album": {"name": "Album", "release_date": "2017-03"}}}`
+	p := newFakeSpotify(t, map[string]string{
+		"/playlists/playlist-id?":        playlistResponse,
+		"/playlists/playlist-id/tracks?": fmt.Sprintf(`{"items": [%s], "next": "", "total": 1}`, track),
+	})
+
+	playlist, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	actual := playlist.Tracks[0]
+	if actual.ISRC != "USUM71703861" {
+		t.Fatalf("expected isrc to be USUM71703861 but it is %s", actual.ISRC)
+	}
+	if actual.Duration != 205*time.Second {
+		t.Fatalf("expected duration to be 3m25s but it is %s", actual.Duration)
+	}
+	if actual.Album != "Album" || actual.ReleaseYear != 2017 {
+		t.Fatalf("unexpected album %s (%d)", actual.Album, actual.ReleaseYear)
+	}
+	if actual.URL != "https://open.spotify.com/track/1" {
+		t.Fatalf("unexpected url %s", actual.URL)
+	}
+}
+
+func TestFindPlaylistByNameReportsMissingPlaylist(t *testing.T) {
+	p := newFakeSpotify(t, map[string]string{
+		"/me/playlists?": `{"items": [{"id": "1", "name": "Other", "tracks": {"total": 1}}]}`,
+	})
+	_, err := p.FindPlaylistByName(context.Background(), "My Playlist")
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Fatalf("expected not found but got %v", err)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{spotify.Error{Status: 401, Message: "The access token expired"}, provider.ErrUnauthorized},
+		{spotify.Error{Status: 404, Message: "Not found."}, provider.ErrNotFound},
+		{spotify.Error{Status: 429, Message: "API rate limit exceeded"}, provider.ErrRateLimited},
+		{spotify.Error{Status: 502, Message: "Bad gateway."}, provider.ErrTransient},
+	}
+	for _, test := range tests {
+		if actual := mapError(test.err); !errors.Is(actual, test.expected) {
+			t.Errorf("expected %v to be mapped to %v but got %v", test.err, test.expected, actual)
+		}
+	}
+}
+
+func TestRateLimitKeepsRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "12")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	t.Cleanup(server.Close)
+	p := &SpotifyProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []spotify.ClientOption{spotify.WithBaseURL(server.URL + "/")},
+	}
+	_, err := p.SearchTracks(context.Background(), "Queen - Bohemian Rhapsody", 5)
+	if !errors.Is(err, provider.ErrRateLimited) || provider.RetryAfter(err) != 12*time.Second {
+		t.Fatalf("expected a rate limit error to retry after 12s but got %v", err)
+	}
+}
+
+// newFakeSpotifyPlaylist serves a playlist with a local file and an episode between its
+// tracks, recording the reorders of its items.
+func newFakeSpotifyPlaylist(t *testing.T, reorders *[]spotify.PlaylistReorderOptions) *SpotifyProvider {
+	localFile := `{"is_local": true, "track": {"type": "track", "id": "", "name": "Local", "artists": []}}`
+	episode := `{"is_local": false, "track": {"type": "episode", "id": "episode", "name": "Podcast"}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/playlists/playlist-id/tracks" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		} else if r.Method == http.MethodGet {
+			_, _ = fmt.Fprintf(w, `{"items": [%s, %s, %s, %s, %s], "next": "", "total": 5}`,
+				trackItem("1"), localFile, trackItem("2"), episode, trackItem("3"))
+		} else if r.Method == http.MethodPut {
+			var body spotify.PlaylistReorderOptions
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			*reorders = append(*reorders, body)
+			_, _ = w.Write([]byte(`{"snapshot_id": "moved"}`))
+		} else {
+			_, _ = w.Write([]byte(`{"snapshot_id": "added"}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &SpotifyProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []spotify.ClientOption{spotify.WithBaseURL(server.URL + "/")},
+	}
+}
+
+func TestMovePlaylistItemCountsPositionBeforeMoving(t *testing.T) {
+	tests := []struct {
+		from         int
+		to           int
+		rangeStart   int
+		insertBefore int
+	}{
+		{from: 2, to: 0, rangeStart: 4, insertBefore: 0},
+		{from: 0, to: 1, rangeStart: 0, insertBefore: 3},
+		{from: 0, to: 2, rangeStart: 0, insertBefore: 5},
+	}
+	for _, test := range tests {
+		reorders := []spotify.PlaylistReorderOptions{}
+		p := newFakeSpotifyPlaylist(t, &reorders)
+		err := p.MovePlaylistItem(context.Background(), "playlist-id", test.from, test.to)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		// the local file and the episode count on Spotify but aren't tracks
+		if len(reorders) != 1 || reorders[0].RangeStart != test.rangeStart || reorders[0].InsertBefore != test.insertBefore {
+			t.Errorf("expected moving %d to %d to move %d before %d but got %+v",
+				test.from, test.to, test.rangeStart, test.insertBefore, reorders)
+		}
+	}
+}
+
+func TestInsertIntoPlaylistSkipsLocalFilesAndEpisodes(t *testing.T) {
+	reorders := []spotify.PlaylistReorderOptions{}
+	p := newFakeSpotifyPlaylist(t, &reorders)
+
+	err := p.InsertIntoPlaylist(context.Background(), "playlist-id", "4", 1)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(reorders) != 1 || reorders[0].RangeStart != 5 || reorders[0].InsertBefore != 2 || reorders[0].SnapshotID != "added" {
+		t.Fatalf("expected the track to be moved before track 2 but got %+v", reorders)
+	}
+
+	reorders = reorders[:0]
+	err = p.InsertIntoPlaylist(context.Background(), "playlist-id", "4", 3)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(reorders) != 0 {
+		t.Fatalf("expected the track to be left at the end but got %+v", reorders)
+	}
+}
+
+func TestRemoveLastFromPlaylistRemovesOnlyTheLastPosition(t *testing.T) {
+	localFile := `{"is_local": true, "track": {"type": "track", "id": "", "name": "Local", "artists": []}}`
+	var body struct {
+		Tracks     []spotify.TrackToRemove `json:"tracks"`
+		SnapshotID string                  `json:"snapshot_id"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && r.URL.Path == "/playlists/playlist-id" {
+			_, _ = w.Write([]byte(`{"id": "playlist-id", "snapshot_id": "snapshot"}`))
+		} else if r.Method == http.MethodGet && r.URL.Path == "/playlists/playlist-id/tracks" {
+			_, _ = fmt.Fprintf(w, `{"items": [%s, %s, %s, %s], "next": "", "total": 4}`,
+				trackItem("1"), localFile, trackItem("1"), trackItem("2"))
+		} else if r.Method == http.MethodDelete && r.URL.Path == "/playlists/playlist-id/tracks" {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			_, _ = w.Write([]byte(`{"snapshot_id": "removed"}`))
+		} else {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+	}))
+	t.Cleanup(server.Close)
+	p := &SpotifyProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []spotify.ClientOption{spotify.WithBaseURL(server.URL + "/")},
+	}
+
+	err := p.RemoveLastFromPlaylist(context.Background(), "playlist-id", "1")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// the local file counts for the position
+	if len(body.Tracks) != 1 || len(body.Tracks[0].Positions) != 1 || body.Tracks[0].Positions[0] != 2 {
+		t.Fatalf("expected only position 2 to be removed but got %+v", body.Tracks)
+	}
+	if body.SnapshotID != "snapshot" {
+		t.Fatalf("expected the snapshot to be sent but got %s", body.SnapshotID)
+	}
+
+	err = p.RemoveLastFromPlaylist(context.Background(), "playlist-id", "3")
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Fatalf("expected a not found error but got %v", err)
+	}
+}
+
+func TestParseTrackID(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{"4uLU6hMCjMI75M1A2tKUQC", "4uLU6hMCjMI75M1A2tKUQC"},
+		{"spotify:track:4uLU6hMCjMI75M1A2tKUQC", "4uLU6hMCjMI75M1A2tKUQC"},
+		{"https://open.spotify.com/track/4uLU6hMCjMI75M1A2tKUQC?si=abc", "4uLU6hMCjMI75M1A2tKUQC"},
+		{"https://open.spotify.com/intl-es/track/4uLU6hMCjMI75M1A2tKUQC", "4uLU6hMCjMI75M1A2tKUQC"},
+		{"https://open.spotify.com/album/4uLU6hMCjMI75M1A2tKUQC", ""},
+		{"https://example.com/track/4uLU6hMCjMI75M1A2tKUQC", ""},
+		{"not a track", ""},
+	}
+	for _, test := range tests {
+		actual, err := ParseTrackID(test.link)
+		if test.expected == "" && err == nil {
+			t.Errorf("expected %q to be invalid but got %s", test.link, actual)
+		} else if test.expected != "" && actual != test.expected {
+			t.Errorf("expected %q to be parsed as %s but got %q (%v)", test.link, test.expected, actual, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TOKEN_EXPIRY_DELTA is how long before they expire tokens are refreshed, so they don't
+// expire while a request is in flight.
+const TOKEN_EXPIRY_DELTA = time.Minute
+
+// NewTokenSource adapts the TokenProvider to the oauth2 clients of the providers. The
+// token is reused until it's about to expire and then refreshed through the
+// TokenProvider, which saves the new one. Tokens whose expiry isn't known, e.g. saved
+// before it was kept, are refreshed on first use.
+func NewTokenSource(tokenProvider TokenProvider) oauth2.TokenSource {
+	return &tokenSource{tokenProvider: tokenProvider}
+}
+
+type tokenSource struct {
+	tokenProvider TokenProvider
+	mu            sync.Mutex
+	reused        *oauth2.Token
+}
+
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fresh(s.reused) {
+		return s.reused, nil
+	}
+	tokens, err := s.tokenProvider.GetToken()
+	if err != nil {
+		// the tokens are kept locally, e.g. on the session, there's nothing to retry
+		return nil, NewError(ErrUnauthorized, err)
+	}
+	if tokens == nil {
+		return nil, NewError(ErrUnauthorized, errors.New("no tokens"))
+	}
+	if !fresh(tokens) {
+		tokens, err = s.tokenProvider.RefreshToken()
+		if err != nil {
+			return nil, ClassifyTokenError(err)
+		}
+	}
+	s.reused = tokens
+	return tokens, nil
+}
+
+func fresh(tokens *oauth2.Token) bool {
+	return tokens != nil && tokens.AccessToken != "" && !tokens.Expiry.IsZero() &&
+		time.Now().Add(TOKEN_EXPIRY_DELTA).Before(tokens.Expiry)
+}
+
+// ClassifyTokenError maps a failure to refresh the tokens onto the provider errors. Only
+// rejected refresh tokens make the user log in again, other failures, e.g. of the
+// network or the token endpoint, may work when retried.
+func ClassifyTokenError(err error) error {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+		status := retrieveErr.Response.StatusCode
+		if status == http.StatusUnauthorized || tokenErrorCode(retrieveErr.Body) == "invalid_grant" {
+			return NewError(ErrUnauthorized, err)
+		} else if status == http.StatusTooManyRequests {
+			rateLimited := NewError(ErrRateLimited, err)
+			rateLimited.RetryAfter = ParseRetryAfter(retrieveErr.Response.Header)
+			return rateLimited
+		}
+	}
+	return NewError(ErrTransient, err)
+}
+
+// tokenErrorCode reads the error of a token endpoint response, which is JSON or, for
+// some providers, form encoded.
+func tokenErrorCode(body []byte) string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil {
+		return response.Error
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return values.Get("error")
+}
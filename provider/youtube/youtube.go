@@ -2,75 +2,138 @@ package youtube
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/quota"
+	"github.com/paulombcosta/waltz/ratelimit"
 	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
 type YoutubeProvider struct {
-	tokenProvider provider.TokenProvider
-	playlists     []*youtube.Playlist
+	// tokens is shared by every copy of the provider, so workers reuse the same token and
+	// refresh it once
+	tokens  oauth2.TokenSource
+	meter   *quota.Meter
+	limiter *rate.Limiter
+	// options are passed to the underlying service, tests use it to point to a fake server
+	options []option.ClientOption
 }
 
-func (y YoutubeProvider) getPlaylists() ([]*youtube.Playlist, error) {
-	if y.playlists != nil {
-		return y.playlists, nil
-	}
-	client, err := y.getYoutubeClient()
-	if err != nil {
-		return nil, err
+func New(tokenProvider provider.TokenProvider) *YoutubeProvider {
+	return &YoutubeProvider{tokens: provider.NewTokenSource(tokenProvider)}
+}
+
+// WithQuotaMeter charges every API call to the meter, refusing calls once the budget is spent.
+func (y YoutubeProvider) WithQuotaMeter(meter *quota.Meter) *YoutubeProvider {
+	y.meter = meter
+	return &y
+}
+
+// WithRateLimiter waits for the limiter before every API call, it's shared by every
+// provider using the same API so they stay under its rate limit together.
+func (y YoutubeProvider) WithRateLimiter(limiter *rate.Limiter) *YoutubeProvider {
+	y.limiter = limiter
+	return &y
+}
+
+// RemainingQuota reports the units left for today, it's false when quota isn't being tracked.
+func (y YoutubeProvider) RemainingQuota() (int, bool) {
+	if y.meter == nil {
+		return 0, false
 	}
-	response, err := client.Playlists.List([]string{"snippet", "id"}).Mine(true).Do()
+	remaining, err := y.meter.Remaining()
 	if err != nil {
-		return nil, err
+		return 0, false
 	}
-	y.playlists = response.Items
-	return y.playlists, nil
+	return remaining, true
 }
 
-func New(tokenProvider provider.TokenProvider) *YoutubeProvider {
-	return &YoutubeProvider{tokenProvider: tokenProvider}
-}
-
-// maybe move to sessions, looks more like it
 func (y YoutubeProvider) IsLoggedIn() bool {
-	_, err := y.tokenProvider.RefreshToken()
+	_, err := y.tokens.Token()
 	return err == nil
 }
 
-func (y YoutubeProvider) FindTrack(name string) (provider.TrackID, error) {
-	client, err := y.getYoutubeClient()
+func (y YoutubeProvider) FindTrack(ctx context.Context, name string) (provider.TrackID, error) {
+	client, err := y.getYoutubeClient(ctx)
 	if err != nil {
 		return "", err
 	}
-	searchResponse, err := client.Search.List([]string{"id"}).Type("video").MaxResults(1).Q(name).Do()
+	searchResponse, err := client.Search.List([]string{"id"}).Type("video").MaxResults(1).Q(name).Context(ctx).Do()
 	if err != nil {
-		return "", err
+		return "", mapError(err)
 	}
 	if len(searchResponse.Items) == 0 {
-		return "", nil
+		return "", provider.NewError(provider.ErrNotFound, fmt.Errorf("no video found for %q", name))
 	}
 	return provider.TrackID(searchResponse.Items[0].Id.VideoId), nil
 }
 
-func (y YoutubeProvider) FindPlaylistByName(name string) (provider.PlaylistID, error) {
-	playlists, err := y.getPlaylists()
+func (y YoutubeProvider) SearchTracks(ctx context.Context, query string, limit int) ([]provider.Track, error) {
+	client, err := y.getYoutubeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	searchResponse, err := client.Search.List([]string{"snippet"}).
+		Type("video").
+		MaxResults(int64(limit)).
+		Q(query).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, mapError(err)
+	}
+	videoIds := []string{}
+	for _, item := range searchResponse.Items {
+		videoIds = append(videoIds, item.Id.VideoId)
+	}
+	durations, err := getVideoDurations(ctx, client, videoIds)
+	if err != nil {
+		return nil, err
+	}
+	tracks := []provider.Track{}
+	for _, item := range searchResponse.Items {
+		videoId := item.Id.VideoId
+		tracks = append(tracks, provider.Track{
+			ID: videoId,
+			// search snippets come html escaped, e.g. &#39; instead of '
+			Name:     html.UnescapeString(item.Snippet.Title),
+			Artists:  []string{html.UnescapeString(item.Snippet.ChannelTitle)},
+			Duration: durations[videoId],
+			URL:      "https://www.youtube.com/watch?v=" + videoId,
+		})
+	}
+	return tracks, nil
+}
+
+func (y YoutubeProvider) FindPlaylistByName(ctx context.Context, name string) (provider.PlaylistID, error) {
+	// every page is read, so existing playlists aren't created again
+	playlists, err := y.GetPlaylists(ctx)
 	if err != nil {
 		return "", err
 	}
 	for _, p := range playlists {
-		if p.Snippet.Title == name {
-			return provider.PlaylistID(p.Id), nil
+		if p.Name == name {
+			return p.ID, nil
 		}
 	}
-	return "", nil
+	return "", provider.NewError(provider.ErrNotFound, fmt.Errorf("no playlist named %q", name))
 }
 
-func (y YoutubeProvider) CreatePlaylist(name string) (provider.PlaylistID, error) {
-	client, err := y.getYoutubeClient()
+func (y YoutubeProvider) CreatePlaylist(ctx context.Context, name string) (provider.PlaylistID, error) {
+	client, err := y.getYoutubeClient(ctx)
 	if err != nil {
 		return "", err
 	}
@@ -84,28 +147,46 @@ func (y YoutubeProvider) CreatePlaylist(name string) (provider.PlaylistID, error
 		},
 	}
 
-	playlist, err = client.Playlists.Insert([]string{"snippet", "status"}, playlist).Do()
+	playlist, err = client.Playlists.Insert([]string{"snippet", "status"}, playlist).Context(ctx).Do()
 	if err != nil {
-		return "", err
+		return "", mapError(err)
 	}
 	return provider.PlaylistID(playlist.Id), nil
 }
 
-func (y YoutubeProvider) GetPlaylists() ([]provider.Playlist, error) {
-	client, err := y.getYoutubeClient()
+func (y YoutubeProvider) GetPlaylists(ctx context.Context) ([]provider.Playlist, error) {
+	client, err := y.getYoutubeClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	call := client.Playlists.List([]string{"snippet", "id", "contentDetails"})
-	call.Mine(true)
-	res, err := call.Do()
-	if err != nil {
-		return nil, err
-	}
 	playlists := []provider.Playlist{}
-	for _, p := range res.Items {
-		playlists = append(playlists, provider.Playlist{Name: p.Snippet.Title})
+	nextPageToken := ""
+	for {
+		res, err := client.Playlists.List([]string{"snippet", "id", "contentDetails"}).
+			Mine(true).
+			MaxResults(provider.PLAYLISTS_PAGE_SIZE).
+			PageToken(nextPageToken).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return nil, mapError(err)
+		}
+		for _, p := range res.Items {
+			playlist := provider.Playlist{
+				ID:      provider.PlaylistID(p.Id),
+				Name:    p.Snippet.Title,
+				Creator: p.Snippet.ChannelTitle,
+			}
+			if p.ContentDetails != nil {
+				playlist.Tracks = uint(p.ContentDetails.ItemCount)
+			}
+			playlists = append(playlists, playlist)
+		}
+		nextPageToken = res.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
 	}
 	return playlists, nil
 }
@@ -114,31 +195,69 @@ func (y YoutubeProvider) Name() string {
 	return "YouTube"
 }
 
-func (y YoutubeProvider) GetFullPlaylist(id string) (*provider.FullPlaylist, error) {
-	client, err := y.getYoutubeClient()
+func (y YoutubeProvider) GetFullPlaylist(ctx context.Context, id string) (*provider.FullPlaylist, error) {
+	client, err := y.getYoutubeClient(ctx)
 	if err != nil {
 		return nil, err
 	}
+	res, err := client.Playlists.List([]string{"snippet", "contentDetails"}).
+		Id(id).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, mapError(err)
+	}
+	if len(res.Items) == 0 {
+		return nil, provider.NewError(provider.ErrNotFound, fmt.Errorf("playlist %s not found", id))
+	}
 	playlist := &provider.FullPlaylist{
-		Playlist: provider.Playlist{ID: provider.PlaylistID(id)},
+		Playlist: provider.Playlist{
+			ID:      provider.PlaylistID(id),
+			Name:    res.Items[0].Snippet.Title,
+			Creator: res.Items[0].Snippet.ChannelTitle,
+		},
+	}
+	if res.Items[0].ContentDetails != nil {
+		playlist.Playlist.Tracks = uint(res.Items[0].ContentDetails.ItemCount)
 	}
 	tracks := []provider.Track{}
 	nextPageToken := ""
 	for {
-		playlistItemListCall := client.PlaylistItems.List([]string{"contentDetails"}).
+		playlistItemListCall := client.PlaylistItems.List([]string{"snippet", "contentDetails"}).
 			PlaylistId(id).
 			MaxResults(50).
-			PageToken(nextPageToken)
+			PageToken(nextPageToken).
+			Context(ctx)
 
 		playlistItemListResponse, err := playlistItemListCall.Do()
 		if err != nil {
-			return nil, fmt.Errorf("error retrieving playlist items: %v", err)
+			return nil, fmt.Errorf("error retrieving playlist items: %w", mapError(err))
 		}
 
+		videoIds := []string{}
 		for _, item := range playlistItemListResponse.Items {
-			tracks = append(tracks, provider.Track{
-				ID: item.ContentDetails.VideoId,
-			})
+			videoIds = append(videoIds, item.ContentDetails.VideoId)
+		}
+		durations, err := getVideoDurations(ctx, client, videoIds)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range playlistItemListResponse.Items {
+			videoId := item.ContentDetails.VideoId
+			track := provider.Track{
+				ID:       videoId,
+				Duration: durations[videoId],
+				URL:      "https://www.youtube.com/watch?v=" + videoId,
+			}
+			if item.Snippet != nil {
+				track.Name = item.Snippet.Title
+				track.AddedAt, _ = time.Parse(time.RFC3339, item.Snippet.PublishedAt)
+				if item.Snippet.VideoOwnerChannelTitle != "" {
+					track.Artists = []string{item.Snippet.VideoOwnerChannelTitle}
+				}
+			}
+			tracks = append(tracks, track)
 		}
 		nextPageToken = playlistItemListResponse.NextPageToken
 
@@ -150,10 +269,83 @@ func (y YoutubeProvider) GetFullPlaylist(id string) (*provider.FullPlaylist, err
 	return playlist, nil
 }
 
-func (y YoutubeProvider) AddToPlaylist(playlistId string, trackId string) error {
-	client, err := y.getYoutubeClient()
+// getVideoDurations fetches the duration of up to 50 videos in a single call.
+func getVideoDurations(ctx context.Context, client *youtube.Service, ids []string) (map[string]time.Duration, error) {
+	durations := map[string]time.Duration{}
+	if len(ids) == 0 {
+		return durations, nil
+	}
+	response, err := client.Videos.List([]string{"contentDetails"}).Id(ids...).MaxResults(50).Context(ctx).Do()
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("error retrieving video details: %w", mapError(err))
+	}
+	for _, video := range response.Items {
+		if video.ContentDetails == nil {
+			continue
+		}
+		durations[video.Id] = parseDuration(video.ContentDetails.Duration)
+	}
+	return durations, nil
+}
+
+var durationRegex = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration parses the ISO 8601 durations returned by the API, e.g. PT4M13S.
+// Invalid values are reported as a zero duration.
+func parseDuration(value string) time.Duration {
+	matches := durationRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return 0
+	}
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if matches[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return 0
+		}
+		duration += time.Duration(n) * unit
+	}
+	return duration
+}
+
+var videoIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+// ParseVideoID returns the ID of the video a link points to, e.g.
+// https://www.youtube.com/watch?v=ID, https://youtu.be/ID or the ID itself.
+func ParseVideoID(link string) (string, error) {
+	link = strings.TrimSpace(link)
+	if videoIDRegex.MatchString(link) {
+		return link, nil
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid YouTube link %q: %w", link, err)
+	}
+	id := ""
+	host := strings.TrimPrefix(u.Hostname(), "www.")
+	if host == "youtu.be" {
+		id = strings.Trim(u.Path, "/")
+	} else if host == "youtube.com" || host == "music.youtube.com" || host == "m.youtube.com" {
+		if u.Path == "/watch" {
+			id = u.Query().Get("v")
+		} else if strings.HasPrefix(u.Path, "/shorts/") {
+			id = strings.TrimPrefix(u.Path, "/shorts/")
+		}
+	}
+	if !videoIDRegex.MatchString(id) {
+		return "", fmt.Errorf("invalid YouTube link %q", link)
+	}
+	return id, nil
+}
+
+func (y YoutubeProvider) AddToPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := y.getYoutubeClient(ctx)
+	if err != nil {
+		return err
 	}
 
 	item := &youtube.PlaylistItem{
@@ -165,31 +357,266 @@ func (y YoutubeProvider) AddToPlaylist(playlistId string, trackId string) error
 			},
 		},
 	}
-	_, err = client.PlaylistItems.Insert([]string{"snippet"}, item).Do()
+	_, err = client.PlaylistItems.Insert([]string{"snippet"}, item).Context(ctx).Do()
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+// InsertIntoPlaylist only works on playlists sorted manually, which is the default.
+func (y YoutubeProvider) InsertIntoPlaylist(ctx context.Context, playlistId string, trackId string, position int) error {
+	client, err := y.getYoutubeClient(ctx)
 	if err != nil {
 		return err
 	}
+	item := &youtube.PlaylistItem{
+		Snippet: &youtube.PlaylistItemSnippet{
+			PlaylistId: playlistId,
+			ResourceId: &youtube.ResourceId{
+				Kind:    "youtube#video",
+				VideoId: trackId,
+			},
+			Position: int64(position),
+			// otherwise position 0 is left out and the item is appended
+			ForceSendFields: []string{"Position"},
+		},
+	}
+	_, err = client.PlaylistItems.Insert([]string{"snippet"}, item).Context(ctx).Do()
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+func (y YoutubeProvider) MovePlaylistItem(ctx context.Context, playlistId string, from int, to int) error {
+	client, err := y.getYoutubeClient(ctx)
+	if err != nil {
+		return err
+	}
+	item, err := getPlaylistItemAt(ctx, client, playlistId, from)
+	if err != nil {
+		return err
+	}
+	update := &youtube.PlaylistItem{
+		Id: item.Id,
+		Snippet: &youtube.PlaylistItemSnippet{
+			PlaylistId:      playlistId,
+			ResourceId:      item.Snippet.ResourceId,
+			Position:        int64(to),
+			ForceSendFields: []string{"Position"},
+		},
+	}
+	_, err = client.PlaylistItems.Update([]string{"snippet"}, update).Context(ctx).Do()
+	if err != nil {
+		return mapError(err)
+	}
 	return nil
 }
 
-func (y YoutubeProvider) getYoutubeClient() (*youtube.Service, error) {
-	tokens, err := y.tokenProvider.GetToken()
+// RemoveFromPlaylist deletes every item of the video, items are deleted one at a time.
+func (y YoutubeProvider) RemoveFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := y.getYoutubeClient(ctx)
+	if err != nil {
+		return err
+	}
+	response, err := client.PlaylistItems.List([]string{"id"}).
+		PlaylistId(playlistId).
+		VideoId(trackId).
+		MaxResults(50).
+		Context(ctx).
+		Do()
 	if err != nil {
+		return mapError(err)
+	}
+	if len(response.Items) == 0 {
+		return provider.NewError(provider.ErrNotFound,
+			fmt.Errorf("playlist %s has no video %s", playlistId, trackId))
+	}
+	for _, item := range response.Items {
+		err = client.PlaylistItems.Delete(item.Id).Context(ctx).Do()
+		if err != nil {
+			return mapError(err)
+		}
+	}
+	return nil
+}
+
+// RemoveLastFromPlaylist deletes the last item of the video, items are listed in the
+// order of the playlist.
+func (y YoutubeProvider) RemoveLastFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	client, err := y.getYoutubeClient(ctx)
+	if err != nil {
+		return err
+	}
+	var last *youtube.PlaylistItem
+	nextPageToken := ""
+	for {
+		response, err := client.PlaylistItems.List([]string{"id"}).
+			PlaylistId(playlistId).
+			VideoId(trackId).
+			MaxResults(50).
+			PageToken(nextPageToken).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return mapError(err)
+		}
+		if len(response.Items) > 0 {
+			last = response.Items[len(response.Items)-1]
+		}
+		nextPageToken = response.NextPageToken
+		if nextPageToken == "" {
+			break
+		}
+	}
+	if last == nil {
+		return provider.NewError(provider.ErrNotFound,
+			fmt.Errorf("playlist %s has no video %s", playlistId, trackId))
+	}
+	err = client.PlaylistItems.Delete(last.Id).Context(ctx).Do()
+	if err != nil {
+		return mapError(err)
+	}
+	return nil
+}
+
+// getPlaylistItemAt finds the item at the position, items can only be updated by their
+// own ID and not by the ID of their video.
+func getPlaylistItemAt(ctx context.Context, client *youtube.Service, playlistId string, position int) (*youtube.PlaylistItem, error) {
+	skipped := 0
+	nextPageToken := ""
+	for {
+		response, err := client.PlaylistItems.List([]string{"snippet"}).
+			PlaylistId(playlistId).
+			MaxResults(50).
+			PageToken(nextPageToken).
+			Context(ctx).
+			Do()
+		if err != nil {
+			return nil, mapError(err)
+		}
+		if position < skipped+len(response.Items) {
+			return response.Items[position-skipped], nil
+		}
+		skipped += len(response.Items)
+		nextPageToken = response.NextPageToken
+		if nextPageToken == "" {
+			return nil, provider.NewError(provider.ErrNotFound,
+				fmt.Errorf("playlist %s has no item at position %d", playlistId, position))
+		}
+	}
+}
+
+func (y YoutubeProvider) getYoutubeClient(ctx context.Context) (*youtube.Service, error) {
+	source := y.tokens
+	// fails before the first call when not logged in
+	if _, err := source.Token(); err != nil {
 		return nil, err
 	}
-	source := TokenSource{Source: *tokens}
-	youtubeService, err := youtube.NewService(
-		context.Background(), option.WithTokenSource(source))
+	options := []option.ClientOption{option.WithTokenSource(source)}
+	if y.meter != nil || y.limiter != nil {
+		httpClient := oauth2.NewClient(ctx, source)
+		if y.meter != nil {
+			httpClient.Transport = quota.Transport{Base: httpClient.Transport, Meter: y.meter, Cost: apiCost}
+		}
+		if y.limiter != nil {
+			httpClient.Transport = ratelimit.Transport{Base: httpClient.Transport, Limiter: y.limiter}
+		}
+		options = []option.ClientOption{option.WithHTTPClient(httpClient)}
+	}
+	options = append(options, y.options...)
+	youtubeService, err := youtube.NewService(ctx, options...)
 	if err != nil {
 		return nil, err
 	}
 	return youtubeService, nil
 }
 
-type TokenSource struct {
-	Source oauth2.Token
+// mapError classifies the errors of the API and the quota meter as provider errors,
+// so transfers know whether to skip, retry, pause or ask to log in again.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(err, quota.ErrBudgetExceeded) {
+		return provider.NewError(provider.ErrQuotaExceeded, err)
+	}
+	var providerErr *provider.Error
+	if errors.As(err, &providerErr) {
+		return providerErr
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return provider.ClassifyTokenError(err)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		kind := provider.ClassifyStatus(apiErr.Code)
+		if apiErr.Code == http.StatusForbidden {
+			kind = classifyForbidden(apiErr)
+		}
+		if kind == nil {
+			return err
+		}
+		providerErr := provider.NewError(kind, err)
+		providerErr.RetryAfter = provider.ParseRetryAfter(apiErr.Header)
+		return providerErr
+	}
+	if provider.IsNetworkError(err) {
+		return provider.NewError(provider.ErrTransient, err)
+	}
+	return err
+}
+
+// classifyForbidden tells quota and rate limit errors apart, the API returns 403 for both.
+func classifyForbidden(apiErr *googleapi.Error) error {
+	for _, e := range apiErr.Errors {
+		if e.Reason == "quotaExceeded" || e.Reason == "dailyLimitExceeded" {
+			return provider.ErrQuotaExceeded
+		} else if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return provider.ErrRateLimited
+		}
+	}
+	return nil
+}
+
+// EstimateCost follows the calls each operation makes, e.g. reads get the playlist and
+// then list 50 items and their durations at a time, searches also get the durations of the results and
+// removals find the item of the video before deleting it.
+func (y YoutubeProvider) EstimateCost(operation string, count int) int {
+	switch operation {
+	case provider.OPERATION_READ:
+		pages := (count + 49) / 50
+		if pages == 0 {
+			pages = 1
+		}
+		return 1 + 2*pages
+	case provider.OPERATION_FIND:
+		return count
+	case provider.OPERATION_SEARCH:
+		return 101 * count
+	case provider.OPERATION_CREATE, provider.OPERATION_ADD:
+		return 50 * count
+	case provider.OPERATION_REMOVE:
+		return 51 * count
+	default:
+		return 0
+	}
 }
 
-func (s TokenSource) Token() (*oauth2.Token, error) {
-	return &s.Source, nil
+// apiCost is the quota cost of a request, see https://developers.google.com/youtube/v3/determine_quota_cost
+func apiCost(r *http.Request) int {
+	if strings.HasSuffix(r.URL.Path, "/search") {
+		return 100
+	}
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		return 50
+	default:
+		return 1
+	}
 }
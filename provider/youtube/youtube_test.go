@@ -0,0 +1,323 @@
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/quota"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+type staticTokenProvider struct{}
+
+func (p staticTokenProvider) GetToken() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func (p staticTokenProvider) RefreshToken() (*oauth2.Token, error) {
+	return &oauth2.Token{AccessToken: "token"}, nil
+}
+
+func newFakeYoutube(t *testing.T, routes map[string]string) *YoutubeProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path+"?"+r.URL.Query().Get("pageToken")]
+		if !ok {
+			t.Errorf("unexpected request %s", r.URL.String())
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return &YoutubeProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+	}
+}
+
+func TestGetPlaylistsReadsEveryPage(t *testing.T) {
+	p := newFakeYoutube(t, map[string]string{
+		"/youtube/v3/playlists?": `{"nextPageToken": "second", "items": [{"id": "1",
+			"snippet": {"title": "First", "channelTitle": "Paulo"}, "contentDetails": {"itemCount": 10}}]}`,
+		"/youtube/v3/playlists?second": `{"items": [{"id": "2",
+			"snippet": {"title": "Second", "channelTitle": "Paulo"}, "contentDetails": {"itemCount": 3}}]}`,
+	})
+
+	playlists, err := p.GetPlaylists(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(playlists) != 2 {
+		t.Fatalf("expected 2 playlists but got %d", len(playlists))
+	}
+	first := playlists[0]
+	if first.ID != "1" || first.Name != "First" || first.Tracks != 10 || first.Creator != "Paulo" {
+		t.Fatalf("unexpected playlist %+v", first)
+	}
+	if playlists[1].ID != "2" || playlists[1].Tracks != 3 {
+		t.Fatalf("unexpected playlist %+v", playlists[1])
+	}
+}
+
+func TestFindPlaylistByNameReadsEveryPage(t *testing.T) {
+	p := newFakeYoutube(t, map[string]string{
+		"/youtube/v3/playlists?": `{"nextPageToken": "second", "items": [{"id": "1",
+			"snippet": {"title": "First"}, "contentDetails": {"itemCount": 10}}]}`,
+		"/youtube/v3/playlists?second": `{"items": [{"id": "2",
+			"snippet": {"title": "Second"}, "contentDetails": {"itemCount": 3}}]}`,
+	})
+
+	id, err := p.FindPlaylistByName(context.Background(), "Second")
+	if err != nil || id != "2" {
+		t.Fatalf("expected the playlist of the second page but got %q, %v", id, err)
+	}
+}
+
+func TestGetFullPlaylistFillsPlaylistAndTrackMetadata(t *testing.T) {
+	p := newFakeYoutube(t, map[string]string{
+		"/youtube/v3/playlists?": `{"items": [{"id": "playlist-id",
+			"snippet": {"title": "My Playlist", "channelTitle": "Paulo"}, "contentDetails": {"itemCount": 1}}]}`,
+		"/youtube/v3/playlistItems?": `{"items": [{"contentDetails": {"videoId": "video"},
+			"snippet": {"title": "Song", "videoOwnerChannelTitle": "Artist - Topic"}}]}`,
+		"/youtube/v3/videos?": `{"items": [{"id": "video", "contentDetails": {"duration": "PT3M25S"}}]}`,
+	})
+
+	playlist, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if playlist.ID != "playlist-id" || playlist.Name != "My Playlist" || playlist.Creator != "Paulo" || playlist.Playlist.Tracks != 1 {
+		t.Fatalf("expected the playlist to be filled but got %+v", playlist.Playlist)
+	}
+	if len(playlist.Tracks) != 1 {
+		t.Fatalf("expected 1 track but got %d", len(playlist.Tracks))
+	}
+	track := playlist.Tracks[0]
+	if track.ID != "video" || track.Name != "Song" || track.Artists[0] != "Artist - Topic" {
+		t.Fatalf("unexpected track %+v", track)
+	}
+	if track.Duration != 3*time.Minute+25*time.Second {
+		t.Fatalf("expected duration to be 3m25s but it is %s", track.Duration)
+	}
+	if track.URL != "https://www.youtube.com/watch?v=video" {
+		t.Fatalf("unexpected url %s", track.URL)
+	}
+}
+
+func TestGetFullPlaylistReportsMissingPlaylist(t *testing.T) {
+	p := newFakeYoutube(t, map[string]string{
+		"/youtube/v3/playlists?": `{"items": []}`,
+	})
+
+	_, err := p.GetFullPlaylist(context.Background(), "playlist-id")
+	if !errors.Is(err, provider.ErrNotFound) {
+		t.Fatalf("expected a not found error but got %v", err)
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"PT4M13S", 4*time.Minute + 13*time.Second},
+		{"PT1H2M3S", time.Hour + 2*time.Minute + 3*time.Second},
+		{"PT45S", 45 * time.Second},
+		{"PT10M", 10 * time.Minute},
+		{"P1DT1S", 24*time.Hour + time.Second},
+		{"P0D", 0},
+		{"", 0},
+		{"invalid", 0},
+	}
+	for _, test := range tests {
+		actual := parseDuration(test.value)
+		if actual != test.expected {
+			t.Errorf("expected %s to be parsed as %s but got %s", test.value, test.expected, actual)
+		}
+	}
+}
+
+func TestAPICost(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		expected int
+	}{
+		{http.MethodGet, "/youtube/v3/playlists", 1},
+		{http.MethodGet, "/youtube/v3/playlistItems", 1},
+		{http.MethodGet, "/youtube/v3/videos", 1},
+		{http.MethodGet, "/youtube/v3/search", 100},
+		{http.MethodPost, "/youtube/v3/playlists", 50},
+		{http.MethodPost, "/youtube/v3/playlistItems", 50},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if actual := apiCost(r); actual != test.expected {
+			t.Errorf("expected %s %s to cost %d but got %d", test.method, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected error
+	}{
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}, provider.ErrQuotaExceeded},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, provider.ErrRateLimited},
+		{&googleapi.Error{Code: 401}, provider.ErrUnauthorized},
+		{&googleapi.Error{Code: 404, Errors: []googleapi.ErrorItem{{Reason: "playlistNotFound"}}}, provider.ErrNotFound},
+		{&googleapi.Error{Code: 503, Errors: []googleapi.ErrorItem{{Reason: "backendError"}}}, provider.ErrTransient},
+		{fmt.Errorf("charging request: %w", quota.ErrBudgetExceeded), provider.ErrQuotaExceeded},
+	}
+	for _, test := range tests {
+		if actual := mapError(test.err); !errors.Is(actual, test.expected) {
+			t.Errorf("expected %v to be mapped to %v but got %v", test.err, test.expected, actual)
+		}
+	}
+}
+
+func TestMapErrorKeepsRetryAfter(t *testing.T) {
+	err := &googleapi.Error{Code: 429, Header: http.Header{"Retry-After": []string{"30"}}}
+	actual := mapError(err)
+	if !errors.Is(actual, provider.ErrRateLimited) || provider.RetryAfter(actual) != 30*time.Second {
+		t.Fatalf("expected a rate limit error to retry after 30s but got %v", actual)
+	}
+}
+
+func TestInsertIntoPlaylistSendsFirstPosition(t *testing.T) {
+	var body map[string]map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/youtube/v3/playlistItems" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "item"}`))
+	}))
+	t.Cleanup(server.Close)
+	p := &YoutubeProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+	}
+	err := p.InsertIntoPlaylist(context.Background(), "playlist-id", "video-id", 0)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if position, ok := body["snippet"]["position"]; !ok || position != 0.0 {
+		t.Fatalf("expected position 0 to be sent but got %v", body)
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	tests := []struct {
+		operation string
+		count     int
+		expected  int
+	}{
+		{provider.OPERATION_READ, 0, 3},
+		{provider.OPERATION_READ, 50, 3},
+		{provider.OPERATION_READ, 51, 5},
+		{provider.OPERATION_FIND, 3, 3},
+		{provider.OPERATION_SEARCH, 2, 202},
+		{provider.OPERATION_CREATE, 1, 50},
+		{provider.OPERATION_ADD, 10, 500},
+	}
+	for _, test := range tests {
+		if actual := (YoutubeProvider{}).EstimateCost(test.operation, test.count); actual != test.expected {
+			t.Errorf("expected %s of %d to cost %d but got %d", test.operation, test.count, test.expected, actual)
+		}
+	}
+}
+
+func TestRemoveFromPlaylistDeletesEveryItemOfTheVideo(t *testing.T) {
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("videoId") == "video-id" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"items": [{"id": "first"}, {"id": "second"}]}`))
+		} else if r.Method == http.MethodDelete {
+			deleted = append(deleted, r.URL.Query().Get("id"))
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+	}))
+	t.Cleanup(server.Close)
+	p := &YoutubeProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+	}
+	err := p.RemoveFromPlaylist(context.Background(), "playlist-id", "video-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(deleted) != 2 || deleted[0] != "first" || deleted[1] != "second" {
+		t.Fatalf("expected both items to be deleted but got %v", deleted)
+	}
+}
+
+func TestRemoveLastFromPlaylistDeletesOnlyTheLastItemOfTheVideo(t *testing.T) {
+	deleted := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Query().Get("videoId") == "video-id" {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("pageToken") == "" {
+				_, _ = w.Write([]byte(`{"items": [{"id": "first"}], "nextPageToken": "next"}`))
+			} else {
+				_, _ = w.Write([]byte(`{"items": [{"id": "second"}, {"id": "last"}]}`))
+			}
+		} else if r.Method == http.MethodDelete {
+			deleted = append(deleted, r.URL.Query().Get("id"))
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.String())
+		}
+	}))
+	t.Cleanup(server.Close)
+	p := &YoutubeProvider{
+		tokens:  provider.NewTokenSource(staticTokenProvider{}),
+		options: []option.ClientOption{option.WithEndpoint(server.URL + "/")},
+	}
+	err := p.RemoveLastFromPlaylist(context.Background(), "playlist-id", "video-id")
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(deleted) != 1 || deleted[0] != "last" {
+		t.Fatalf("expected only the last item to be deleted but got %v", deleted)
+	}
+}
+
+func TestParseVideoID(t *testing.T) {
+	tests := []struct {
+		link     string
+		expected string
+	}{
+		{"dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PL123", "dQw4w9WgXcQ"},
+		{"https://music.youtube.com/watch?v=dQw4w9WgXcQ", "dQw4w9WgXcQ"},
+		{"https://youtu.be/dQw4w9WgXcQ?si=abc", "dQw4w9WgXcQ"},
+		{" https://www.youtube.com/shorts/dQw4w9WgXcQ ", "dQw4w9WgXcQ"},
+		{"https://www.youtube.com/playlist?list=PL123", ""},
+		{"https://example.com/watch?v=dQw4w9WgXcQ", ""},
+		{"not a video", ""},
+	}
+	for _, test := range tests {
+		actual, err := ParseVideoID(test.link)
+		if test.expected == "" && err == nil {
+			t.Errorf("expected %q to be invalid but got %s", test.link, actual)
+		} else if test.expected != "" && actual != test.expected {
+			t.Errorf("expected %q to be parsed as %s but got %q (%v)", test.link, test.expected, actual, err)
+		}
+	}
+}
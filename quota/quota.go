@@ -0,0 +1,99 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/paulombcosta/waltz/store"
+)
+
+const (
+	QUOTA_BUCKET   = "quota"
+	DEFAULT_BUDGET = 10000
+)
+
+var ErrBudgetExceeded = errors.New("quota budget exceeded")
+
+// Google resets the daily quota at midnight Pacific Time.
+var pacific, _ = time.LoadLocation("America/Los_Angeles")
+
+// Meter keeps the daily count of quota units spent on an API and refuses
+// operations that would go over the budget.
+type Meter struct {
+	store  *store.Store
+	name   string
+	budget int
+	now    func() time.Time
+	mu     sync.Mutex
+}
+
+func NewMeter(s *store.Store, name string, budget int) *Meter {
+	return &Meter{store: s, name: name, budget: budget, now: time.Now}
+}
+
+// Charge records cost units as spent, failing with ErrBudgetExceeded without recording
+// anything when they would go over the budget.
+func (m *Meter) Charge(cost int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, err := m.used()
+	if err != nil {
+		return err
+	}
+	if used+cost > m.budget {
+		return fmt.Errorf("%w: %d of %d units used today, resets at %s",
+			ErrBudgetExceeded, used, m.budget, m.ResetsAt().Format(time.Kitchen+" MST"))
+	}
+	return m.store.Put(QUOTA_BUCKET, m.key(), used+cost)
+}
+
+func (m *Meter) Remaining() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	used, err := m.used()
+	if err != nil {
+		return 0, err
+	}
+	if used > m.budget {
+		return 0, nil
+	}
+	return m.budget - used, nil
+}
+
+func (m *Meter) ResetsAt() time.Time {
+	return NextReset(m.now())
+}
+
+// NextReset is the next midnight Pacific Time after t.
+func NextReset(t time.Time) time.Time {
+	t = t.In(pacific)
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, pacific)
+}
+
+func (m *Meter) used() (int, error) {
+	used := 0
+	_, err := m.store.Get(QUOTA_BUCKET, m.key(), &used)
+	return used, err
+}
+
+func (m *Meter) key() string {
+	return m.name + "/" + m.now().In(pacific).Format("2006-01-02")
+}
+
+// Transport charges every request its cost before sending it.
+type Transport struct {
+	Base  http.RoundTripper
+	Meter *Meter
+	Cost  func(r *http.Request) int
+}
+
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.Meter.Charge(t.Cost(r)); err != nil {
+		return nil, err
+	}
+	return t.Base.RoundTrip(r)
+}
@@ -0,0 +1,62 @@
+package quota
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/store"
+)
+
+func newMeter(t *testing.T, budget int, now *time.Time) *Meter {
+	s, err := store.Open(filepath.Join(t.TempDir(), "waltz.db"))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	m := NewMeter(s, "youtube", budget)
+	m.now = func() time.Time { return *now }
+	return m
+}
+
+func TestChargeRefusesOperationsOverBudget(t *testing.T) {
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, pacific)
+	m := newMeter(t, 150, &now)
+
+	if err := m.Charge(100); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if err := m.Charge(100); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected budget exceeded but got %v", err)
+	}
+	if err := m.Charge(50); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	remaining, _ := m.Remaining()
+	if remaining != 0 {
+		t.Fatalf("expected nothing remaining but got %d", remaining)
+	}
+}
+
+func TestUsageResetsAtPacificMidnight(t *testing.T) {
+	now := time.Date(2023, 3, 1, 23, 59, 0, 0, pacific)
+	m := newMeter(t, 100, &now)
+
+	_ = m.Charge(100)
+	expectedReset := time.Date(2023, 3, 2, 0, 0, 0, 0, pacific)
+	if !m.ResetsAt().Equal(expectedReset) {
+		t.Fatalf("expected reset at %s but it is %s", expectedReset, m.ResetsAt())
+	}
+
+	// 08:00 UTC is still the same day in California
+	now = time.Date(2023, 3, 2, 7, 0, 0, 0, time.UTC)
+	if remaining, _ := m.Remaining(); remaining != 0 {
+		t.Fatalf("expected nothing remaining but got %d", remaining)
+	}
+
+	now = expectedReset
+	if remaining, _ := m.Remaining(); remaining != 100 {
+		t.Fatalf("expected quota to reset but got %d remaining", remaining)
+	}
+}
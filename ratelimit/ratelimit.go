@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// Requests per second allowed on each API, shared by every transfer of the server.
+const (
+	SPOTIFY_RATE  = 10
+	SPOTIFY_BURST = 10
+	YOUTUBE_RATE  = 5
+	YOUTUBE_BURST = 5
+)
+
+func NewSpotifyLimiter() *rate.Limiter {
+	return rate.NewLimiter(SPOTIFY_RATE, SPOTIFY_BURST)
+}
+
+func NewYoutubeLimiter() *rate.Limiter {
+	return rate.NewLimiter(YOUTUBE_RATE, YOUTUBE_BURST)
+}
+
+// Transport waits for the limiter before sending every request.
+type Transport struct {
+	Base    http.RoundTripper
+	Limiter *rate.Limiter
+}
+
+func (t Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(r.Context()); err != nil {
+		return nil, err
+	}
+	return t.Base.RoundTrip(r)
+}
@@ -0,0 +1,38 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+type countingTransport struct {
+	requests *int
+}
+
+func (t countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	*t.requests++
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestTransportDoesNotSendWhenCancelledWhileWaiting(t *testing.T) {
+	requests := 0
+	transport := Transport{Base: countingTransport{requests: &requests}, Limiter: rate.NewLimiter(rate.Every(1<<62), 1)}
+
+	_, err := transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = transport.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+	if err == nil {
+		t.Fatalf("expected the second request to wait for the limiter")
+	}
+	if requests != 1 {
+		t.Fatalf("expected a single request to be sent but got %d", requests)
+	}
+}
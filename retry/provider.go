@@ -0,0 +1,119 @@
+package retry
+
+import (
+	"context"
+
+	"github.com/paulombcosta/waltz/provider"
+)
+
+// Provider retries the calls of the wrapped provider according to the policy.
+type Provider struct {
+	provider.Provider
+	policy Policy
+}
+
+func Wrap(p provider.Provider, policy Policy) Provider {
+	return Provider{Provider: p, policy: policy}
+}
+
+// RemainingQuota forwards to the wrapped provider, so wrapping doesn't hide its quota.
+func (p Provider) RemainingQuota() (int, bool) {
+	reporter, ok := p.Provider.(provider.QuotaReporter)
+	if !ok {
+		return 0, false
+	}
+	return reporter.RemainingQuota()
+}
+
+// EstimateCost forwards to the wrapped provider, providers without a quota cost nothing.
+func (p Provider) EstimateCost(operation string, count int) int {
+	estimator, ok := p.Provider.(provider.CostEstimator)
+	if !ok {
+		return 0
+	}
+	return estimator.EstimateCost(operation, count)
+}
+
+func (p Provider) GetPlaylists(ctx context.Context) ([]provider.Playlist, error) {
+	var playlists []provider.Playlist
+	err := p.policy.Do(ctx, func() (err error) {
+		playlists, err = p.Provider.GetPlaylists(ctx)
+		return err
+	})
+	return playlists, err
+}
+
+func (p Provider) CreatePlaylist(ctx context.Context, name string) (provider.PlaylistID, error) {
+	var id provider.PlaylistID
+	err := p.policy.Do(ctx, func() (err error) {
+		id, err = p.Provider.CreatePlaylist(ctx, name)
+		return err
+	})
+	return id, err
+}
+
+func (p Provider) FindTrack(ctx context.Context, name string) (provider.TrackID, error) {
+	var id provider.TrackID
+	err := p.policy.Do(ctx, func() (err error) {
+		id, err = p.Provider.FindTrack(ctx, name)
+		return err
+	})
+	return id, err
+}
+
+func (p Provider) SearchTracks(ctx context.Context, query string, limit int) ([]provider.Track, error) {
+	var tracks []provider.Track
+	err := p.policy.Do(ctx, func() (err error) {
+		tracks, err = p.Provider.SearchTracks(ctx, query, limit)
+		return err
+	})
+	return tracks, err
+}
+
+func (p Provider) FindPlaylistByName(ctx context.Context, name string) (provider.PlaylistID, error) {
+	var id provider.PlaylistID
+	err := p.policy.Do(ctx, func() (err error) {
+		id, err = p.Provider.FindPlaylistByName(ctx, name)
+		return err
+	})
+	return id, err
+}
+
+func (p Provider) GetFullPlaylist(ctx context.Context, id string) (*provider.FullPlaylist, error) {
+	var playlist *provider.FullPlaylist
+	err := p.policy.Do(ctx, func() (err error) {
+		playlist, err = p.Provider.GetFullPlaylist(ctx, id)
+		return err
+	})
+	return playlist, err
+}
+
+func (p Provider) AddToPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	return p.policy.Do(ctx, func() error {
+		return p.Provider.AddToPlaylist(ctx, playlistId, trackId)
+	})
+}
+
+func (p Provider) InsertIntoPlaylist(ctx context.Context, playlistId string, trackId string, position int) error {
+	return p.policy.Do(ctx, func() error {
+		return p.Provider.InsertIntoPlaylist(ctx, playlistId, trackId, position)
+	})
+}
+
+func (p Provider) MovePlaylistItem(ctx context.Context, playlistId string, from int, to int) error {
+	return p.policy.Do(ctx, func() error {
+		return p.Provider.MovePlaylistItem(ctx, playlistId, from, to)
+	})
+}
+
+func (p Provider) RemoveFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	return p.policy.Do(ctx, func() error {
+		return p.Provider.RemoveFromPlaylist(ctx, playlistId, trackId)
+	})
+}
+
+func (p Provider) RemoveLastFromPlaylist(ctx context.Context, playlistId string, trackId string) error {
+	return p.policy.Do(ctx, func() error {
+		return p.Provider.RemoveLastFromPlaylist(ctx, playlistId, trackId)
+	})
+}
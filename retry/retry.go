@@ -0,0 +1,94 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+)
+
+const (
+	DEFAULT_ATTEMPTS   = 5
+	DEFAULT_BASE_DELAY = time.Second
+	DEFAULT_MAX_DELAY  = time.Minute
+)
+
+// Policy decides how many times and how long apart failed calls are retried. The
+// delay doubles on every attempt, with jitter so concurrent callers don't retry in
+// lockstep, unless the API asked to wait for a given time with Retry-After.
+type Policy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. When the API asks to wait longer than it the call
+	// isn't retried, e.g. so the job is paused instead.
+	MaxDelay time.Duration
+	// OnWait is called before waiting to retry a call that failed with err
+	OnWait func(err error, delay time.Duration)
+	// sleep waits for the delay, tests replace it to avoid waiting
+	sleep func(ctx context.Context, delay time.Duration) error
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Attempts:  DEFAULT_ATTEMPTS,
+		BaseDelay: DEFAULT_BASE_DELAY,
+		MaxDelay:  DEFAULT_MAX_DELAY,
+	}
+}
+
+// Retryable is true for errors that may not happen again, i.e. rate limits and
+// transient server or network failures.
+func Retryable(err error) bool {
+	return errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrTransient)
+}
+
+// Do calls f until it succeeds, fails with an error that isn't retryable, runs out
+// of attempts or ctx is done.
+func (p Policy) Do(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = f()
+		if err == nil || !Retryable(err) || attempt+1 >= p.Attempts {
+			return err
+		}
+		delay := p.delay(attempt, err)
+		if delay > p.MaxDelay {
+			return err
+		}
+		if p.OnWait != nil {
+			p.OnWait(err, delay)
+		}
+		if err := p.wait(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// delay is the Retry-After of err, or a jittered exponential backoff when there is none.
+func (p Policy) delay(attempt int, err error) time.Duration {
+	if retryAfter := provider.RetryAfter(err); retryAfter > 0 {
+		return retryAfter
+	}
+	backoff := p.BaseDelay << attempt
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	// between half and the whole backoff
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+func (p Policy) wait(ctx context.Context, delay time.Duration) error {
+	if p.sleep != nil {
+		return p.sleep(ctx, delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,112 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+)
+
+func testPolicy(waits *[]time.Duration) Policy {
+	policy := DefaultPolicy()
+	policy.sleep = func(ctx context.Context, delay time.Duration) error {
+		*waits = append(*waits, delay)
+		return ctx.Err()
+	}
+	return policy
+}
+
+func TestDoRetriesTransientErrors(t *testing.T) {
+	waits := []time.Duration{}
+	calls := 0
+	err := testPolicy(&waits).Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return provider.NewError(provider.ErrTransient, errors.New("backend error"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if calls != 3 || len(waits) != 2 {
+		t.Fatalf("expected 3 calls and 2 waits but got %d calls and %v", calls, waits)
+	}
+	if waits[0] < DEFAULT_BASE_DELAY/2 || waits[0] > DEFAULT_BASE_DELAY {
+		t.Fatalf("expected the first wait to be around %s but it is %s", DEFAULT_BASE_DELAY, waits[0])
+	}
+	if waits[1] < DEFAULT_BASE_DELAY || waits[1] > 2*DEFAULT_BASE_DELAY {
+		t.Fatalf("expected the second wait to double but it is %s", waits[1])
+	}
+}
+
+func TestDoDoesNotRetryOtherErrors(t *testing.T) {
+	waits := []time.Duration{}
+	calls := 0
+	err := testPolicy(&waits).Do(context.Background(), func() error {
+		calls++
+		return provider.NewError(provider.ErrNotFound, errors.New("no playlist"))
+	})
+	if !errors.Is(err, provider.ErrNotFound) || calls != 1 {
+		t.Fatalf("expected a single call failing with not found but got %d calls and %v", calls, err)
+	}
+}
+
+func TestDoGivesUpAfterAttempts(t *testing.T) {
+	waits := []time.Duration{}
+	calls := 0
+	err := testPolicy(&waits).Do(context.Background(), func() error {
+		calls++
+		return provider.NewError(provider.ErrRateLimited, errors.New("slow down"))
+	})
+	if !errors.Is(err, provider.ErrRateLimited) || calls != DEFAULT_ATTEMPTS {
+		t.Fatalf("expected %d calls failing with rate limited but got %d and %v", DEFAULT_ATTEMPTS, calls, err)
+	}
+}
+
+func TestDoWaitsForRetryAfter(t *testing.T) {
+	waits := []time.Duration{}
+	calls := 0
+	err := testPolicy(&waits).Do(context.Background(), func() error {
+		calls++
+		if calls == 1 {
+			rateLimited := provider.NewError(provider.ErrRateLimited, errors.New("slow down"))
+			rateLimited.RetryAfter = 7 * time.Second
+			return rateLimited
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if len(waits) != 1 || waits[0] != 7*time.Second {
+		t.Fatalf("expected to wait for the Retry-After but waited %v", waits)
+	}
+}
+
+func TestDoDoesNotWaitLongerThanMaxDelay(t *testing.T) {
+	waits := []time.Duration{}
+	err := testPolicy(&waits).Do(context.Background(), func() error {
+		rateLimited := provider.NewError(provider.ErrRateLimited, errors.New("slow down"))
+		rateLimited.RetryAfter = time.Hour
+		return rateLimited
+	})
+	if !errors.Is(err, provider.ErrRateLimited) || len(waits) != 0 {
+		t.Fatalf("expected to give up without waiting but got %v after waiting %v", err, waits)
+	}
+}
+
+func TestDoStopsWhenContextIsCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := DefaultPolicy().Do(ctx, func() error {
+		calls++
+		return provider.NewError(provider.ErrTransient, errors.New("backend error"))
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Fatalf("expected to stop after the first call but got %d calls and %v", calls, err)
+	}
+}
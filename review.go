@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider/spotify"
+	"github.com/paulombcosta/waltz/provider/youtube"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// CHOICE_LINK is the choice of tracks resolved with a pasted link or ID
+const CHOICE_LINK = "link"
+
+func (a application) reviewHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	tmpl := template.Must(loadPage("review"))
+	err = tmpl.Execute(w, j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// saveReviewHandler sets the resolutions chosen on the review page. Each track has a
+// choice-<playlist>-<track> value with the chosen candidate, job.RESOLUTION_IGNORE or
+// CHOICE_LINK, in which case the link-<playlist>-<track> value is the link or ID.
+func (a application) saveReviewHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	if j.Status == job.STATUS_RUNNING {
+		http.Error(w, "the job is running, review it once it stops", http.StatusConflict)
+		return
+	}
+	for p := range j.Playlists {
+		for i := range j.Playlists[p].Tracks {
+			t := &j.Playlists[p].Tracks[i]
+			choice := r.FormValue(fmt.Sprintf("choice-%d-%d", p, i))
+			if t.Review == "" || t.Review == job.REVIEW_APPLIED || choice == "" {
+				continue
+			}
+			if choice == CHOICE_LINK {
+				choice, err = parseTrackLink(j.Destination, r.FormValue(fmt.Sprintf("link-%d-%d", p, i)))
+				if err != nil {
+					http.Error(w, fmt.Sprintf("%s: %s", t.FullName(), err), http.StatusBadRequest)
+					return
+				}
+			} else if choice != job.RESOLUTION_IGNORE && !isCandidate(t, choice) {
+				http.Error(w, fmt.Sprintf("%s: invalid choice %s", t.FullName(), choice), http.StatusBadRequest)
+				return
+			}
+			t.Resolve(choice)
+		}
+	}
+	err = a.jobs.Save(j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%s/review", j.ID), http.StatusSeeOther)
+}
+
+// isCandidate returns whether the ID is one of the candidates of the track, the only
+// tracks that can be chosen other than pasted links.
+func isCandidate(t *job.Track, id string) bool {
+	for _, c := range t.Candidates {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// applyReviewHandler adds the resolved tracks of the job to the destination, with the
+// tokens of its owner, who's the only one that can review it.
+func (a application) applyReviewHandler(w http.ResponseWriter, r *http.Request) {
+	j, err := a.getOwnedJob(r)
+	if err != nil {
+		jobError(w, err)
+		return
+	}
+	if j.Status == job.STATUS_RUNNING {
+		http.Error(w, "the job is running, apply the review once it stops", http.StatusConflict)
+		return
+	}
+	_, destination, err := a.jobProviders(j)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	err = a.runner.ApplyReview(r.Context(), j, destination)
+	if errors.Is(err, transfer.ErrAlreadyRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%s/review", j.ID), http.StatusSeeOther)
+}
+
+// parseTrackLink returns the ID of the track a link of the provider points to.
+func parseTrackLink(name string, link string) (string, error) {
+	if strings.TrimSpace(link) == "" {
+		return "", errors.New("no link given")
+	}
+	if name == PROVIDER_GOOGLE {
+		return youtube.ParseVideoID(link)
+	} else if name == PROVIDER_SPOTIFY {
+		return spotify.ParseTrackID(link)
+	} else {
+		return "", fmt.Errorf("invalid provider %s", name)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/paulombcosta/waltz/job"
+)
+
+func TestReviewsOfOtherUsersShouldNotBeFound(t *testing.T) {
+	a := newTestApplication(t)
+	other := saveJob(t, a, "bob", job.STATUS_DONE)
+	other.Playlists[0].Tracks = []job.Track{{Review: job.REVIEW_PENDING}}
+	if err := a.jobs.Save(other); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	cookies := loggedIn(t, a, "alice")
+
+	res, _ := serve(a, httptest.NewRequest(http.MethodGet, "/jobs/"+other.ID+"/review", nil), cookies)
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the review to not be found but got %d", res.StatusCode)
+	}
+	form := url.Values{"choice-0-0": {CHOICE_LINK}, "link-0-0": {"https://www.youtube.com/watch?v=dQw4w9WgXcQ"}}
+	r := httptest.NewRequest(http.MethodPost, "/jobs/"+other.ID+"/review", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, _ = serve(a, r, cookies)
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the review to not be saved but got %d", res.StatusCode)
+	}
+	res, _ = serve(a, httptest.NewRequest(http.MethodPost, "/jobs/"+other.ID+"/review/apply", nil), cookies)
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the review to not be applied but got %d", res.StatusCode)
+	}
+	j, err := a.jobs.Get(other.ID)
+	if err != nil || j.Playlists[0].Tracks[0].Review != job.REVIEW_PENDING {
+		t.Fatalf("expected the track to still be pending but got %+v, %v", j, err)
+	}
+}
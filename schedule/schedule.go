@@ -0,0 +1,147 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_SCHEDULE runs every night, half an hour after the YouTube quota resets at
+// midnight Pacific Time.
+const (
+	DEFAULT_SCHEDULE = "30 0 * * *"
+	DEFAULT_TIMEZONE = "America/Los_Angeles"
+)
+
+// Schedule is a cron expression with the five usual fields: minute, hour, day of the
+// month, month and day of the week. Each field is either *, a number, a range like
+// 1-5 or a list of them like 1,15, optionally with a step like */2.
+type Schedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	// anyDay and anyWeekday are set when the field is *, as cron matches either the
+	// day of the month or of the week when both are given
+	anyDay     bool
+	anyWeekday bool
+	location   *time.Location
+}
+
+var fields = []struct {
+	name string
+	min  int
+	max  int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of the month", 1, 31},
+	{"month", 1, 12},
+	{"day of the week", 0, 6},
+}
+
+// Parse reads a cron expression, whose times are in the location.
+func Parse(expr string, location *time.Location) (Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: expected %d fields", expr, len(fields))
+	}
+	values := []map[int]bool{}
+	for i, part := range parts {
+		field := fields[i]
+		value, err := parseField(part, field.min, field.max)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid %s in schedule %q: %w", field.name, expr, err)
+		}
+		values = append(values, value)
+	}
+	s := Schedule{
+		minutes:    values[0],
+		hours:      values[1],
+		days:       values[2],
+		months:     values[3],
+		weekdays:   values[4],
+		anyDay:     parts[2] == "*",
+		anyWeekday: parts[4] == "*",
+		location:   location,
+	}
+	// e.g. the 30th of February
+	if s.Next(time.Now()).IsZero() {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: it never runs", expr)
+	}
+	return s, nil
+}
+
+func parseField(field string, min int, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			part = part[:i]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	if len(values) == 0 {
+		return nil, errors.New("no values")
+	}
+	return values, nil
+}
+
+// Next is the first time after t that matches the schedule, or the zero time if none does.
+func (s Schedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	// every combination repeats within a few years, e.g. the 29th of February on a Monday
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		} else if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		} else if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		} else if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+		} else {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s Schedule) matchesDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
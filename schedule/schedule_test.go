@@ -0,0 +1,40 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	pacific, _ := time.LoadLocation(DEFAULT_TIMEZONE)
+	tests := []struct {
+		expr     string
+		now      time.Time
+		expected time.Time
+	}{
+		{DEFAULT_SCHEDULE, time.Date(2023, 5, 10, 12, 0, 0, 0, pacific), time.Date(2023, 5, 11, 0, 30, 0, 0, pacific)},
+		{DEFAULT_SCHEDULE, time.Date(2023, 5, 10, 0, 10, 0, 0, pacific), time.Date(2023, 5, 10, 0, 30, 0, 0, pacific)},
+		{DEFAULT_SCHEDULE, time.Date(2023, 5, 10, 0, 30, 0, 0, pacific), time.Date(2023, 5, 11, 0, 30, 0, 0, pacific)},
+		{"*/15 * * * *", time.Date(2023, 5, 10, 12, 16, 30, 0, pacific), time.Date(2023, 5, 10, 12, 30, 0, 0, pacific)},
+		{"0 9 * * 1-5", time.Date(2023, 5, 12, 10, 0, 0, 0, pacific), time.Date(2023, 5, 15, 9, 0, 0, 0, pacific)},
+		{"0 0 1,15 * *", time.Date(2023, 12, 20, 0, 0, 0, 0, pacific), time.Date(2024, 1, 1, 0, 0, 0, 0, pacific)},
+		{"0 0 29 2 *", time.Date(2023, 3, 1, 0, 0, 0, 0, pacific), time.Date(2024, 2, 29, 0, 0, 0, 0, pacific)},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr, pacific)
+		if err != nil {
+			t.Fatalf("unexpected error %s", err)
+		}
+		if actual := s.Next(test.now); !actual.Equal(test.expected) {
+			t.Errorf("expected %q after %s to be %s but got %s", test.expr, test.now, test.expected, actual)
+		}
+	}
+}
+
+func TestParseRejectsInvalidSchedules(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "0 0 30 2 *", "0 0 31 4,6 *"} {
+		if _, err := Parse(expr, time.UTC); err == nil {
+			t.Errorf("expected %q to be invalid", expr)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -13,15 +14,23 @@ const (
 	SPOTIFY_TOKEN_SESSION_KEY     = "spotify-token"
 	GOOGLE_USER_TOKEN_SESSION_KEY = "google-user"
 	SESSION_NAME                  = "token-session"
+	USER_SESSION_KEY              = "user"
 )
 
 type SessionManager struct {
 	store *sessions.CookieStore
 }
 
-// TODO use a proper cookie store
-func New() SessionManager {
-	return SessionManager{store: sessions.NewCookieStore([]byte("1234"))}
+// KEY_SIZE is the size of the keys that sign and encrypt the session cookies, in bytes.
+const KEY_SIZE = 32
+
+// New returns a manager of sessions kept on cookies signed with authKey and encrypted
+// with encryptionKey, since who logged in on a session unlocks their saved tokens.
+func New(authKey []byte, encryptionKey []byte) (SessionManager, error) {
+	if len(authKey) != KEY_SIZE || len(encryptionKey) != KEY_SIZE {
+		return SessionManager{}, errors.New("the session keys must have 32 bytes, make them with `openssl rand -base64 32`")
+	}
+	return SessionManager{store: sessions.NewCookieStore(authKey, encryptionKey)}, nil
 }
 
 func (s SessionManager) RefreshToken(
@@ -41,6 +50,10 @@ func (s SessionManager) RefreshToken(
 	if err != nil {
 		return nil, err
 	}
+	// not every provider rotates the refresh token
+	if newTokens.RefreshToken == "" {
+		newTokens.RefreshToken = existingTokens.RefreshToken
+	}
 	err = s.UpdateTokens(providerName, newTokens, r, w)
 	if err != nil {
 		return nil, err
@@ -76,3 +89,22 @@ func (s SessionManager) UpdateTokens(provider string, tokens *oauth2.Token, r *h
 	}
 	return session.Save(r, w)
 }
+
+// GetUser returns who logged in on the session, or an empty string if nobody did.
+func (s SessionManager) GetUser(r *http.Request) string {
+	session, err := s.store.Get(r, SESSION_NAME)
+	if err != nil {
+		return ""
+	}
+	user, _ := session.Values[USER_SESSION_KEY].(string)
+	return user
+}
+
+func (s SessionManager) SetUser(user string, r *http.Request, w http.ResponseWriter) error {
+	session, err := s.store.Get(r, SESSION_NAME)
+	if err != nil {
+		return err
+	}
+	session.Values[USER_SESSION_KEY] = user
+	return session.Save(r, w)
+}
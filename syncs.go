@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+// SYNC_RUNS_SHOWN is how many of the most recent sync runs the syncs page shows
+const SYNC_RUNS_SHOWN = 50
+
+type SyncsPageState struct {
+	Syncs   []transfer.SyncState
+	TwoWays []transfer.TwoWayState
+	Runs    []transfer.SyncRun
+	NextRun time.Time
+}
+
+func (a application) syncsHandler(w http.ResponseWriter, r *http.Request) {
+	syncs, err := a.syncs.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	twoWays, err := a.twoWays.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	runs, err := a.runs.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(runs) > SYNC_RUNS_SHOWN {
+		runs = runs[:SYNC_RUNS_SHOWN]
+	}
+	tmpl := template.Must(loadPage("syncs"))
+	err = tmpl.Execute(w, SyncsPageState{Syncs: syncs, TwoWays: twoWays, Runs: runs, NextRun: a.scheduler.Next()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// scheduleSyncHandler adds the pair to the scheduled syncs, or removes it when the
+// scheduled form value is false.
+func (a application) scheduleSyncHandler(w http.ResponseWriter, r *http.Request) {
+	state, ok := a.getSyncState(w, r)
+	if !ok {
+		return
+	}
+	state.Scheduled = r.FormValue("scheduled") == "true"
+	err := a.syncs.Save(state)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/syncs", http.StatusSeeOther)
+}
+
+// runSyncHandler syncs the pair now, in the background.
+func (a application) runSyncHandler(w http.ResponseWriter, r *http.Request) {
+	state, ok := a.getSyncState(w, r)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := a.scheduler.Sync(context.Background(), *state, false); err != nil {
+			log.Printf("sync of %s stopped: %s", state.Name, err)
+		}
+	}()
+	http.Redirect(w, r, "/syncs", http.StatusSeeOther)
+}
+
+// getSyncState returns the pair of the request, as long as the user of the session
+// owns it, since its syncs run with their saved tokens.
+func (a application) getSyncState(w http.ResponseWriter, r *http.Request) (*transfer.SyncState, bool) {
+	state, err := a.syncs.Get(
+		chi.URLParam(r, "origin"),
+		chi.URLParam(r, "destination"),
+		provider.PlaylistID(chi.URLParam(r, "playlist")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if state == nil {
+		http.Error(w, "sync not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !a.checkSyncOwner(w, r, state.Owner) {
+		return nil, false
+	}
+	return state, true
+}
+
+// checkSyncOwner responds with an error unless the user of the session is the owner of
+// the sync.
+func (a application) checkSyncOwner(w http.ResponseWriter, r *http.Request, owner string) bool {
+	user := a.sessionManager.GetUser(r)
+	if user == "" {
+		http.Error(w, "not logged in", http.StatusUnauthorized)
+		return false
+	}
+	if owner == "" {
+		// synced before owners were kept
+		http.Error(w, "sync the playlist from the home page first", http.StatusForbidden)
+		return false
+	} else if owner != user {
+		http.Error(w, "the sync belongs to another user", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// resolveConflictHandler sets the resolution of a conflict of a two-way sync, which is
+// applied on its next sync. Only the owner of the sync can resolve its conflicts, which
+// are identified by the IDs of their track.
+func (a application) resolveConflictHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := a.twoWays.Get(
+		chi.URLParam(r, "a"),
+		chi.URLParam(r, "b"),
+		provider.PlaylistID(chi.URLParam(r, "playlist")))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if state == nil {
+		http.Error(w, "sync not found", http.StatusNotFound)
+		return
+	}
+	if !a.checkSyncOwner(w, r, state.Owner) {
+		return
+	}
+	resolution := r.FormValue("resolution")
+	if resolution != transfer.RESOLUTION_KEEP && resolution != transfer.RESOLUTION_REMOVE {
+		http.Error(w, fmt.Sprintf("invalid resolution %s", resolution), http.StatusBadRequest)
+		return
+	}
+	found := false
+	for i, c := range state.Conflicts {
+		if c.Link.A == r.FormValue("trackA") && c.Link.B == r.FormValue("trackB") {
+			state.Conflicts[i].Resolution = resolution
+			found = true
+		}
+	}
+	if !found {
+		http.Error(w, "conflict not found", http.StatusNotFound)
+		return
+	}
+	if err := a.twoWays.Save(state); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/syncs", http.StatusSeeOther)
+}
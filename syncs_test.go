@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/paulombcosta/waltz/transfer"
+)
+
+func resolveConflict(t *testing.T, a application, cookies []*http.Cookie) *http.Response {
+	form := url.Values{"resolution": {transfer.RESOLUTION_REMOVE}, "trackA": {"track-a"}, "trackB": {"track-b"}}
+	r := httptest.NewRequest(http.MethodPost, "/two-way-syncs/spotify/google/playlist-a/conflicts", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, _ := serve(a, r, cookies)
+	return res
+}
+
+func TestOnlyTheOwnerShouldResolveConflicts(t *testing.T) {
+	a := newTestApplication(t)
+	state := &transfer.TwoWayState{
+		A:         "spotify",
+		B:         "google",
+		PlaylistA: "playlist-a",
+		PlaylistB: "playlist-b",
+		Owner:     "bob",
+		Links:     []transfer.Link{},
+		Conflicts: []transfer.Conflict{{Link: transfer.Link{A: "track-a", B: "track-b"}, RemovedFrom: "google"}},
+	}
+	if err := a.twoWays.Save(state); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if res := resolveConflict(t, a, nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected to log in first but got %d", res.StatusCode)
+	}
+	if res := resolveConflict(t, a, loggedIn(t, a, "alice")); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the conflicts of another user to be forbidden but got %d", res.StatusCode)
+	}
+	saved, err := a.twoWays.Get("spotify", "google", "playlist-a")
+	if err != nil || saved.Conflicts[0].Resolution != "" {
+		t.Fatalf("expected the conflict to be left unresolved but got %+v, %v", saved, err)
+	}
+
+	if res := resolveConflict(t, a, loggedIn(t, a, "bob")); res.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the owner to resolve the conflict but got %d", res.StatusCode)
+	}
+	saved, err = a.twoWays.Get("spotify", "google", "playlist-a")
+	if err != nil || saved.Conflicts[0].Resolution != transfer.RESOLUTION_REMOVE {
+		t.Fatalf("expected the conflict to be resolved but got %+v, %v", saved, err)
+	}
+}
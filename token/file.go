@@ -0,0 +1,211 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// DEFAULT_USER is the user of credentials files used by a single person.
+const DEFAULT_USER = "default"
+
+// KEY_SIZE is the size of the keys of encrypted credentials files, in bytes.
+const KEY_SIZE = 32
+
+// ParseKey decodes a base64 key, e.g. made with `openssl rand -base64 32`.
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("invalid key: it has %d bytes instead of %d", len(key), KEY_SIZE)
+	}
+	return key, nil
+}
+
+// NewCredentialsFile returns the file at path, encrypted with the key.
+func NewCredentialsFile(path string, key []byte) (*CredentialsFile, error) {
+	if len(key) == 0 {
+		return nil, errors.New("no key to encrypt the credentials with, make one with `openssl rand -base64 32`")
+	} else if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("invalid key: it has %d bytes instead of %d", len(key), KEY_SIZE)
+	}
+	return &CredentialsFile{Path: path, key: key}, nil
+}
+
+// CredentialsFile keeps the tokens of each user and provider on a file only its owner
+// can read, encrypted with AES-256-GCM. Plain files, written by older versions, are
+// encrypted on the next write.
+type CredentialsFile struct {
+	Path string
+	key  []byte
+	mu   sync.Mutex
+}
+
+// credentials is the content of the file, Sealed is the nonce followed by the encrypted
+// users, Users is only set on the plain files of older versions.
+type credentials struct {
+	Users  map[string]map[string]*oauth2.Token `json:"users,omitempty"`
+	Sealed []byte                              `json:"sealed,omitempty"`
+}
+
+// TokenProvider returns the tokens of the user on the provider.
+func (f *CredentialsFile) TokenProvider(user string, provider string) *FileTokenProvider {
+	return &FileTokenProvider{User: user, Provider: provider, file: f}
+}
+
+// read returns the tokens of each user and provider, there are none when the file
+// doesn't exist.
+func (f *CredentialsFile) read() (map[string]map[string]*oauth2.Token, error) {
+	data, err := os.ReadFile(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]map[string]*oauth2.Token{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var c credentials
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", f.Path, err)
+	}
+	if len(c.Sealed) > 0 {
+		plain, err := f.open(c.Sealed)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt credentials file %s: %w", f.Path, err)
+		}
+		if err := json.Unmarshal(plain, &c.Users); err != nil {
+			return nil, fmt.Errorf("invalid credentials file %s: %w", f.Path, err)
+		}
+	}
+	if c.Users == nil {
+		c.Users = map[string]map[string]*oauth2.Token{}
+	}
+	return c.Users, nil
+}
+
+// write replaces the file at once, so it's never left half written.
+func (f *CredentialsFile) write(users map[string]map[string]*oauth2.Token) error {
+	plain, err := json.Marshal(users)
+	if err != nil {
+		return err
+	}
+	sealed, err := f.seal(plain)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(credentials{Sealed: sealed}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.Path)
+}
+
+func (f *CredentialsFile) seal(plain []byte) ([]byte, error) {
+	aead, err := f.aead()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (f *CredentialsFile) open(sealed []byte) ([]byte, error) {
+	aead, err := f.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func (f *CredentialsFile) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(f.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// FileTokenProvider keeps the tokens of a user on a CredentialsFile, so they can be used
+// without a browser session, e.g. by the command line. Refreshed tokens are saved back,
+// along with the refresh token when the provider rotates it.
+type FileTokenProvider struct {
+	User     string
+	Provider string
+	file     *CredentialsFile
+}
+
+func (t *FileTokenProvider) GetToken() (*oauth2.Token, error) {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	users, err := t.file.read()
+	if err != nil {
+		return nil, err
+	}
+	return users[t.User][t.Provider], nil
+}
+
+func (t *FileTokenProvider) RefreshToken() (*oauth2.Token, error) {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	users, err := t.file.read()
+	if err != nil {
+		return nil, err
+	}
+	tokens := users[t.User][t.Provider]
+	if tokens == nil {
+		return nil, fmt.Errorf("no tokens for provider %s", t.Provider)
+	}
+	newTokens, err := refresh(t.Provider, tokens)
+	if err != nil {
+		return nil, err
+	}
+	users[t.User][t.Provider] = newTokens
+	return newTokens, t.file.write(users)
+}
+
+// Set saves the tokens, e.g. after logging in.
+func (t *FileTokenProvider) Set(tokens *oauth2.Token) error {
+	t.file.mu.Lock()
+	defer t.file.mu.Unlock()
+	users, err := t.file.read()
+	if err != nil {
+		return err
+	}
+	if users[t.User] == nil {
+		users[t.User] = map[string]*oauth2.Token{}
+	}
+	users[t.User][t.Provider] = tokens
+	return t.file.write(users)
+}
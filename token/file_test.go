@@ -0,0 +1,101 @@
+package token
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+const testKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestFileTokenProviderShouldKeepTheTokensOfEachUserAndProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "waltz", "credentials.json")
+	key, _ := ParseKey(testKey)
+	file, err := NewCredentialsFile(path, key)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	spotify := file.TokenProvider(DEFAULT_USER, "spotify")
+	google := file.TokenProvider(DEFAULT_USER, "google")
+
+	tokens, err := spotify.GetToken()
+	if err != nil || tokens != nil {
+		t.Fatalf("expected no tokens before logging in but got %v, %v", tokens, err)
+	}
+	if err := spotify.Set(&oauth2.Token{AccessToken: "spotify-access", RefreshToken: "spotify-refresh"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if err := google.Set(&oauth2.Token{AccessToken: "google-access", RefreshToken: "google-refresh"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if err := file.TokenProvider("someone", "spotify").Set(&oauth2.Token{RefreshToken: "someone-refresh"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	tokens, err = spotify.GetToken()
+	if err != nil || tokens.RefreshToken != "spotify-refresh" {
+		t.Fatalf("expected the spotify tokens to be kept but got %v, %v", tokens, err)
+	}
+	tokens, err = file.TokenProvider("someone", "google").GetToken()
+	if err != nil || tokens != nil {
+		t.Fatalf("expected no tokens of another user but got %v, %v", tokens, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected only the user to read the file but got %v, %v", info.Mode(), err)
+	}
+}
+
+func TestCredentialsFileShouldOnlyBeReadWithItsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	key, err := ParseKey(testKey)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	file, _ := NewCredentialsFile(path, key)
+	if err := file.TokenProvider(DEFAULT_USER, "spotify").Set(&oauth2.Token{RefreshToken: "secret-refresh"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "secret-refresh") {
+		t.Fatalf("expected the tokens to be encrypted but got %s", data)
+	}
+	tokens, err := file.TokenProvider(DEFAULT_USER, "spotify").GetToken()
+	if err != nil || tokens.RefreshToken != "secret-refresh" {
+		t.Fatalf("expected the tokens to be decrypted but got %v, %v", tokens, err)
+	}
+	otherKey, _ := ParseKey("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	other, _ := NewCredentialsFile(path, otherKey)
+	if _, err := other.TokenProvider(DEFAULT_USER, "spotify").GetToken(); err == nil {
+		t.Fatalf("expected the file not to be read with another key")
+	}
+	if _, err := NewCredentialsFile(path, nil); err == nil {
+		t.Fatalf("expected a key to be required")
+	}
+}
+
+func TestCredentialsFileShouldEncryptPlainFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	plain := `{"users": {"default": {"google": {"access_token": "access", "refresh_token": "plain-refresh"}}}}`
+	if err := os.WriteFile(path, []byte(plain), 0600); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	key, _ := ParseKey(testKey)
+	file, _ := NewCredentialsFile(path, key)
+
+	tokens, err := file.TokenProvider(DEFAULT_USER, "google").GetToken()
+	if err != nil || tokens.RefreshToken != "plain-refresh" {
+		t.Fatalf("expected the plain tokens to be read but got %v, %v", tokens, err)
+	}
+	if err := file.TokenProvider(DEFAULT_USER, "spotify").Set(&oauth2.Token{RefreshToken: "spotify-refresh"}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), "plain-refresh") {
+		t.Fatalf("expected the file to be encrypted once written but got %s", data)
+	}
+}
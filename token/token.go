@@ -3,7 +3,9 @@ package token
 import (
 	"net/http"
 
+	"github.com/markbates/goth"
 	"github.com/paulombcosta/waltz/session"
+	"github.com/paulombcosta/waltz/store"
 	"golang.org/x/oauth2"
 )
 
@@ -37,3 +39,28 @@ func (t CookieStoreTokenProvider) GetToken() (*oauth2.Token, error) {
 func (t CookieStoreTokenProvider) RefreshToken() (*oauth2.Token, error) {
 	return t.Session.RefreshToken(t.Provider, t.Req, t.Writer)
 }
+
+func refresh(providerName string, tokens *oauth2.Token) (*oauth2.Token, error) {
+	provider, err := goth.GetProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	newTokens, err := provider.RefreshToken(tokens.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	// not every provider rotates the refresh token
+	if newTokens.RefreshToken == "" {
+		newTokens.RefreshToken = tokens.RefreshToken
+	}
+	return newTokens, nil
+}
+
+// PLAIN_TOKENS_BUCKET had, in plain text, the tokens of whoever logged in last, before
+// they were kept per user on the credentials file.
+const PLAIN_TOKENS_BUCKET = "tokens"
+
+// DropPlainTokens removes the tokens older versions saved in plain text.
+func DropPlainTokens(s *store.Store) error {
+	return s.DeleteBucket(PLAIN_TOKENS_BUCKET)
+}
@@ -0,0 +1,180 @@
+package transfer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/paulombcosta/waltz/provider"
+)
+
+// BROADCAST_HISTORY is how many events of each job are kept to be replayed to
+// subscribers that reconnect, the oldest are dropped first.
+const BROADCAST_HISTORY = 10000
+
+// BROADCAST_RETENTION is how long the events of a job are kept after its last one,
+// when nobody is subscribed to it.
+const BROADCAST_RETENTION = time.Hour
+
+// BROADCAST_BUFFER is how many events a subscriber can fall behind before it's dropped,
+// so a slow client never blocks a transfer. It can subscribe again from its last event.
+const BROADCAST_BUFFER = 256
+
+// BroadcastEvent is a progress event with its position on the stream of the broadcaster,
+// IDs only grow, across jobs and restarts too.
+type BroadcastEvent struct {
+	ID int64
+	ProgressEvent
+}
+
+// Broadcaster fans out the progress of each job to many subscribers, keeping its recent
+// events so subscribers that reconnect get what they missed.
+type Broadcaster struct {
+	mu      sync.Mutex
+	lastID  int64
+	streams map[string]*jobStream
+	now     func() time.Time
+}
+
+type jobStream struct {
+	events      []BroadcastEvent
+	subscribers map[chan BroadcastEvent]bool
+	updatedAt   time.Time
+}
+
+// NewBroadcaster returns a broadcaster whose IDs start from the time it's made, in
+// milliseconds, so clients reconnecting after a restart don't skip the new events as
+// long as fewer than a thousand events a second were published before it. The IDs stay
+// below 2^53, so clients can parse them as JSON numbers.
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{lastID: time.Now().UnixMilli(), streams: map[string]*jobStream{}, now: time.Now}
+}
+
+// Publisher publishes to the subscribers of the job.
+func (b *Broadcaster) Publisher(jobID string) BroadcastPublisher {
+	return BroadcastPublisher{broadcaster: b, jobID: jobID}
+}
+
+// Subscribe returns the events of the job after lastEventID, and a channel with the
+// ones published from now on. The channel is closed when the subscriber falls too far
+// behind, unsubscribe must be called once the subscriber is done.
+func (b *Broadcaster) Subscribe(jobID string, lastEventID int64) ([]BroadcastEvent, <-chan BroadcastEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stream := b.stream(jobID)
+	missed := []BroadcastEvent{}
+	for _, e := range stream.events {
+		if e.ID > lastEventID {
+			missed = append(missed, e)
+		}
+	}
+	ch := make(chan BroadcastEvent, BROADCAST_BUFFER)
+	stream.subscribers[ch] = true
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if stream.subscribers[ch] {
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+		stream.updatedAt = b.now()
+	}
+	return missed, ch, unsubscribe
+}
+
+func (b *Broadcaster) publish(jobID string, e ProgressEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.evict()
+	b.lastID++
+	event := BroadcastEvent{ID: b.lastID, ProgressEvent: e}
+	stream := b.stream(jobID)
+	stream.events = append(stream.events, event)
+	if len(stream.events) > BROADCAST_HISTORY {
+		stream.events = stream.events[len(stream.events)-BROADCAST_HISTORY:]
+	}
+	stream.updatedAt = b.now()
+	for ch := range stream.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(stream.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *Broadcaster) stream(jobID string) *jobStream {
+	stream, ok := b.streams[jobID]
+	if !ok {
+		stream = &jobStream{events: []BroadcastEvent{}, subscribers: map[chan BroadcastEvent]bool{}, updatedAt: b.now()}
+		b.streams[jobID] = stream
+	}
+	return stream
+}
+
+// evict drops the streams nobody watched for BROADCAST_RETENTION.
+func (b *Broadcaster) evict() {
+	for jobID, stream := range b.streams {
+		if len(stream.subscribers) == 0 && b.now().Sub(stream.updatedAt) > BROADCAST_RETENTION {
+			delete(b.streams, jobID)
+		}
+	}
+}
+
+// BroadcastPublisher publishes the progress of a job to the subscribers of a Broadcaster.
+type BroadcastPublisher struct {
+	broadcaster *Broadcaster
+	jobID       string
+}
+
+func (p BroadcastPublisher) Publish(progressType string, body string) error {
+	return p.PublishWithCode(progressType, body, "")
+}
+
+func (p BroadcastPublisher) PublishWithCode(progressType string, body string, code string) error {
+	e := newEvent(progressType)
+	e.Body = body
+	e.Code = code
+	return p.PublishEvent(e)
+}
+
+func (p BroadcastPublisher) PublishEvent(e ProgressEvent) error {
+	if e.JobID == "" {
+		e.JobID = p.jobID
+	}
+	p.broadcaster.publish(p.jobID, e)
+	return nil
+}
+
+// Fail reports the error that stopped the job along with its code.
+func (p BroadcastPublisher) Fail(err error) {
+	_ = p.PublishWithCode(PROGRESS_TRANFER_ERROR, err.Error(), provider.Code(err))
+}
+
+// Final tells whether no more events follow the event, until the job is resumed.
+func Final(e ProgressEvent) bool {
+	return e.Type == PROGRESS_TRANSFER_DONE || e.Type == PROGRESS_TRANFER_ERROR || e.Type == PROGRESS_CANCELLED
+}
+
+// multiPublisher publishes every event to all of its publishers.
+type multiPublisher []ProgressPublisher
+
+func (p multiPublisher) Publish(progressType string, body string) error {
+	return p.PublishWithCode(progressType, body, "")
+}
+
+func (p multiPublisher) PublishWithCode(progressType string, body string, code string) error {
+	e := newEvent(progressType)
+	e.Body = body
+	e.Code = code
+	return p.PublishEvent(e)
+}
+
+func (p multiPublisher) PublishEvent(e ProgressEvent) error {
+	errs := []error{}
+	for _, publisher := range p {
+		errs = append(errs, sendEvent(publisher, e))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,265 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const PLANS_BUCKET = "plans"
+
+var ErrPlanNotFound = errors.New("plan not found")
+
+// Plan is what a transfer would do, computed without changing the destination.
+type Plan struct {
+	ID          string         `json:"id"`
+	Origin      string         `json:"origin"`
+	Destination string         `json:"destination"`
+	Playlists   []PlaylistPlan `json:"playlists"`
+	// Cost is the quota the transfer would spend on each provider that has one, the
+	// tracks that weren't on the match cache while planning are counted as searched again
+	Cost map[string]int `json:"cost"`
+}
+
+type PlaylistPlan struct {
+	ID   provider.PlaylistID `json:"id"`
+	Name string              `json:"name"`
+	// Exists is false when the playlist would be created on the destination
+	Exists bool `json:"exists"`
+	// Add are the destination tracks that would be added
+	Add []provider.Track `json:"add"`
+	// Present are the origin tracks whose match is already on the destination playlist
+	Present []provider.Track `json:"present"`
+	// Unmatched are the origin tracks without a match on the destination
+	Unmatched []provider.Track `json:"unmatched"`
+}
+
+// Count is the number of tracks on the origin playlist.
+func (p PlaylistPlan) Count() int {
+	return len(p.Add) + len(p.Present) + len(p.Unmatched)
+}
+
+// Plan resolves the playlists and the matches of their tracks to compute what the
+// transfer would do, without creating playlists or adding tracks.
+func (t TransferClient) Plan(ctx context.Context) (*Plan, error) {
+	playlists := t.playlists
+	if t.job != nil {
+		playlists = []provider.Playlist{}
+		for _, p := range t.job.Playlists {
+			playlists = append(playlists, p.Playlist)
+		}
+	}
+	if playlists == nil {
+		return nil, errors.New("cannot plan: list is null")
+	}
+	if len(playlists) == 0 {
+		return nil, errors.New("cannot plan: list is empty")
+	}
+
+	plan := &Plan{
+		ID:          job.NewID(),
+		Origin:      t.origin.Name(),
+		Destination: t.destination.Name(),
+		Playlists:   []PlaylistPlan{},
+	}
+	// listed once instead of for every playlist, the transfer finds each one by its name
+	destinationPlaylists, err := t.destination.GetPlaylists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for i, playlist := range playlists {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		t.publishEvent(planEvent(PROGRESS_STARTED_PLAYLSIT, i, playlist))
+		playlistPlan, err := t.planPlaylist(ctx, playlist, destinationPlaylists, counts)
+		if err != nil {
+			return nil, err
+		}
+		plan.Playlists = append(plan.Playlists, *playlistPlan)
+		t.publishEvent(planEvent(PROGRESS_PLAYLIST_DONE, i, playlist))
+	}
+	plan.Cost = t.estimateCost(plan, counts)
+	return plan, nil
+}
+
+// planEvent is an event about a playlist being planned, which isn't part of a job.
+func planEvent(typeOf string, index int, playlist provider.Playlist) ProgressEvent {
+	e := newEvent(typeOf)
+	e.Playlist = &index
+	e.PlaylistID = string(playlist.ID)
+	e.PlaylistName = playlist.Name
+	if typeOf == PROGRESS_STARTED_PLAYLSIT {
+		e.Body = playlist.Name
+	}
+	return e
+}
+
+func (t TransferClient) planPlaylist(ctx context.Context, playlist provider.Playlist, destinationPlaylists []provider.Playlist, counts map[string]int) (*PlaylistPlan, error) {
+	playlistPlan := &PlaylistPlan{
+		ID:        playlist.ID,
+		Name:      playlist.Name,
+		Add:       []provider.Track{},
+		Present:   []provider.Track{},
+		Unmatched: []provider.Track{},
+	}
+	present := map[string]bool{}
+	destinationID := findPlaylistByName(destinationPlaylists, playlist.Name)
+	if destinationID != "" {
+		playlistPlan.Exists = true
+		destinationPlaylist, err := t.destination.GetFullPlaylist(ctx, string(destinationID))
+		if err != nil {
+			return nil, err
+		}
+		counts[provider.OPERATION_READ] += len(destinationPlaylist.Tracks)
+		for _, track := range destinationPlaylist.Tracks {
+			present[track.ID] = true
+		}
+	}
+
+	fullPlaylist, err := t.origin.GetFullPlaylist(ctx, string(playlist.ID))
+	if err != nil {
+		return nil, err
+	}
+	tracks := map[int]provider.Track{}
+	for i, track := range fullPlaylist.Tracks {
+		tracks[i] = track
+	}
+	results := make([]resolvedTrack, len(fullPlaylist.Tracks))
+	err = t.resolveTracks(ctx, t.destination, tracks, func(r resolvedTrack) error {
+		if r.err != nil && !errors.Is(r.err, provider.ErrNotFound) {
+			return r.err
+		}
+		results[r.index] = r
+		if !r.cached {
+			counts[provider.OPERATION_SEARCH]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// tracks are sorted in the order of the origin, so repeated tracks are added once
+	for i, r := range results {
+		track := fullPlaylist.Tracks[i]
+		if !r.result.Matched {
+			playlistPlan.Unmatched = append(playlistPlan.Unmatched, track)
+		} else if present[r.result.Track.ID] {
+			playlistPlan.Present = append(playlistPlan.Present, track)
+		} else {
+			present[r.result.Track.ID] = true
+			match := r.result.Track
+			if match.Name == "" {
+				// matches found on the cache only have an ID
+				match.Name = track.Name
+				match.Artists = track.Artists
+			}
+			playlistPlan.Add = append(playlistPlan.Add, match)
+		}
+	}
+	if !playlistPlan.Exists {
+		counts[provider.OPERATION_CREATE]++
+	}
+	// finding the playlist by its name lists every page of the playlists of the user
+	pages := (len(destinationPlaylists) + provider.PLAYLISTS_PAGE_SIZE - 1) / provider.PLAYLISTS_PAGE_SIZE
+	if pages == 0 {
+		pages = 1
+	}
+	counts[provider.OPERATION_FIND] += pages
+	counts[provider.OPERATION_ADD] += len(playlistPlan.Add)
+	return playlistPlan, nil
+}
+
+// findPlaylistByName is the ID of the first playlist with the name, like the providers
+// find it, or empty when there is none.
+func findPlaylistByName(playlists []provider.Playlist, name string) provider.PlaylistID {
+	for _, p := range playlists {
+		if p.Name == name {
+			return p.ID
+		}
+	}
+	return ""
+}
+
+// estimateCost adds up the cost of the calls the transfer would make on each provider.
+func (t TransferClient) estimateCost(plan *Plan, destinationCounts map[string]int) map[string]int {
+	originRead := 0
+	for _, p := range plan.Playlists {
+		originRead += t.estimate(t.origin, provider.OPERATION_READ, p.Count())
+	}
+	cost := map[string]int{}
+	if originRead > 0 {
+		cost[t.origin.Name()] += originRead
+	}
+	destinationCost := 0
+	for operation, count := range destinationCounts {
+		destinationCost += t.estimate(t.destination, operation, count)
+	}
+	if destinationCost > 0 {
+		cost[t.destination.Name()] += destinationCost
+	}
+	return cost
+}
+
+func (t TransferClient) estimate(p provider.Provider, operation string, count int) int {
+	estimator, ok := p.(provider.CostEstimator)
+	if !ok {
+		return 0
+	}
+	return estimator.EstimateCost(operation, count)
+}
+
+// startDryRun computes the plan, saves it and publishes it as JSON.
+func (t TransferClient) startDryRun(ctx context.Context) error {
+	plan, err := t.Plan(ctx)
+	if err != nil {
+		return err
+	}
+	if t.plans != nil {
+		if err := t.plans.Save(plan); err != nil {
+			return err
+		}
+	}
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	t.publish(PROGRESS_PLAN, string(data))
+	return nil
+}
+
+type PlanRepository interface {
+	Save(p *Plan) error
+	Get(id string) (*Plan, error)
+}
+
+func NewPlanStore(s *store.Store) PlanStore {
+	return PlanStore{store: s}
+}
+
+// PlanStore saves plans on the application database, so they can be viewed later.
+type PlanStore struct {
+	store *store.Store
+}
+
+func (s PlanStore) Save(p *Plan) error {
+	return s.store.Put(PLANS_BUCKET, p.ID, p)
+}
+
+func (s PlanStore) Get(id string) (*Plan, error) {
+	var p Plan
+	found, err := s.store.Get(PLANS_BUCKET, id, &p)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrPlanNotFound
+	}
+	return &p, nil
+}
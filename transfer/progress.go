@@ -0,0 +1,154 @@
+package transfer
+
+import (
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+)
+
+// PROTOCOL_VERSION is the version of the progress events, increased on changes that
+// break clients. The events are described by docs/progress-events.schema.json.
+const PROTOCOL_VERSION = 1
+
+// ProgressEvent is what transfers publish about their progress.
+type ProgressEvent struct {
+	Version int    `json:"version"`
+	Type    string `json:"type"`
+	JobID   string `json:"jobId,omitempty"`
+	// Playlist is the index of the playlist in the job, set on the events of a playlist
+	Playlist     *int   `json:"playlist,omitempty"`
+	PlaylistID   string `json:"playlistId,omitempty"`
+	PlaylistName string `json:"playlistName,omitempty"`
+	// Track is the full name of the origin track, set on the events of a track
+	Track string      `json:"track,omitempty"`
+	Match *EventMatch `json:"match,omitempty"`
+	// Reason is why the track was skipped or failed
+	Reason string       `json:"reason,omitempty"`
+	Counts *EventCounts `json:"counts,omitempty"`
+	// Body is the content of the other events, e.g. the remaining quota
+	Body string `json:"body,omitempty"`
+	// Code identifies the kind of error that stopped or paused the transfer, see provider.Code
+	Code string    `json:"code,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// EventMatch is the destination track a track was matched with.
+type EventMatch struct {
+	ID string `json:"id"`
+	// Score is how confident the match is, from 0 to 1, it's 1 for cached matches
+	Score float64 `json:"score"`
+}
+
+// EventCounts is the progress of a playlist, Done is how many of its Total tracks were
+// added, skipped or failed.
+type EventCounts struct {
+	Done    int `json:"done"`
+	Total   int `json:"total"`
+	Added   int `json:"added"`
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	Removed int `json:"removed"`
+}
+
+// EventPublisher is implemented by publishers of the whole events, the others only
+// get their type and body, or track name.
+type EventPublisher interface {
+	PublishEvent(e ProgressEvent) error
+}
+
+func newEvent(typeOf string) ProgressEvent {
+	return ProgressEvent{Version: PROTOCOL_VERSION, Type: typeOf, Time: time.Now()}
+}
+
+// playlistEvent is an event about a playlist of the job, with its progress.
+func playlistEvent(typeOf string, j *job.Job, playlist *job.Playlist) ProgressEvent {
+	e := newEvent(typeOf)
+	e.JobID = j.ID
+	for i := range j.Playlists {
+		if &j.Playlists[i] == playlist {
+			index := i
+			e.Playlist = &index
+		}
+	}
+	e.PlaylistID = string(playlist.ID)
+	e.PlaylistName = playlist.Name
+	e.Counts = countPlaylist(playlist)
+	return e
+}
+
+func countPlaylist(playlist *job.Playlist) *EventCounts {
+	counts := &EventCounts{Total: len(playlist.Tracks), Removed: playlist.Removed}
+	for _, t := range playlist.Tracks {
+		if t.State == job.TRACK_ADDED {
+			counts.Added++
+		} else if t.State == job.TRACK_SKIPPED {
+			counts.Skipped++
+		} else if t.State == job.TRACK_FAILED {
+			counts.Failed++
+		}
+	}
+	counts.Done = counts.Added + counts.Skipped + counts.Failed
+	return counts
+}
+
+func (t TransferClient) publish(typeOf string, content string) {
+	t.publishWithCode(typeOf, content, "")
+}
+
+func (t TransferClient) publishWithCode(typeOf string, content string, code string) {
+	e := newEvent(typeOf)
+	e.Body = content
+	e.Code = code
+	if t.job != nil {
+		e.JobID = t.job.ID
+	}
+	t.publishEvent(e)
+}
+
+// publishPlaylist publishes an event about the playlist, its start has the name as the
+// body too, for publishers that don't take events.
+func (t TransferClient) publishPlaylist(typeOf string, j *job.Job, playlist *job.Playlist) {
+	e := playlistEvent(typeOf, j, playlist)
+	if typeOf == PROGRESS_STARTED_PLAYLSIT {
+		e.Body = playlist.Name
+	}
+	t.publishEvent(e)
+}
+
+// publishTrack publishes an event about a track of the playlist.
+func (t TransferClient) publishTrack(typeOf string, j *job.Job, playlist *job.Playlist, track *job.Track) {
+	e := playlistEvent(typeOf, j, playlist)
+	e.Track = track.FullName()
+	e.Reason = track.Reason
+	if track.DestinationID != "" {
+		e.Match = &EventMatch{ID: track.DestinationID, Score: track.Score}
+	}
+	t.publishEvent(e)
+}
+
+// publishNamedTrack publishes an event about a track that is only known by its name,
+// e.g. a track removed from the destination.
+func (t TransferClient) publishNamedTrack(typeOf string, j *job.Job, playlist *job.Playlist, name string) {
+	e := playlistEvent(typeOf, j, playlist)
+	e.Track = name
+	t.publishEvent(e)
+}
+
+func (t TransferClient) publishEvent(e ProgressEvent) {
+	_ = sendEvent(t.publisher, e)
+}
+
+// sendEvent publishes the event, or its type and body to publishers that don't take events.
+func sendEvent(publisher ProgressPublisher, e ProgressEvent) error {
+	if p, ok := publisher.(EventPublisher); ok {
+		return p.PublishEvent(e)
+	}
+	body := e.Body
+	if body == "" {
+		body = e.Track
+	}
+	if p, ok := publisher.(CodePublisher); ok {
+		return p.PublishWithCode(e.Type, body, e.Code)
+	}
+	return publisher.Publish(e.Type, body)
+}
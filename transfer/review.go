@@ -0,0 +1,116 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/match"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/retry"
+)
+
+// REVIEW_CANDIDATES is how many search results are kept for every track to review
+const REVIEW_CANDIDATES = 3
+
+// reviewTrack records the score of the match, listing the track for review with its
+// best candidates when it has no match or a low confidence one.
+func reviewTrack(t *job.Track, result match.Result) {
+	t.Score = result.Score
+	if !result.LowConfidence() {
+		return
+	}
+	t.Review = job.REVIEW_PENDING
+	t.Candidates = []job.Candidate{}
+	for _, c := range result.Candidates {
+		if len(t.Candidates) == REVIEW_CANDIDATES {
+			break
+		}
+		t.Candidates = append(t.Candidates, job.Candidate{Track: c.Track, Score: c.Score})
+	}
+}
+
+// ApplyReview adds the tracks resolved while reviewing the job to the destination,
+// replacing the match they were added with, if any. The job is saved after every track.
+// It takes the same locks as running the job, so it doesn't race the syncs of its playlists.
+func (r *Runner) ApplyReview(ctx context.Context, j *job.Job, destination provider.Provider) error {
+	keys := r.lockKeys(j)
+	if !r.acquire(keys...) {
+		return fmt.Errorf("job %s: %w", j.ID, ErrAlreadyRunning)
+	}
+	defer r.release(keys...)
+	destination = retry.Wrap(destination, retry.DefaultPolicy())
+	for i := range j.Playlists {
+		playlist := &j.Playlists[i]
+		if playlist.DestinationID == "" {
+			// resolved before the playlist was transferred, it's applied later
+			continue
+		}
+		for k := range playlist.Tracks {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			t := &playlist.Tracks[k]
+			if t.Review != job.REVIEW_RESOLVED {
+				continue
+			}
+			if err := r.applyResolution(ctx, j, playlist, t, destination); err != nil {
+				return err
+			}
+			if err := r.jobs.Save(j); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) applyResolution(ctx context.Context, j *job.Job, playlist *job.Playlist, t *job.Track, destination provider.Provider) error {
+	if t.State != job.TRACK_ADDED || t.DestinationID != t.Resolution {
+		if t.State == job.TRACK_ADDED {
+			// the match added by the transfer is wrong, other occurrences of it were on
+			// the playlist before the transfer appended it or were added since
+			err := destination.RemoveLastFromPlaylist(ctx, playlist.DestinationID, t.DestinationID)
+			if err != nil && !errors.Is(err, provider.ErrNotFound) {
+				return err
+			}
+			t.State = job.TRACK_SKIPPED
+			t.DestinationID = ""
+		}
+		err := destination.AddToPlaylist(ctx, playlist.DestinationID, t.Resolution)
+		if errors.Is(err, provider.ErrNotFound) {
+			t.State = job.TRACK_FAILED
+			t.Reason = fmt.Sprintf("not found on %s", destination.Name())
+			t.Review = job.REVIEW_PENDING
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	t.State = job.TRACK_ADDED
+	t.DestinationID = t.Resolution
+	t.Reason = ""
+	t.Review = job.REVIEW_APPLIED
+	if r.cache != nil {
+		if err := r.cache.Put(destination.Name(), t.Track, provider.TrackID(t.Resolution)); err != nil {
+			return err
+		}
+	}
+	if j.Mode == MODE_SYNC && r.syncs != nil {
+		return r.syncResolution(j, playlist, t)
+	}
+	return nil
+}
+
+// syncResolution remembers the resolution on the sync state, so the next syncs know
+// the track is on the destination.
+func (r *Runner) syncResolution(j *job.Job, playlist *job.Playlist, t *job.Track) error {
+	state, err := r.syncs.Get(j.Origin, j.Destination, playlist.ID)
+	if err != nil || state == nil {
+		return err
+	}
+	state.Tracks[t.ID] = SyncedTrack{DestinationID: t.DestinationID, Name: t.FullName()}
+	return r.syncs.Save(state)
+}
@@ -0,0 +1,265 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/paulombcosta/waltz/cache"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+)
+
+const RUNNER_INTERVAL = time.Minute
+
+var ErrAlreadyRunning = errors.New("already running")
+
+// ProviderFactory returns the providers a job transfers between, without depending
+// on a request being in progress.
+type ProviderFactory func(j *job.Job) (origin provider.Provider, destination provider.Provider, err error)
+
+// Runner runs transfer jobs, making sure the same job never runs twice at once, and
+// resumes paused jobs in the background once they are due.
+type Runner struct {
+	jobs      job.Repository
+	providers ProviderFactory
+	cache     cache.MatchCache
+	plans     PlanRepository
+	syncs     SyncRepository
+	twoWays   TwoWayRepository
+	runs      RunRepository
+	events    *Broadcaster
+	workers   int
+	now       func() time.Time
+	mu        sync.Mutex
+	running   map[string]bool
+}
+
+func NewRunner(jobs job.Repository, providers ProviderFactory, c cache.MatchCache) *Runner {
+	return &Runner{
+		jobs:      jobs,
+		providers: providers,
+		cache:     c,
+		now:       time.Now,
+		running:   map[string]bool{},
+	}
+}
+
+// WithWorkers sets how many tracks each job matches at the same time.
+func (r *Runner) WithWorkers(n int) *Runner {
+	r.workers = n
+	return r
+}
+
+// WithPlans sets where the plans of dry runs are saved.
+func (r *Runner) WithPlans(plans PlanRepository) *Runner {
+	r.plans = plans
+	return r
+}
+
+// WithSyncs sets where the state of synced playlists is kept, for jobs in MODE_SYNC.
+func (r *Runner) WithSyncs(syncs SyncRepository) *Runner {
+	r.syncs = syncs
+	return r
+}
+
+// WithTwoWaySyncs sets where the snapshots of playlists synced both ways are kept, for
+// jobs in MODE_TWO_WAY.
+func (r *Runner) WithTwoWaySyncs(twoWays TwoWayRepository) *Runner {
+	r.twoWays = twoWays
+	return r
+}
+
+// WithHistory sets where the result of every run of a sync job is saved.
+func (r *Runner) WithHistory(runs RunRepository) *Runner {
+	r.runs = runs
+	return r
+}
+
+// WithBroadcaster publishes the progress of every job to the broadcaster too, so it can
+// be followed by anyone, not only by whoever started the job.
+func (r *Runner) WithBroadcaster(events *Broadcaster) *Runner {
+	r.events = events
+	return r
+}
+
+// Run checks for due jobs every RUNNER_INTERVAL until ctx is done.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(RUNNER_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.ResumeDue(ctx)
+		}
+	}
+}
+
+// ResumeDue starts, in the background, every paused job whose resume time has passed.
+func (r *Runner) ResumeDue(ctx context.Context) {
+	jobs, err := r.jobs.List()
+	if err != nil {
+		log.Printf("failed to list jobs: %s", err)
+		return
+	}
+	for i := range jobs {
+		j := &jobs[i]
+		if j.Status != job.STATUS_PAUSED || j.ResumeAt.After(r.now()) || r.isRunning(j.ID) {
+			continue
+		}
+		origin, destination, err := r.providers(j)
+		if err != nil {
+			log.Printf("cannot resume job %s: %s", j.ID, err)
+			continue
+		}
+		go func() {
+			err := r.Start(ctx, j, origin, destination, LogProgressPublisher{JobID: j.ID})
+			if err != nil {
+				log.Printf("job %s stopped: %s", j.ID, err)
+			}
+		}()
+	}
+}
+
+// Start runs the job until it finishes, pauses, fails or ctx is cancelled. Sync jobs
+// don't start while another job syncs one of their playlists.
+func (r *Runner) Start(ctx context.Context, j *job.Job, origin provider.Provider, destination provider.Provider, publisher ProgressPublisher) error {
+	run := newSyncRun(j, r.now())
+	err := r.start(ctx, j, origin, destination, publisher)
+	if isSync(j) && r.runs != nil {
+		if err := r.runs.Save(run.finish(j, err, r.now())); err != nil {
+			log.Printf("failed to save the run of job %s: %s", j.ID, err)
+		}
+	}
+	return err
+}
+
+func (r *Runner) start(ctx context.Context, j *job.Job, origin provider.Provider, destination provider.Provider, publisher ProgressPublisher) error {
+	keys := r.lockKeys(j)
+	if !r.acquire(keys...) {
+		return fmt.Errorf("job %s: %w", j.ID, ErrAlreadyRunning)
+	}
+	defer r.release(keys...)
+	var events BroadcastPublisher
+	if r.events != nil {
+		events = r.events.Publisher(j.ID)
+		publisher = multiPublisher{publisher, events}
+	}
+	builder := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(publisher).
+		WithMatchCache(r.cache).
+		WithJob(j, r.jobs).
+		WithWorkers(r.workers).
+		WithOrder(j.Order)
+	if j.Mode == MODE_SYNC {
+		builder = builder.WithSync(r.syncs, j.RemoveMissing)
+	} else if j.Mode == MODE_TWO_WAY {
+		builder = builder.WithTwoWaySync(r.twoWays, j.Policy)
+	}
+	err := builder.Build().Start(ctx)
+	if r.events != nil && err != nil && !errors.Is(err, ErrPaused) && !errors.Is(err, ErrCancelled) {
+		// paused and cancelled jobs already published why they stopped
+		events.Fail(err)
+	}
+	return err
+}
+
+// Plan publishes what transferring the playlists would do, without changing the destination.
+func (r *Runner) Plan(ctx context.Context, playlists []provider.Playlist, origin provider.Provider, destination provider.Provider, publisher ProgressPublisher) error {
+	return Transfer().
+		Playlists(playlists).
+		From(origin).
+		To(destination).
+		WithProgressPublisher(publisher).
+		WithMatchCache(r.cache).
+		WithWorkers(r.workers).
+		DryRun(r.plans).
+		Build().
+		Start(ctx)
+}
+
+// Busy is true when the job, or another job syncing one of its playlists, is running.
+func (r *Runner) Busy(j *job.Job) bool {
+	keys := r.lockKeys(j)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if r.running[key] {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runner) isRunning(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.running[id]
+}
+
+func isSync(j *job.Job) bool {
+	return j.Mode == MODE_SYNC || j.Mode == MODE_TWO_WAY
+}
+
+// lockKeys are the job and, for sync jobs, each of the playlists it syncs. Pairs synced
+// both ways are locked on both playlists, whichever way the job syncs them.
+func (r *Runner) lockKeys(j *job.Job) []string {
+	keys := []string{j.ID}
+	for _, p := range j.Playlists {
+		if j.Mode == MODE_SYNC {
+			keys = append(keys, j.Mode+":"+syncKey(j.Origin, j.Destination, p.ID))
+		} else if j.Mode == MODE_TWO_WAY {
+			state := &TwoWayState{A: j.Origin, B: j.Destination, PlaylistA: p.ID}
+			if r.twoWays != nil {
+				found, err := findTwoWayState(r.twoWays, j.Origin, j.Destination, p.ID)
+				if err != nil {
+					log.Printf("failed to find the two-way sync of %s: %s", p.Name, err)
+				} else if found != nil {
+					state = found
+				}
+			}
+			keys = append(keys, state.lockKeys()...)
+		}
+	}
+	return keys
+}
+
+// acquire locks every key, or none when one of them is taken.
+func (r *Runner) acquire(keys ...string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if r.running[key] {
+			return false
+		}
+	}
+	for _, key := range keys {
+		r.running[key] = true
+	}
+	return true
+}
+
+func (r *Runner) release(keys ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		delete(r.running, key)
+	}
+}
+
+// LogProgressPublisher logs the progress of jobs running without anyone watching.
+type LogProgressPublisher struct {
+	JobID string
+}
+
+func (p LogProgressPublisher) Publish(progressType string, body string) error {
+	log.Printf("job %s: %s %s", p.JobID, progressType, body)
+	return nil
+}
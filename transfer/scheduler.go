@@ -0,0 +1,198 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/schedule"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const RUNS_BUCKET = "sync-runs"
+
+// RUN_SKIPPED is the status of runs that didn't start because the playlist was
+// already syncing, runs that started have the status of their job.
+const RUN_SKIPPED = "skipped"
+
+// SyncRun is the result of running a sync job once, jobs run again when they are resumed.
+type SyncRun struct {
+	ID          string   `json:"id"`
+	JobID       string   `json:"jobId"`
+	Origin      string   `json:"origin"`
+	Destination string   `json:"destination"`
+	Playlists   []string `json:"playlists"`
+	Scheduled   bool     `json:"scheduled"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+	// ErrorCode identifies the kind of error, see provider.Code
+	ErrorCode  string    `json:"errorCode,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Added, Failed and Removed count the tracks of this run only
+	Added   int `json:"added"`
+	Failed  int `json:"failed"`
+	Removed int `json:"removed"`
+}
+
+func newSyncRun(j *job.Job, now time.Time) *SyncRun {
+	run := &SyncRun{
+		ID:          job.NewID(),
+		JobID:       j.ID,
+		Origin:      j.Origin,
+		Destination: j.Destination,
+		Playlists:   []string{},
+		Scheduled:   j.Scheduled,
+		StartedAt:   now,
+		// subtracted from the counts when the run finishes
+		Added:   -j.Count(job.TRACK_ADDED),
+		Failed:  -j.Count(job.TRACK_FAILED),
+		Removed: -j.Removed(),
+	}
+	for _, p := range j.Playlists {
+		run.Playlists = append(run.Playlists, p.Name)
+	}
+	return run
+}
+
+func (r *SyncRun) finish(j *job.Job, err error, now time.Time) *SyncRun {
+	r.FinishedAt = now
+	r.Status = j.Status
+	r.Added += j.Count(job.TRACK_ADDED)
+	r.Failed += j.Count(job.TRACK_FAILED)
+	r.Removed += j.Removed()
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorCode = provider.Code(err)
+	}
+	if errors.Is(err, ErrAlreadyRunning) {
+		r.Status = RUN_SKIPPED
+	} else if err != nil && !j.Finished() && j.Status != job.STATUS_PAUSED {
+		// the job stopped before it was saved
+		r.Status = job.STATUS_FAILED
+	}
+	return r
+}
+
+type RunRepository interface {
+	Save(r *SyncRun) error
+	List() ([]SyncRun, error)
+}
+
+func NewRunStore(s *store.Store) RunStore {
+	return RunStore{store: s}
+}
+
+// RunStore saves the history of sync runs on the application database.
+type RunStore struct {
+	store *store.Store
+}
+
+func (s RunStore) Save(r *SyncRun) error {
+	return s.store.Put(RUNS_BUCKET, r.ID, r)
+}
+
+// List returns every run, the most recent first.
+func (s RunStore) List() ([]SyncRun, error) {
+	runs := []SyncRun{}
+	err := s.store.ForEach(RUNS_BUCKET, func(key string, value []byte) error {
+		var r SyncRun
+		if err := json.Unmarshal(value, &r); err != nil {
+			return err
+		}
+		runs = append(runs, r)
+		return nil
+	})
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	return runs, err
+}
+
+// Scheduler syncs the scheduled pairs in the background, one at a time, whenever the
+// schedule says so.
+type Scheduler struct {
+	runner   *Runner
+	syncs    SyncRepository
+	schedule schedule.Schedule
+	now      func() time.Time
+}
+
+func NewScheduler(runner *Runner, syncs SyncRepository, s schedule.Schedule) *Scheduler {
+	return &Scheduler{runner: runner, syncs: syncs, schedule: s, now: time.Now}
+}
+
+// Next is when the scheduled pairs are synced next.
+func (s *Scheduler) Next() time.Time {
+	return s.schedule.Next(s.now())
+}
+
+// Run syncs the scheduled pairs every time the schedule is due, until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next := s.Next()
+		if next.IsZero() {
+			log.Printf("the schedule never runs, scheduled syncs are disabled")
+			return
+		}
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.SyncScheduled(ctx)
+		}
+	}
+}
+
+// SyncScheduled syncs every scheduled pair.
+func (s *Scheduler) SyncScheduled(ctx context.Context) {
+	states, err := s.syncs.List()
+	if err != nil {
+		log.Printf("failed to list syncs: %s", err)
+		return
+	}
+	for _, state := range states {
+		if !state.Scheduled {
+			continue
+		}
+		if err := s.Sync(ctx, state, true); err != nil {
+			log.Printf("sync of %s stopped: %s", state.Name, err)
+		}
+	}
+}
+
+// Sync runs the sync of a pair with the options of its last sync, using the credentials
+// of the runner.
+func (s *Scheduler) Sync(ctx context.Context, state SyncState, scheduled bool) error {
+	j := job.New(state.Origin, state.Destination, []provider.Playlist{{ID: state.PlaylistID, Name: state.Name}})
+	j.Mode = MODE_SYNC
+	j.Order = state.Order
+	j.RemoveMissing = state.RemoveMissing
+	j.Scheduled = scheduled
+	j.Owner = state.Owner
+	origin, destination, err := s.runner.providers(j)
+	if err != nil {
+		// saved so the failure shows up with the other jobs
+		j.Status = job.STATUS_FAILED
+		j.Error = err.Error()
+		j.ErrorCode = provider.Code(err)
+		if err := s.runner.jobs.Save(j); err != nil {
+			log.Printf("failed to save job %s: %s", j.ID, err)
+		}
+		if s.runner.runs != nil {
+			run := newSyncRun(j, s.now())
+			if err := s.runner.runs.Save(run.finish(j, err, s.now())); err != nil {
+				log.Printf("failed to save the run of job %s: %s", j.ID, err)
+			}
+		}
+		return err
+	}
+	return s.runner.Start(ctx, j, origin, destination, LogProgressPublisher{JobID: j.ID})
+}
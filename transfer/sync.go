@@ -0,0 +1,209 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const SYNCS_BUCKET = "syncs"
+
+// SyncState is what a sync remembers about an origin playlist between runs.
+type SyncState struct {
+	// Origin and Destination are the providers of the job, e.g. "spotify"
+	Origin      string              `json:"origin"`
+	Destination string              `json:"destination"`
+	PlaylistID  provider.PlaylistID `json:"playlistId"`
+	Name        string              `json:"name"`
+	// DestinationID is the playlist the origin playlist is synced to, it's kept even
+	// when either playlist is renamed
+	DestinationID string `json:"destinationId"`
+	// Tracks has every synced origin track by its ID
+	Tracks   map[string]SyncedTrack `json:"tracks"`
+	SyncedAt time.Time              `json:"syncedAt"`
+	// Order and RemoveMissing are the options of the last sync, scheduled syncs use them too
+	Order         string `json:"order,omitempty"`
+	RemoveMissing bool   `json:"removeMissing,omitempty"`
+	// Scheduled pairs are synced in the background, see Scheduler
+	Scheduled bool `json:"scheduled,omitempty"`
+	// Owner is the user who first synced the pair, only they can run or schedule it
+	Owner string `json:"owner,omitempty"`
+}
+
+func (s SyncState) Key() string {
+	return syncKey(s.Origin, s.Destination, s.PlaylistID)
+}
+
+type SyncedTrack struct {
+	// DestinationID is empty for tracks without a match, so they aren't searched on every run
+	DestinationID string `json:"destinationId,omitempty"`
+	Name          string `json:"name"`
+}
+
+func newSyncState(origin string, destination string, playlist provider.Playlist) *SyncState {
+	return &SyncState{
+		Origin:      origin,
+		Destination: destination,
+		PlaylistID:  playlist.ID,
+		Name:        playlist.Name,
+		Tracks:      map[string]SyncedTrack{},
+	}
+}
+
+type SyncRepository interface {
+	Save(s *SyncState) error
+	// Get returns nil when the playlist was never synced to the destination
+	Get(origin string, destination string, playlistID provider.PlaylistID) (*SyncState, error)
+	List() ([]SyncState, error)
+}
+
+func NewSyncStore(s *store.Store) SyncStore {
+	return SyncStore{store: s}
+}
+
+// SyncStore saves sync states on the application database.
+type SyncStore struct {
+	store *store.Store
+}
+
+func syncKey(origin string, destination string, playlistID provider.PlaylistID) string {
+	return fmt.Sprintf("%s:%s:%s", origin, destination, playlistID)
+}
+
+func (s SyncStore) Save(state *SyncState) error {
+	return s.store.Put(SYNCS_BUCKET, state.Key(), state)
+}
+
+func (s SyncStore) Get(origin string, destination string, playlistID provider.PlaylistID) (*SyncState, error) {
+	var state SyncState
+	found, err := s.store.Get(SYNCS_BUCKET, syncKey(origin, destination, playlistID), &state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// List returns every synced playlist, sorted by name.
+func (s SyncStore) List() ([]SyncState, error) {
+	states := []SyncState{}
+	err := s.store.ForEach(SYNCS_BUCKET, func(key string, value []byte) error {
+		var state SyncState
+		if err := json.Unmarshal(value, &state); err != nil {
+			return err
+		}
+		states = append(states, state)
+		return nil
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states, err
+}
+
+func (t TransferClient) getSyncState(j *job.Job, playlist provider.Playlist) (*SyncState, error) {
+	state, err := t.syncs.Get(j.Origin, j.Destination, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = newSyncState(j.Origin, j.Destination, playlist)
+	}
+	if state.Owner == "" {
+		// pairs synced before owners were kept are taken by whoever syncs them next
+		state.Owner = j.Owner
+	}
+	return state, nil
+}
+
+// syncedPlaylist returns the destination playlist of the origin playlist, which is
+// looked up by its name only the first time it's synced.
+func (t TransferClient) syncedPlaylist(ctx context.Context, state *SyncState, playlist provider.Playlist) (string, error) {
+	if state.DestinationID != "" {
+		return state.DestinationID, nil
+	}
+	id, err := getOrCreatePlaylist(ctx, t.destination, playlist)
+	if err != nil {
+		return "", err
+	}
+	state.DestinationID = id
+	return id, t.syncs.Save(state)
+}
+
+// removeMissingTracks removes from the destination the tracks removed from the origin
+// since the last sync.
+func (t TransferClient) removeMissingTracks(ctx context.Context, j *job.Job, state *SyncState, playlist *job.Playlist, tracks []provider.Track) error {
+	origin := map[string]bool{}
+	for _, track := range tracks {
+		origin[track.ID] = true
+	}
+	for originID, synced := range state.Tracks {
+		if origin[originID] {
+			continue
+		}
+		if synced.DestinationID != "" && !stillSynced(state, originID, synced.DestinationID) {
+			err := t.destination.RemoveFromPlaylist(ctx, state.DestinationID, synced.DestinationID)
+			if err != nil && !errors.Is(err, provider.ErrNotFound) {
+				return err
+			}
+			playlist.Removed++
+			t.publishNamedTrack(PROGRESS_TRACK_REMOVED, j, playlist, synced.Name)
+		}
+		delete(state.Tracks, originID)
+		if err := t.syncs.Save(state); err != nil {
+			return err
+		}
+		if err := t.checkpoint(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stillSynced is true when another origin track has the same match, e.g. the same
+// song from a different album, so removing one of them doesn't remove both.
+func stillSynced(state *SyncState, originID string, destinationID string) bool {
+	for id, other := range state.Tracks {
+		if id != originID && other.DestinationID == destinationID {
+			return true
+		}
+	}
+	return false
+}
+
+// syncedTrack is the track of the job for an origin track, skipping the ones synced before.
+func syncedTrack(state *SyncState, track provider.Track) job.Track {
+	synced, ok := state.Tracks[track.ID]
+	if !ok {
+		return job.Track{Track: track, State: job.TRACK_PENDING}
+	} else if synced.DestinationID == "" {
+		return job.Track{Track: track, State: job.TRACK_SKIPPED, Reason: "no match found"}
+	} else {
+		return job.Track{Track: track, State: job.TRACK_SKIPPED, DestinationID: synced.DestinationID, Reason: "already synced"}
+	}
+}
+
+// saveSyncState remembers the tracks of the playlist that don't have to be synced again.
+// Tracks that failed are left out so they are tried again on the next run.
+func (t TransferClient) saveSyncState(state *SyncState, playlist *job.Playlist) error {
+	for _, track := range playlist.Tracks {
+		if track.ID == "" {
+			continue
+		}
+		// skipped tracks are either already on the destination or have no match
+		if track.State == job.TRACK_ADDED || track.State == job.TRACK_SKIPPED {
+			state.Tracks[track.ID] = SyncedTrack{DestinationID: track.DestinationID, Name: track.FullName()}
+		}
+	}
+	state.Name = playlist.Name
+	state.Order = t.order
+	state.RemoveMissing = t.removeMissing
+	state.SyncedAt = time.Now()
+	return t.syncs.Save(state)
+}
@@ -0,0 +1,126 @@
+package transfer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/paulombcosta/waltz/provider"
+)
+
+func NewTerminalProgressPublisher(out io.Writer, interactive bool) *TerminalProgressPublisher {
+	return &TerminalProgressPublisher{out: out, interactive: interactive}
+}
+
+// TerminalProgressPublisher prints the progress of transfers run from the command line.
+// On interactive terminals the progress of the current playlist is kept on one line that
+// is rewritten, otherwise only what needs attention and the playlist totals are printed,
+// so it reads well on logs.
+type TerminalProgressPublisher struct {
+	out         io.Writer
+	interactive bool
+	// status is true while the last line is the progress line
+	status bool
+}
+
+func (p *TerminalProgressPublisher) Publish(progressType string, body string) error {
+	return p.PublishWithCode(progressType, body, "")
+}
+
+func (p *TerminalProgressPublisher) PublishWithCode(progressType string, body string, code string) error {
+	e := newEvent(progressType)
+	e.Body = body
+	e.Code = code
+	return p.PublishEvent(e)
+}
+
+func (p *TerminalProgressPublisher) PublishEvent(e ProgressEvent) error {
+	if e.Type == PROGRESS_JOB {
+		p.println("Job %s", e.Body)
+	} else if e.Type == PROGRESS_STARTED_PLAYLSIT {
+		p.println("%s", e.PlaylistName)
+	} else if e.Type == PROGRESS_PLAYLIST_DONE {
+		if e.Counts != nil {
+			p.println("  %s", formatCounts(e.Counts))
+		}
+	} else if e.Type == PROGRESS_TRACK_UNMATCHED {
+		p.println("  no match: %s", e.Track)
+	} else if e.Type == PROGRESS_TRACK_FAILED {
+		p.println("  failed: %s (%s)", e.Track, e.Reason)
+	} else if e.Type == PROGRESS_TRACK_REMOVED {
+		p.println("  removed: %s", e.Track)
+	} else if e.Type == PROGRESS_CONFLICT {
+		p.println("  conflict: %s", e.Track)
+	} else if e.Type == PROGRESS_RETRY {
+		p.println("  retrying: %s", e.Body)
+	} else if e.Type == PROGRESS_QUOTA {
+		p.println("  quota left on %s", e.Body)
+	} else if e.Type == PROGRESS_PAUSED {
+		p.println("Paused until %s (%s)", e.Body, e.Code)
+	} else if e.Type == PROGRESS_CANCELLED {
+		p.println("Cancelled")
+	} else if e.Type == PROGRESS_TRANSFER_DONE {
+		p.println("Done")
+	} else if e.Type == PROGRESS_TRANFER_ERROR {
+		p.println("Error: %s", e.Body)
+	} else if e.Type == PROGRESS_PLAN {
+		p.printPlan(e.Body)
+	}
+	if p.interactive && e.Counts != nil && e.Type != PROGRESS_PLAYLIST_DONE {
+		fmt.Fprintf(p.out, "\r\033[K  %s", formatCounts(e.Counts))
+		p.status = true
+	}
+	return nil
+}
+
+// Fail prints the error that stopped the transfer.
+func (p *TerminalProgressPublisher) Fail(err error) {
+	_ = p.PublishWithCode(PROGRESS_TRANFER_ERROR, err.Error(), provider.Code(err))
+}
+
+// println prints the line, on a new line when the progress line is shown.
+func (p *TerminalProgressPublisher) println(format string, args ...any) {
+	if p.status {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.status = false
+	}
+	fmt.Fprintf(p.out, format+"\n", args...)
+}
+
+func (p *TerminalProgressPublisher) printPlan(body string) {
+	var plan Plan
+	if err := json.Unmarshal([]byte(body), &plan); err != nil {
+		p.println("Invalid plan: %s", err)
+		return
+	}
+	for _, playlist := range plan.Playlists {
+		action := "exists"
+		if !playlist.Exists {
+			action = "would be created"
+		}
+		p.println("%s (%s): %d tracks to add, %d already there, %d without a match",
+			playlist.Name, action, len(playlist.Add), len(playlist.Present), len(playlist.Unmatched))
+		for _, t := range playlist.Unmatched {
+			p.println("  no match: %s", t.FullName())
+		}
+	}
+	cost := []string{}
+	for name, units := range plan.Cost {
+		cost = append(cost, fmt.Sprintf("%d units on %s", units, name))
+	}
+	sort.Strings(cost)
+	if len(cost) > 0 {
+		p.println("Estimated quota: %s", strings.Join(cost, ", "))
+	}
+	p.println("Plan %s", plan.ID)
+}
+
+func formatCounts(c *EventCounts) string {
+	text := fmt.Sprintf("%d/%d tracks, %d added, %d skipped, %d failed", c.Done, c.Total, c.Added, c.Skipped, c.Failed)
+	if c.Removed > 0 {
+		text += fmt.Sprintf(", %d removed", c.Removed)
+	}
+	return text
+}
@@ -1,31 +1,100 @@
 package transfer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/paulombcosta/waltz/cache"
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/match"
 	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/quota"
+	"github.com/paulombcosta/waltz/retry"
 )
 
+// The types of progress events, see ProgressEvent.
 const (
 	PROGRESS_STARTED_PLAYLSIT = "playlist-start"
 	PROGRESS_PLAYLIST_DONE    = "playlist-done"
 	PROGRESS_TRACK_DONE       = "track-done"
+	PROGRESS_TRACK_UNMATCHED  = "track-unmatched"
+	PROGRESS_TRACK_SKIPPED    = "track-skipped"
+	PROGRESS_TRACK_DUPLICATE  = "track-duplicate"
+	PROGRESS_TRACK_FAILED     = "track-failed"
+	PROGRESS_TRACK_MOVED      = "track-moved"
+	PROGRESS_TRACK_REMOVED    = "track-removed"
+	PROGRESS_CONFLICT         = "conflict"
+	PROGRESS_CACHE_HIT        = "cache-hit"
+	PROGRESS_CACHE_MISS       = "cache-miss"
+	PROGRESS_QUOTA            = "quota"
+	PROGRESS_PAUSED           = "paused"
+	PROGRESS_RETRY            = "retry"
+	PROGRESS_CANCELLED        = "cancelled"
+	PROGRESS_JOB              = "job"
+	PROGRESS_PLAN             = "plan"
 	PROGRESS_TRANSFER_DONE    = "done"
 	PROGRESS_TRANFER_ERROR    = "error"
 )
 
-type ProgressMessage struct {
-	Type string `json:"type"`
-	Body string `json:"body"`
-}
+var (
+	ErrPaused    = errors.New("transfer paused")
+	ErrCancelled = errors.New("transfer cancelled")
+)
+
+// How new tracks are placed on playlists that already exist on the destination.
+const (
+	// ORDER_APPEND adds them at the end of the playlist
+	ORDER_APPEND = "append"
+	// ORDER_MIRROR adds them at the same position they have on the origin, moving the
+	// tracks that were already there so the playlist follows the order of the origin
+	ORDER_MIRROR = "mirror"
+)
+
+// What a job does with the playlists.
+const (
+	// MODE_TRANSFER copies the playlists once
+	MODE_TRANSFER = "transfer"
+	// MODE_SYNC remembers the destination of each playlist and on every run only adds
+	// the tracks added to the origin since the last one
+	MODE_SYNC = "sync"
+	// MODE_TWO_WAY syncs the playlists both ways, adding and removing on each playlist
+	// what was added and removed on the other since the last sync
+	MODE_TWO_WAY = "two-way"
+)
+
+// DEFAULT_WORKERS is how many tracks are matched at the same time
+const DEFAULT_WORKERS = 4
+
+// RETRY_DELAY is how long jobs wait after a transient error when the API didn't say
+const RETRY_DELAY = time.Minute
 
 type TransferClientBuilder struct {
 	origin      provider.Provider
 	playlists   []provider.Playlist
 	publisher   ProgressPublisher
 	destination provider.Provider
+	matcher     match.Matcher
+	cache       cache.MatchCache
+	job         *job.Job
+	jobs        job.Repository
+	retry       *retry.Policy
+	workers     int
+	order       string
+	dryRun      bool
+	plans       PlanRepository
+	syncs       SyncRepository
+	// removeMissing removes tracks removed from the origin, when syncing
+	removeMissing bool
+	twoWays       TwoWayRepository
+	// policy resolves the conflicts of two-way syncs, see POLICY_SOURCE_WINS
+	policy string
 }
 
 func Transfer() TransferClientBuilder {
@@ -52,28 +121,128 @@ func (t TransferClientBuilder) WithProgressPublisher(p ProgressPublisher) Transf
 	return t
 }
 
+func (t TransferClientBuilder) WithMatcher(m match.Matcher) TransferClientBuilder {
+	t.matcher = m
+	return t
+}
+
+func (t TransferClientBuilder) WithMatchCache(c cache.MatchCache) TransferClientBuilder {
+	t.cache = c
+	return t
+}
+
+// WithJob runs the transfer as the given job, saving its progress to the repository
+// after every operation. Jobs that already made progress continue from where they stopped.
+func (t TransferClientBuilder) WithJob(j *job.Job, jobs job.Repository) TransferClientBuilder {
+	t.job = j
+	t.jobs = jobs
+	return t
+}
+
+// WithRetryPolicy sets how failed provider calls are retried, by default they are
+// retried with retry.DefaultPolicy.
+func (t TransferClientBuilder) WithRetryPolicy(policy retry.Policy) TransferClientBuilder {
+	t.retry = &policy
+	return t
+}
+
+// WithWorkers sets how many tracks are matched at the same time, DEFAULT_WORKERS by default.
+func (t TransferClientBuilder) WithWorkers(n int) TransferClientBuilder {
+	t.workers = n
+	return t
+}
+
+// WithOrder sets how new tracks are placed, ORDER_APPEND by default.
+func (t TransferClientBuilder) WithOrder(order string) TransferClientBuilder {
+	t.order = order
+	return t
+}
+
+// WithSync syncs the playlists of the job instead of copying them, see MODE_SYNC. The
+// playlists they are synced to and the tracks already synced are kept in the repository.
+// With removeMissing the tracks removed from the origin are removed from the destination.
+func (t TransferClientBuilder) WithSync(syncs SyncRepository, removeMissing bool) TransferClientBuilder {
+	t.syncs = syncs
+	t.removeMissing = removeMissing
+	return t
+}
+
+// WithTwoWaySync syncs the playlists of the job both ways, see MODE_TWO_WAY, keeping
+// the snapshot of every sync in the repository. The policy resolves the tracks removed
+// from one playlist and added again to the other since the last sync.
+func (t TransferClientBuilder) WithTwoWaySync(twoWays TwoWayRepository, policy string) TransferClientBuilder {
+	t.twoWays = twoWays
+	t.policy = policy
+	return t
+}
+
+// DryRun makes Start compute and publish the plan of the transfer instead of running
+// it, saving the plan to the repository when there is one.
+func (t TransferClientBuilder) DryRun(plans PlanRepository) TransferClientBuilder {
+	t.dryRun = true
+	t.plans = plans
+	return t
+}
+
 // TODO validate fields here
 func (t TransferClientBuilder) Build() TransferClient {
-	return TransferClient(t)
+	if t.matcher == nil {
+		t.matcher = match.New()
+	}
+	if t.retry == nil {
+		policy := retry.DefaultPolicy()
+		t.retry = &policy
+	}
+	if t.workers <= 0 {
+		t.workers = DEFAULT_WORKERS
+	}
+	if t.order == "" {
+		t.order = ORDER_APPEND
+	}
+	if t.policy == "" {
+		t.policy = POLICY_SOURCE_WINS
+	}
+	if t.publisher != nil {
+		t.publisher = lockedPublisher{mu: &sync.Mutex{}, publisher: t.publisher}
+	}
+	client := TransferClient(t)
+	if t.origin != nil {
+		client.origin = retry.Wrap(t.origin, client.retryPolicy(t.origin))
+	}
+	if t.destination != nil {
+		client.destination = retry.Wrap(t.destination, client.retryPolicy(t.destination))
+	}
+	return client
 }
 
 func NewWebSocketProgressPublisher(conn *websocket.Conn) WebSocketProgressPublisher {
-	return WebSocketProgressPublisher{Conn: conn}
+	return WebSocketProgressPublisher{Conn: conn, mu: &sync.Mutex{}}
 }
 
+// WebSocketProgressPublisher is safe to use from multiple goroutines, unlike the connection.
 type WebSocketProgressPublisher struct {
 	Conn *websocket.Conn
+	mu   *sync.Mutex
 }
 
 func (publisher WebSocketProgressPublisher) Publish(progressType string, body string) error {
-	payload := ProgressMessage{
-		Type: progressType,
-		Body: body,
-	}
-	data, err := json.Marshal(payload)
+	return publisher.PublishWithCode(progressType, body, "")
+}
+
+func (publisher WebSocketProgressPublisher) PublishWithCode(progressType string, body string, code string) error {
+	e := newEvent(progressType)
+	e.Body = body
+	e.Code = code
+	return publisher.PublishEvent(e)
+}
+
+func (publisher WebSocketProgressPublisher) PublishEvent(e ProgressEvent) error {
+	data, err := json.Marshal(e)
 	if err != nil {
 		return err
 	}
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
 	return publisher.Conn.WriteMessage(websocket.TextMessage, data)
 }
 
@@ -81,101 +250,592 @@ func (publisher WebSocketProgressPublisher) Error(body string) {
 	_ = publisher.Publish(PROGRESS_TRANFER_ERROR, body)
 }
 
+// Fail reports the error that stopped the transfer along with its code.
+func (publisher WebSocketProgressPublisher) Fail(err error) {
+	_ = publisher.PublishWithCode(PROGRESS_TRANFER_ERROR, err.Error(), provider.Code(err))
+}
+
 type ProgressPublisher interface {
 	Publish(progressType string, body string) error
 }
 
+// lockedPublisher serializes the calls to a publisher, as the tracks of a transfer
+// are matched concurrently.
+type lockedPublisher struct {
+	mu        *sync.Mutex
+	publisher ProgressPublisher
+}
+
+func (p lockedPublisher) Publish(progressType string, body string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.publisher.Publish(progressType, body)
+}
+
+func (p lockedPublisher) PublishWithCode(progressType string, body string, code string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if codePublisher, ok := p.publisher.(CodePublisher); ok {
+		return codePublisher.PublishWithCode(progressType, body, code)
+	}
+	return p.publisher.Publish(progressType, body)
+}
+
+func (p lockedPublisher) PublishEvent(e ProgressEvent) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sendEvent(p.publisher, e)
+}
+
+// CodePublisher is implemented by publishers that tell clients which kind of error
+// paused or stopped the transfer.
+type CodePublisher interface {
+	PublishWithCode(progressType string, body string, code string) error
+}
+
 type TransferClient struct {
 	origin      provider.Provider
 	playlists   []provider.Playlist
 	publisher   ProgressPublisher
 	destination provider.Provider
+	matcher     match.Matcher
+	cache       cache.MatchCache
+	job         *job.Job
+	jobs        job.Repository
+	retry       *retry.Policy
+	workers     int
+	order       string
+	dryRun      bool
+	plans       PlanRepository
+	syncs       SyncRepository
+	// removeMissing removes tracks removed from the origin, when syncing
+	removeMissing bool
+	twoWays       TwoWayRepository
+	// policy resolves the conflicts of two-way syncs, see POLICY_SOURCE_WINS
+	policy string
 }
 
-func (t TransferClient) publish(typeOf string, content string) {
-	_ = t.publisher.Publish(typeOf, content)
+// retryPolicy reports every wait for a retry of p as progress.
+func (t TransferClient) retryPolicy(p provider.Provider) retry.Policy {
+	policy := *t.retry
+	onWait := policy.OnWait
+	policy.OnWait = func(err error, delay time.Duration) {
+		if onWait != nil {
+			onWait(err, delay)
+		}
+		if t.publisher != nil {
+			t.publishWithCode(PROGRESS_RETRY,
+				fmt.Sprintf("%s: retrying in %s", p.Name(), delay.Round(time.Second)), provider.Code(err))
+		}
+	}
+	return policy
 }
 
-func (t TransferClient) Start() error {
+// publishQuota reports the quota left on the providers that keep track of it.
+func (t TransferClient) publishQuota() {
+	for _, p := range []provider.Provider{t.origin, t.destination} {
+		reporter, ok := p.(provider.QuotaReporter)
+		if !ok {
+			continue
+		}
+		if remaining, ok := reporter.RemainingQuota(); ok {
+			t.publish(PROGRESS_QUOTA, fmt.Sprintf("%s: %d", p.Name(), remaining))
+		}
+	}
+}
 
-	if t.playlists == nil {
-		return errors.New("cannot import: list is null")
+func (t TransferClient) Start(ctx context.Context) error {
+	if t.dryRun {
+		return t.startDryRun(ctx)
+	}
+	j := t.job
+	if j == nil && (t.syncs != nil || t.twoWays != nil) {
+		// the job knows the providers the sync state is kept for
+		return errors.New("cannot sync: no job")
+	}
+	if j == nil {
+		if t.playlists == nil {
+			return errors.New("cannot import: list is null")
+		}
+		if len(t.playlists) == 0 {
+			return errors.New("cannot import: list is empty")
+		}
+		j = job.New("", "", t.playlists)
 	}
-	if len(t.playlists) == 0 {
+	if len(j.Playlists) == 0 {
 		return errors.New("cannot import: list is empty")
 	}
 
-	for _, playlist := range t.playlists {
-		destinationPlaylistId, err := getOrCreatePlaylist(t.destination, playlist)
+	j.Status = job.STATUS_RUNNING
+	j.Error = ""
+	j.ErrorCode = ""
+	if err := t.checkpoint(j); err != nil {
+		return t.stop(j, err)
+	}
+	for i := range j.Playlists {
+		if err := ctx.Err(); err != nil {
+			return t.stop(j, err)
+		}
+		var err error
+		if t.twoWays != nil {
+			err = t.syncBothWays(ctx, j, &j.Playlists[i])
+		} else {
+			err = t.transferPlaylist(ctx, j, &j.Playlists[i])
+		}
 		if err != nil {
-			return err
+			return t.stop(j, err)
 		}
+	}
+	j.Status = job.STATUS_DONE
+	if err := t.checkpoint(j); err != nil {
+		return t.stop(j, err)
+	}
+	t.publish(PROGRESS_TRANSFER_DONE, "")
+
+	return nil
+}
 
-		t.publish(PROGRESS_STARTED_PLAYLSIT, playlist.Name)
-		fullPlaylist, err := t.origin.GetFullPlaylist(string(playlist.ID))
+func (t TransferClient) transferPlaylist(ctx context.Context, j *job.Job, playlist *job.Playlist) error {
+	var state *SyncState
+	if t.syncs != nil {
+		var err error
+		state, err = t.getSyncState(j, playlist.Playlist)
 		if err != nil {
 			return err
 		}
-		err = t.addTracksToPlaylist(t.destination, destinationPlaylistId, fullPlaylist.Tracks)
+	}
+	if playlist.DestinationID == "" {
+		var destinationPlaylistId string
+		var err error
+		if state != nil {
+			destinationPlaylistId, err = t.syncedPlaylist(ctx, state, playlist.Playlist)
+		} else {
+			destinationPlaylistId, err = getOrCreatePlaylist(ctx, t.destination, playlist.Playlist)
+		}
 		if err != nil {
 			return err
 		}
-		t.publish(PROGRESS_PLAYLIST_DONE, "")
+		playlist.DestinationID = destinationPlaylistId
+		if err := t.checkpoint(j); err != nil {
+			return err
+		}
 	}
-	t.publish(PROGRESS_TRANSFER_DONE, "")
 
+	t.publishPlaylist(PROGRESS_STARTED_PLAYLSIT, j, playlist)
+	t.publishQuota()
+	if !playlist.Loaded {
+		fullPlaylist, err := t.origin.GetFullPlaylist(ctx, string(playlist.ID))
+		if err != nil {
+			return err
+		}
+		// removed before the tracks are loaded, so resuming doesn't load them twice
+		if state != nil && t.removeMissing {
+			if err := t.removeMissingTracks(ctx, j, state, playlist, fullPlaylist.Tracks); err != nil {
+				return err
+			}
+		}
+		for _, track := range fullPlaylist.Tracks {
+			if state != nil {
+				playlist.Tracks = append(playlist.Tracks, syncedTrack(state, track))
+			} else {
+				playlist.Tracks = append(playlist.Tracks, job.Track{Track: track, State: job.TRACK_PENDING})
+			}
+		}
+		playlist.Loaded = true
+		if err := t.checkpoint(j); err != nil {
+			return err
+		}
+		for i := range playlist.Tracks {
+			if playlist.Tracks[i].State == job.TRACK_SKIPPED {
+				t.publishTrack(PROGRESS_TRACK_SKIPPED, j, playlist, &playlist.Tracks[i])
+			}
+		}
+	}
+	err := t.addTracksToPlaylist(ctx, j, t.destination, playlist)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		if err := t.saveSyncState(state, playlist); err != nil {
+			return err
+		}
+	}
+	t.publishPlaylist(PROGRESS_PLAYLIST_DONE, j, playlist)
 	return nil
 }
 
-func (client TransferClient) addTracksToPlaylist(provider provider.Provider, playlistId string, tracks []provider.Track) error {
-	currentPlaylist, err := provider.GetFullPlaylist(playlistId)
+func (client TransferClient) addTracksToPlaylist(ctx context.Context, j *job.Job, destination provider.Provider, playlist *job.Playlist) error {
+	if !hasRemainingTracks(playlist) {
+		return nil
+	}
+	currentPlaylist, err := destination.GetFullPlaylist(ctx, playlist.DestinationID)
 	if err != nil {
 		return err
 	}
 	existingTracks := currentPlaylist.Tracks
 
-	for _, t := range tracks {
-
-		trackId, err := provider.FindTrack(t.FullName())
-		if err != nil {
+	// tracks are matched concurrently, but added one at a time so the destination
+	// keeps the order of the origin
+	if err := client.matchTracks(ctx, j, destination, playlist); err != nil {
+		return err
+	}
+	if client.order == ORDER_MIRROR {
+		return client.mirrorTracks(ctx, j, destination, playlist, existingTracks)
+	}
+	for i := range playlist.Tracks {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
-
-		if trackId == "" {
+		t := &playlist.Tracks[i]
+		if t.State != job.TRACK_MATCHED {
 			continue
 		}
+		trackId := t.DestinationID
 
 		// See if playlist already has an item with the videoID
 		isDuplicate := false
 		for _, t := range existingTracks {
-			if string(trackId) == t.ID {
+			if trackId == t.ID {
 				isDuplicate = true
 				break
 			}
 		}
 
 		if isDuplicate {
+			t.State = job.TRACK_SKIPPED
+			t.Reason = "already in playlist"
+			if err := client.checkpoint(j); err != nil {
+				return err
+			}
+			client.publishTrack(PROGRESS_TRACK_DUPLICATE, j, playlist, t)
 			continue
 		}
 
-		err = provider.AddToPlaylist(playlistId, string(trackId))
+		err = destination.AddToPlaylist(ctx, playlist.DestinationID, trackId)
+		if errors.Is(err, provider.ErrNotFound) {
+			// e.g. the video was deleted since it was matched
+			t.State = job.TRACK_FAILED
+			t.Reason = fmt.Sprintf("not found on %s", destination.Name())
+			if err := client.checkpoint(j); err != nil {
+				return err
+			}
+			client.publishTrack(PROGRESS_TRACK_FAILED, j, playlist, t)
+			continue
+		}
 		if err != nil {
 			return err
 		}
+		t.State = job.TRACK_ADDED
+		if err := client.checkpoint(j); err != nil {
+			return err
+		}
 
-		client.publish(PROGRESS_TRACK_DONE, "")
+		client.publishTrack(PROGRESS_TRACK_DONE, j, playlist, t)
+		client.publishQuota()
 	}
 	return nil
 }
 
-func getOrCreatePlaylist(destination provider.Provider, playlist provider.Playlist) (string, error) {
-	destinationPlaylist := ""
-	id, err := destination.FindPlaylistByName(string(playlist.Name))
+// mirrorTracks adds the matched tracks at the position they have on the origin and
+// moves the tracks already on the destination to the position they have on the origin.
+// Tracks that are only on the destination end up after them.
+func (client TransferClient) mirrorTracks(ctx context.Context, j *job.Job, destination provider.Provider, playlist *job.Playlist, existingTracks []provider.Track) error {
+	current := []string{}
+	for _, t := range existingTracks {
+		current = append(current, t.ID)
+	}
+	position := 0
+	for i := range playlist.Tracks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t := &playlist.Tracks[i]
+		if t.DestinationID == "" || t.State == job.TRACK_FAILED {
+			continue
+		}
+		index := indexOf(current, t.DestinationID)
+		if index >= 0 && index < position {
+			// repeated on the origin, it was placed already
+			if err := client.skipExisting(j, playlist, t); err != nil {
+				return err
+			}
+			continue
+		}
+		if index >= 0 {
+			if index != position {
+				err := destination.MovePlaylistItem(ctx, playlist.DestinationID, index, position)
+				if err != nil {
+					return err
+				}
+				current = append(current[:index], current[index+1:]...)
+				current = insertAt(current, position, t.DestinationID)
+				client.publishTrack(PROGRESS_TRACK_MOVED, j, playlist, t)
+				client.publishQuota()
+			}
+			if err := client.skipExisting(j, playlist, t); err != nil {
+				return err
+			}
+			position++
+			continue
+		}
+
+		err := destination.InsertIntoPlaylist(ctx, playlist.DestinationID, t.DestinationID, position)
+		if errors.Is(err, provider.ErrNotFound) {
+			t.State = job.TRACK_FAILED
+			t.Reason = fmt.Sprintf("not found on %s", destination.Name())
+			if err := client.checkpoint(j); err != nil {
+				return err
+			}
+			client.publishTrack(PROGRESS_TRACK_FAILED, j, playlist, t)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		current = insertAt(current, position, t.DestinationID)
+		position++
+		t.State = job.TRACK_ADDED
+		if err := client.checkpoint(j); err != nil {
+			return err
+		}
+		client.publishTrack(PROGRESS_TRACK_DONE, j, playlist, t)
+		client.publishQuota()
+	}
+	return nil
+}
+
+// skipExisting skips matched tracks that were already on the destination, tracks added
+// by a previous run are kept as added.
+func (client TransferClient) skipExisting(j *job.Job, playlist *job.Playlist, t *job.Track) error {
+	if t.State != job.TRACK_MATCHED {
+		return nil
+	}
+	t.State = job.TRACK_SKIPPED
+	t.Reason = "already in playlist"
+	if err := client.checkpoint(j); err != nil {
+		return err
+	}
+	client.publishTrack(PROGRESS_TRACK_DUPLICATE, j, playlist, t)
+	return nil
+}
+
+func indexOf(ids []string, id string) int {
+	for i := range ids {
+		if ids[i] == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertAt(ids []string, position int, id string) []string {
+	ids = append(ids, "")
+	copy(ids[position+1:], ids[position:])
+	ids[position] = id
+	return ids
+}
+
+type resolvedTrack struct {
+	index  int
+	result match.Result
+	// cached is set when the match was found on the cache, without searching
+	cached bool
+	err    error
+}
+
+// matchTracks finds the pending tracks of the playlist on the destination.
+func (client TransferClient) matchTracks(ctx context.Context, j *job.Job, destination provider.Provider, playlist *job.Playlist) error {
+	pending := map[int]provider.Track{}
+	for i, t := range playlist.Tracks {
+		if t.State == job.TRACK_PENDING {
+			pending[i] = t.Track
+		}
+	}
+	return client.resolveTracks(ctx, destination, pending, func(r resolvedTrack) error {
+		return client.recordMatch(j, playlist, &playlist.Tracks[r.index], r.result, r.err)
+	})
+}
+
+// resolveTracks finds the tracks, by their index, on the destination using up to workers
+// concurrent searches. The results are recorded by the calling goroutine only, so what
+// record changes is never changed concurrently. It stops on the first error of record.
+func (client TransferClient) resolveTracks(ctx context.Context, destination provider.Provider, tracks map[int]provider.Track, record func(r resolvedTrack) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := []int{}
+	for i := range tracks {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	pending := make(chan int)
+	resolved := make(chan resolvedTrack)
+	go func() {
+		defer close(pending)
+		for _, i := range indexes {
+			select {
+			case pending <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < client.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				result, cached, err := client.resolveTrack(ctx, destination, tracks[i])
+				resolved <- resolvedTrack{index: i, result: result, cached: cached, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(resolved)
+	}()
+
+	var firstErr error
+	for r := range resolved {
+		if firstErr != nil {
+			// wait for the searches in progress to stop
+			continue
+		}
+		if err := record(r); err != nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	return firstErr
+}
+
+func (client TransferClient) recordMatch(j *job.Job, playlist *job.Playlist, t *job.Track, result match.Result, err error) error {
+	if err != nil && !errors.Is(err, provider.ErrNotFound) {
+		return err
+	}
+	reviewTrack(t, result)
+	if !result.Matched {
+		t.State = job.TRACK_SKIPPED
+		t.Reason = "no match found"
+		if err := client.checkpoint(j); err != nil {
+			return err
+		}
+		client.publishTrack(PROGRESS_TRACK_UNMATCHED, j, playlist, t)
+		client.publishQuota()
+		return nil
+	}
+	t.State = job.TRACK_MATCHED
+	t.DestinationID = result.Track.ID
+	return client.checkpoint(j)
+}
+
+func hasRemainingTracks(playlist *job.Playlist) bool {
+	for _, t := range playlist.Tracks {
+		if t.State == job.TRACK_PENDING || t.State == job.TRACK_MATCHED {
+			return true
+		}
+	}
+	return false
+}
+
+// checkpoint saves the job progress. It fails with ErrCancelled when the job was
+// cancelled since the last checkpoint.
+func (t TransferClient) checkpoint(j *job.Job) error {
+	if t.jobs == nil {
+		return nil
+	}
+	stored, err := t.jobs.Get(j.ID)
+	if err != nil && !errors.Is(err, job.ErrNotFound) {
+		return err
+	}
+	if stored != nil && stored.Status == job.STATUS_CANCELLED {
+		return ErrCancelled
+	}
+	return t.jobs.Save(j)
+}
+
+// stop records why the job stopped. Jobs that ran out of quota are paused until
+// the quota resets and jobs that were rate limited or hit a transient error are
+// paused for a while, so the runner retries them. Everything else is final, jobs
+// that failed because the user is no longer logged in can be resumed after logging
+// in again. Cancelling the context, e.g. by closing the page, cancels the job.
+func (t TransferClient) stop(j *job.Job, err error) error {
+	if errors.Is(err, provider.ErrQuotaExceeded) || errors.Is(err, quota.ErrBudgetExceeded) {
+		j.ResumeAt = quota.NextReset(time.Now())
+		err = t.pause(j, err)
+	} else if errors.Is(err, provider.ErrRateLimited) || errors.Is(err, provider.ErrTransient) {
+		delay := provider.RetryAfter(err)
+		if delay == 0 {
+			delay = RETRY_DELAY
+		}
+		j.ResumeAt = time.Now().Add(delay)
+		err = t.pause(j, err)
+	} else if errors.Is(err, ErrCancelled) || errors.Is(err, context.Canceled) {
+		j.Status = job.STATUS_CANCELLED
+		err = ErrCancelled
+		t.publish(PROGRESS_CANCELLED, "")
+	} else {
+		j.Status = job.STATUS_FAILED
+		j.Error = err.Error()
+		j.ErrorCode = provider.Code(err)
+	}
+	if t.jobs != nil {
+		if saveErr := t.jobs.Save(j); saveErr != nil {
+			log.Printf("failed to save job %s: %s", j.ID, saveErr)
+		}
+	}
+	return err
+}
+
+// pause pauses the job until its ResumeAt.
+func (t TransferClient) pause(j *job.Job, err error) error {
+	j.Status = job.STATUS_PAUSED
+	j.Error = err.Error()
+	j.ErrorCode = provider.Code(err)
+	t.publishWithCode(PROGRESS_PAUSED, j.ResumeAt.Format(time.RFC3339), j.ErrorCode)
+	return fmt.Errorf("%w until %s: %w", ErrPaused, j.ResumeAt.Format(time.RFC1123), err)
+}
+
+// resolveTrack finds the track on the destination, looking it up on the match cache
+// before searching for it. It's true when the match was on the cache.
+func (client TransferClient) resolveTrack(ctx context.Context, destination provider.Provider, track provider.Track) (match.Result, bool, error) {
+	if client.cache == nil {
+		result, err := client.matcher.Match(ctx, destination, track)
+		return result, false, err
+	}
+	id, found, err := client.cache.Get(destination.Name(), track)
 	if err != nil {
+		return match.Result{}, false, err
+	}
+	if found {
+		client.publish(PROGRESS_CACHE_HIT, track.FullName())
+		return match.Result{Track: provider.Track{ID: string(id)}, Score: 1, Matched: true}, true, nil
+	}
+	client.publish(PROGRESS_CACHE_MISS, track.FullName())
+	result, err := client.matcher.Match(ctx, destination, track)
+	if err != nil {
+		return match.Result{}, false, err
+	}
+	// low confidence matches are searched again, until they are reviewed
+	if !result.LowConfidence() {
+		err = client.cache.Put(destination.Name(), track, provider.TrackID(result.Track.ID))
+		if err != nil {
+			return match.Result{}, false, err
+		}
+	}
+	return result, false, nil
+}
+
+func getOrCreatePlaylist(ctx context.Context, destination provider.Provider, playlist provider.Playlist) (string, error) {
+	destinationPlaylist := ""
+	id, err := destination.FindPlaylistByName(ctx, string(playlist.Name))
+	if err != nil && !errors.Is(err, provider.ErrNotFound) {
 		return "", err
 	}
 	if id == "" {
-		id, err = destination.CreatePlaylist(playlist.Name)
+		id, err = destination.CreatePlaylist(ctx, playlist.Name)
 		if err != nil {
 			return "", err
 		}
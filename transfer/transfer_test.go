@@ -1,10 +1,20 @@
 package transfer
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/match"
 	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/quota"
+	"github.com/paulombcosta/waltz/retry"
+	"github.com/paulombcosta/waltz/schedule"
+	"github.com/stretchr/testify/mock"
 )
 
 type NoOpPublisher struct{}
@@ -23,7 +33,7 @@ func TestShouldReturnErrorIfPlaylistsAreNil(t *testing.T) {
 		From(getMockProvider(t)).
 		To(getMockProvider(t)).
 		Build().
-		Start()
+		Start(context.Background())
 	expectedMsg := "cannot import: list is null"
 	actual := err.Error()
 	if actual != expectedMsg {
@@ -37,7 +47,7 @@ func TestShouldReturnErrorIfPlaylistsAreEmpty(t *testing.T) {
 		To(getMockProvider(t)).
 		Playlists([]provider.Playlist{}).
 		Build().
-		Start()
+		Start(context.Background())
 	expectedMsg := "cannot import: list is empty"
 	actual := err.Error()
 	if actual != expectedMsg {
@@ -50,9 +60,9 @@ func TestShouldUseExistingPlaylistOnDestination(t *testing.T) {
 
 	destinationPlaylist := provider.Playlist{ID: "123", Name: "name"}
 
-	destination.EXPECT().FindPlaylistByName("name").Return(provider.PlaylistID("123"), nil).Once()
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "name").Return(provider.PlaylistID("123"), nil).Once()
 
-	id, _ := getOrCreatePlaylist(destination, destinationPlaylist)
+	id, _ := getOrCreatePlaylist(context.Background(), destination, destinationPlaylist)
 
 	if id != "123" {
 		t.Fatalf("expected id to be 123 but it is %s", id)
@@ -64,10 +74,10 @@ func TestShouldCreatePlaylistWhenOriginDoesntExist(t *testing.T) {
 
 	destinationPlaylist := provider.Playlist{ID: "123", Name: "name"}
 
-	destination.EXPECT().FindPlaylistByName("name").Return("", nil).Once()
-	destination.EXPECT().CreatePlaylist("name").Return("123", nil).Once()
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "name").Return("", nil).Once()
+	destination.EXPECT().CreatePlaylist(mock.Anything, "name").Return("123", nil).Once()
 
-	id, _ := getOrCreatePlaylist(destination, destinationPlaylist)
+	id, _ := getOrCreatePlaylist(context.Background(), destination, destinationPlaylist)
 
 	if id != "123" {
 		t.Fatalf("expected id to be 123 but it is %s", id)
@@ -88,8 +98,8 @@ func TestShouldUseOriginPlaylistIDWhenFetchingFullPlaylist(t *testing.T) {
 		},
 	}
 
-	destination.EXPECT().FindPlaylistByName("playlist").Return(provider.PlaylistID(destinationPlaylistID), nil).Once()
-	origin.EXPECT().GetFullPlaylist(originPlaylistID).Return(nil, errors.New("stop")).Once()
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return(provider.PlaylistID(destinationPlaylistID), nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, originPlaylistID).Return(nil, errors.New("stop")).Once()
 
 	_ = Transfer().
 		From(origin).
@@ -97,5 +107,1294 @@ func TestShouldUseOriginPlaylistIDWhenFetchingFullPlaylist(t *testing.T) {
 		Playlists(playlists).
 		WithProgressPublisher(NoOpPublisher{}).
 		Build().
-		Start()
+		Start(context.Background())
+}
+
+type ProgressMessage struct {
+	Type string
+	Body string
+}
+
+type RecordingPublisher struct {
+	messages *[]ProgressMessage
+}
+
+func (p RecordingPublisher) Publish(progressType string, body string) error {
+	*p.messages = append(*p.messages, ProgressMessage{Type: progressType, Body: body})
+	return nil
+}
+
+func TestShouldReportUnmatchedTracksInsteadOfAddingThem(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	track := provider.Track{Name: "Song", Artists: []string{"Artist"}}
+
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("destination-ID", nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{Tracks: []provider.Track{track}}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, "Artist - Song", mock.Anything).Return([]provider.Track{
+		{ID: "unrelated", Name: "Something Else", Artists: []string{"Other"}},
+	}, nil).Once()
+
+	messages := []ProgressMessage{}
+	err := Transfer().
+		From(origin).
+		To(destination).
+		Playlists(playlists).
+		WithProgressPublisher(RecordingPublisher{messages: &messages}).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	expected := ProgressMessage{Type: PROGRESS_TRACK_UNMATCHED, Body: "Artist - Song"}
+	found := false
+	for _, m := range messages {
+		if m == expected {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %v to be published but got %v", expected, messages)
+	}
+}
+
+type mapMatchCache map[string]provider.TrackID
+
+func (c mapMatchCache) Get(destination string, track provider.Track) (provider.TrackID, bool, error) {
+	id, found := c[destination+track.FullName()]
+	return id, found, nil
+}
+
+func (c mapMatchCache) Put(destination string, track provider.Track, id provider.TrackID) error {
+	c[destination+track.FullName()] = id
+	return nil
+}
+
+func TestShouldUseCachedMatchInsteadOfSearching(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	track := provider.Track{Name: "Song", Artists: []string{"Artist"}}
+	matchCache := mapMatchCache{"YouTube" + track.FullName(): "cached-video"}
+
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("destination-ID", nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{Tracks: []provider.Track{track}}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().Name().Return("YouTube")
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "cached-video").Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		Playlists(playlists).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithMatchCache(matchCache).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+}
+
+func TestShouldCacheNewMatches(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	track := provider.Track{Name: "Song", Artists: []string{"Artist"}}
+	matchCache := mapMatchCache{}
+
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("destination-ID", nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{Tracks: []provider.Track{track}}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().Name().Return("YouTube")
+	destination.EXPECT().SearchTracks(mock.Anything, "Artist - Song", mock.Anything).Return([]provider.Track{
+		{ID: "video", Name: "Song", Artists: []string{"Artist - Topic"}},
+	}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "video").Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		Playlists(playlists).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithMatchCache(matchCache).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if matchCache["YouTube"+track.FullName()] != "video" {
+		t.Fatalf("expected match to be cached but cache is %v", matchCache)
+	}
+}
+
+type memoryJobs map[string]job.Job
+
+func (m memoryJobs) Save(j *job.Job) error {
+	m[j.ID] = *j
+	return nil
+}
+
+func (m memoryJobs) Get(id string) (*job.Job, error) {
+	j, ok := m[id]
+	if !ok {
+		return nil, job.ErrNotFound
+	}
+	return &j, nil
+}
+
+func (m memoryJobs) List() ([]job.Job, error) {
+	jobs := []job.Job{}
+	for _, j := range m {
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+func TestShouldResumeJobFromCheckpoint(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Status = job.STATUS_PAUSED
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "Added"}, State: job.TRACK_ADDED, DestinationID: "added"},
+		{Track: provider.Track{Name: "Matched"}, State: job.TRACK_MATCHED, DestinationID: "matched"},
+	}
+	jobs := memoryJobs{}
+
+	// neither the playlist nor the already added track are looked up again
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "matched").Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	stored := jobs[j.ID]
+	if stored.Status != job.STATUS_DONE || stored.Count(job.TRACK_ADDED) != 2 {
+		t.Fatalf("expected job to be done with every track added but got %+v", stored)
+	}
+}
+
+func TestShouldPauseJobWhenQuotaIsExhausted(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "First"}, State: job.TRACK_MATCHED, DestinationID: "first"},
+		{Track: provider.Track{Name: "Second"}, State: job.TRACK_MATCHED, DestinationID: "second"},
+	}
+	jobs := memoryJobs{}
+
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "first").Return(nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "second").Return(quota.ErrBudgetExceeded).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if !errors.Is(err, ErrPaused) {
+		t.Fatalf("expected transfer to be paused but got %v", err)
+	}
+	stored := jobs[j.ID]
+	if stored.Status != job.STATUS_PAUSED || stored.ResumeAt.IsZero() {
+		t.Fatalf("expected job to be paused with a resume time but got %+v", stored)
+	}
+	tracks := stored.Playlists[0].Tracks
+	if tracks[0].State != job.TRACK_ADDED || tracks[1].State != job.TRACK_MATCHED {
+		t.Fatalf("expected progress to be checkpointed but got %+v", tracks)
+	}
+}
+
+func TestShouldStopCancelledJob(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	cancelled := *j
+	cancelled.Status = job.STATUS_CANCELLED
+	jobs := memoryJobs{j.ID: cancelled}
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("expected transfer to be cancelled but got %v", err)
+	}
+	if jobs[j.ID].Status != job.STATUS_CANCELLED {
+		t.Fatalf("expected job to stay cancelled but it is %s", jobs[j.ID].Status)
+	}
+}
+
+func TestShouldStopWhenContextIsCancelled(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "First"}, State: job.TRACK_MATCHED, DestinationID: "first"},
+		{Track: provider.Track{Name: "Second"}, State: job.TRACK_MATCHED, DestinationID: "second"},
+	}
+	jobs := memoryJobs{}
+	messages := []ProgressMessage{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the page is closed while the first track is added
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "first").
+		Run(func(ctx context.Context, playlistId string, trackId string) { cancel() }).
+		Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(RecordingPublisher{messages: &messages}).
+		WithJob(j, jobs).
+		Build().
+		Start(ctx)
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("expected transfer to be cancelled but got %v", err)
+	}
+	stored := jobs[j.ID]
+	if stored.Status != job.STATUS_CANCELLED {
+		t.Fatalf("expected job to be cancelled but it is %s", stored.Status)
+	}
+	if tracks := stored.Playlists[0].Tracks; tracks[1].State != job.TRACK_MATCHED {
+		t.Fatalf("expected second track not to be added but got %+v", tracks)
+	}
+	last := messages[len(messages)-1]
+	if last.Type != PROGRESS_CANCELLED {
+		t.Fatalf("expected the last message to be %s but it is %s", PROGRESS_CANCELLED, last.Type)
+	}
+}
+
+func TestShouldRetryRateLimitedJobLater(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "First"}, State: job.TRACK_MATCHED, DestinationID: "first"},
+	}
+	jobs := memoryJobs{}
+
+	rateLimited := provider.NewError(provider.ErrRateLimited, errors.New("too many requests"))
+	rateLimited.RetryAfter = time.Hour
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "first").Return(rateLimited).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if !errors.Is(err, ErrPaused) || !errors.Is(err, provider.ErrRateLimited) {
+		t.Fatalf("expected transfer to be paused by the rate limit but got %v", err)
+	}
+	stored := jobs[j.ID]
+	if stored.Status != job.STATUS_PAUSED || stored.ErrorCode != provider.CODE_RATE_LIMITED {
+		t.Fatalf("expected job to be paused with the error code but got %+v", stored)
+	}
+	if wait := time.Until(stored.ResumeAt); wait < 59*time.Minute || wait > time.Hour {
+		t.Fatalf("expected job to resume after the Retry-After but it resumes at %s", stored.ResumeAt)
+	}
+}
+
+func TestShouldFailTrackMissingOnDestination(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "Deleted"}, State: job.TRACK_MATCHED, DestinationID: "deleted"},
+		{Track: provider.Track{Name: "Available"}, State: job.TRACK_MATCHED, DestinationID: "available"},
+	}
+	jobs := memoryJobs{}
+
+	destination.EXPECT().Name().Return("YouTube")
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "deleted").
+		Return(provider.NewError(provider.ErrNotFound, errors.New("video not found"))).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "available").Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	tracks := jobs[j.ID].Playlists[0].Tracks
+	if tracks[0].State != job.TRACK_FAILED || tracks[1].State != job.TRACK_ADDED {
+		t.Fatalf("expected only the missing track to fail but got %+v", tracks)
+	}
+}
+
+func TestShouldRetryTransientErrors(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "First"}, State: job.TRACK_MATCHED, DestinationID: "first"},
+	}
+	jobs := memoryJobs{}
+	messages := []ProgressMessage{}
+
+	destination.EXPECT().Name().Return("YouTube")
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "first").
+		Return(provider.NewError(provider.ErrTransient, errors.New("backend error"))).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "first").Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(RecordingPublisher{messages: &messages}).
+		WithRetryPolicy(retry.Policy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	retried := false
+	for _, m := range messages {
+		if m.Type == PROGRESS_RETRY {
+			retried = true
+		}
+	}
+	if !retried {
+		t.Fatalf("expected the retry to be reported but got %+v", messages)
+	}
+	if jobs[j.ID].Count(job.TRACK_ADDED) != 1 {
+		t.Fatalf("expected the track to be added on the retry")
+	}
+}
+
+// slowMatcher matches every track to itself, the first tracks taking the longest
+type slowMatcher struct {
+	tracks int
+}
+
+func (m slowMatcher) Match(ctx context.Context, destination provider.Provider, track provider.Track) (match.Result, error) {
+	index, _ := strconv.Atoi(track.ID)
+	time.Sleep(time.Duration(m.tracks-index) * time.Millisecond)
+	return match.Result{Track: provider.Track{ID: "matched-" + track.ID}, Score: 1, Matched: true}, nil
+}
+
+func TestShouldAddConcurrentlyMatchedTracksInOrder(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	const total = 20
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	for i := 0; i < total; i++ {
+		j.Playlists[0].Tracks = append(j.Playlists[0].Tracks,
+			job.Track{Track: provider.Track{ID: strconv.Itoa(i)}, State: job.TRACK_PENDING})
+	}
+	jobs := memoryJobs{}
+
+	added := []string{}
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", mock.Anything).
+		Run(func(ctx context.Context, playlistId string, trackId string) { added = append(added, trackId) }).
+		Return(nil).Times(total)
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithMatcher(slowMatcher{tracks: total}).
+		WithWorkers(5).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	for i, id := range added {
+		if id != "matched-"+strconv.Itoa(i) {
+			t.Fatalf("expected tracks to be added in the origin order but got %v", added)
+		}
+	}
+}
+
+func TestShouldMirrorOriginOrder(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Loaded = true
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{Name: "A"}, State: job.TRACK_MATCHED, DestinationID: "a"},
+		{Track: provider.Track{Name: "B"}, State: job.TRACK_MATCHED, DestinationID: "b"},
+		{Track: provider.Track{Name: "C"}, State: job.TRACK_MATCHED, DestinationID: "c"},
+	}
+	jobs := memoryJobs{}
+
+	// "x" is only on the destination, so it ends up last
+	existing := &provider.FullPlaylist{Tracks: []provider.Track{{ID: "c"}, {ID: "x"}, {ID: "a"}}}
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(existing, nil).Once()
+	destination.EXPECT().MovePlaylistItem(mock.Anything, "destination-ID", 2, 0).Return(nil).Once()
+	destination.EXPECT().InsertIntoPlaylist(mock.Anything, "destination-ID", "b", 1).Return(nil).Once()
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithOrder(ORDER_MIRROR).
+		WithJob(j, jobs).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	tracks := jobs[j.ID].Playlists[0].Tracks
+	if tracks[0].State != job.TRACK_SKIPPED || tracks[1].State != job.TRACK_ADDED || tracks[2].State != job.TRACK_SKIPPED {
+		t.Fatalf("expected only the missing track to be added but got %+v", tracks)
+	}
+}
+
+// estimatingProvider costs one unit per call
+type estimatingProvider struct {
+	*provider.MockProvider
+}
+
+func (p estimatingProvider) EstimateCost(operation string, count int) int {
+	return count
+}
+
+type memoryPlans map[string]Plan
+
+func (m memoryPlans) Save(p *Plan) error {
+	m[p.ID] = *p
+	return nil
+}
+
+func (m memoryPlans) Get(id string) (*Plan, error) {
+	p, ok := m[id]
+	if !ok {
+		return nil, ErrPlanNotFound
+	}
+	return &p, nil
+}
+
+func TestShouldPlanWithoutChangingDestination(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}, {ID: "other-ID", Name: "other"}}
+	present := provider.Track{Name: "Present", Artists: []string{"Artist"}}
+	missing := provider.Track{Name: "Missing", Artists: []string{"Artist"}}
+	unmatched := provider.Track{Name: "Unmatched", Artists: []string{"Artist"}}
+	other := provider.Track{Name: "Other", Artists: []string{"Artist"}}
+
+	origin.EXPECT().Name().Return("Spotify").Maybe()
+	destination.EXPECT().Name().Return("YouTube").Maybe()
+	// more than a page of playlists
+	destinationPlaylists := []provider.Playlist{{ID: "destination-ID", Name: "playlist"}}
+	for i := 0; i < provider.PLAYLISTS_PAGE_SIZE; i++ {
+		destinationPlaylists = append(destinationPlaylists, provider.Playlist{ID: provider.PlaylistID(strconv.Itoa(i)), Name: "unrelated " + strconv.Itoa(i)})
+	}
+	destination.EXPECT().GetPlaylists(mock.Anything).Return(destinationPlaylists, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "present-video"}},
+	}, nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{present, missing, unmatched},
+	}, nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "other-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{other},
+	}, nil).Once()
+	for id, track := range map[string]provider.Track{"present-video": present, "missing-video": missing, "other-video": other} {
+		destination.EXPECT().SearchTracks(mock.Anything, track.FullName(), mock.Anything).Return([]provider.Track{
+			{ID: id, Name: track.Name, Artists: []string{"Artist - Topic"}},
+		}, nil).Once()
+	}
+	destination.EXPECT().SearchTracks(mock.Anything, unmatched.FullName(), mock.Anything).Return([]provider.Track{}, nil).Once()
+
+	messages := []ProgressMessage{}
+	plans := memoryPlans{}
+	err := Transfer().
+		From(origin).
+		To(estimatingProvider{destination}).
+		Playlists(playlists).
+		WithProgressPublisher(RecordingPublisher{messages: &messages}).
+		DryRun(plans).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if len(plans) != 1 {
+		t.Fatalf("expected the plan to be saved but got %v", plans)
+	}
+	var plan Plan
+	for _, p := range plans {
+		plan = p
+	}
+	first, second := plan.Playlists[0], plan.Playlists[1]
+	if !first.Exists || len(first.Present) != 1 || len(first.Add) != 1 || len(first.Unmatched) != 1 {
+		t.Fatalf("expected a track of each kind on the existing playlist but got %+v", first)
+	}
+	if first.Add[0].ID != "missing-video" || first.Unmatched[0].Name != "Unmatched" {
+		t.Fatalf("expected the missing track to be added but got %+v", first)
+	}
+	if second.Exists || len(second.Add) != 1 {
+		t.Fatalf("expected the other playlist to be created with its track but got %+v", second)
+	}
+	// 1 track read, 2 pages of playlists listed for each playlist, 1 created, 2 tracks added and 4 searched
+	if len(plan.Cost) != 1 || plan.Cost["YouTube"] != 12 {
+		t.Fatalf("expected the cost of the destination calls but got %v", plan.Cost)
+	}
+	if last := messages[len(messages)-1]; last.Type != PROGRESS_PLAN {
+		t.Fatalf("expected the plan to be published but got %v", last)
+	}
+}
+
+func TestPlanShouldCountSearchesOfTracksNotOnTheCache(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	cached := provider.Track{Name: "Cached", Artists: []string{"Artist"}}
+	unmatched := provider.Track{Name: "Unmatched", Artists: []string{"Artist"}}
+	origin.EXPECT().Name().Return("Spotify").Maybe()
+	destination.EXPECT().Name().Return("YouTube").Maybe()
+	destination.EXPECT().GetPlaylists(mock.Anything).Return([]provider.Playlist{}, nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{cached, unmatched},
+	}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, unmatched.FullName(), mock.Anything).Return([]provider.Track{}, nil).Once()
+
+	plan, err := Transfer().
+		From(origin).
+		To(estimatingProvider{destination}).
+		Playlists([]provider.Playlist{{ID: "origin-ID", Name: "playlist"}}).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithMatchCache(mapMatchCache{"YouTube" + cached.FullName(): "cached-video"}).
+		Build().
+		Plan(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	// 1 playlist found and created, 1 track added and the unmatched one searched again
+	if plan.Cost["YouTube"] != 4 {
+		t.Fatalf("expected the unmatched track to be searched again but got %v", plan.Cost)
+	}
+}
+
+type memorySyncs map[string]SyncState
+
+func (m memorySyncs) Save(s *SyncState) error {
+	state := *s
+	state.Tracks = map[string]SyncedTrack{}
+	for id, track := range s.Tracks {
+		state.Tracks[id] = track
+	}
+	m[syncKey(s.Origin, s.Destination, s.PlaylistID)] = state
+	return nil
+}
+
+func (m memorySyncs) List() ([]SyncState, error) {
+	states := []SyncState{}
+	for _, s := range m {
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+func (m memorySyncs) Get(origin string, destination string, playlistID provider.PlaylistID) (*SyncState, error) {
+	s, ok := m[syncKey(origin, destination, playlistID)]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+func TestShouldOnlySyncChangesSinceLastSync(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	kept := provider.Track{ID: "kept", Name: "Kept", Artists: []string{"Artist"}}
+	removed := provider.Track{ID: "removed", Name: "Removed", Artists: []string{"Artist"}}
+	added := provider.Track{ID: "added", Name: "Added", Artists: []string{"Artist"}}
+	syncs := memorySyncs{
+		syncKey("spotify", "google", "origin-ID"): {
+			Origin: "spotify", Destination: "google", PlaylistID: "origin-ID", DestinationID: "renamed-ID",
+			Tracks: map[string]SyncedTrack{
+				"kept":    {DestinationID: "kept-video", Name: kept.FullName()},
+				"removed": {DestinationID: "removed-video", Name: removed.FullName()},
+			},
+		},
+	}
+
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{kept, added},
+	}, nil).Once()
+	destination.EXPECT().RemoveFromPlaylist(mock.Anything, "renamed-ID", "removed-video").Return(nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "renamed-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "kept-video"}},
+	}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, added.FullName(), mock.Anything).Return([]provider.Track{
+		{ID: "added-video", Name: "Added", Artists: []string{"Artist - Topic"}},
+	}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "renamed-ID", "added-video").Return(nil).Once()
+
+	j := job.New("spotify", "google", playlists)
+	jobs := memoryJobs{}
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		WithSync(syncs, true).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	state := syncs[syncKey("spotify", "google", "origin-ID")]
+	if _, ok := state.Tracks["removed"]; ok || state.Tracks["added"].DestinationID != "added-video" || len(state.Tracks) != 2 {
+		t.Fatalf("expected the removed track to be replaced by the added one but got %+v", state.Tracks)
+	}
+	if removed := jobs[j.ID].Removed(); removed != 1 {
+		t.Fatalf("expected 1 track to be removed but got %d", removed)
+	}
+}
+
+type memoryRuns []SyncRun
+
+func (m *memoryRuns) Save(r *SyncRun) error {
+	*m = append(*m, *r)
+	return nil
+}
+
+func (m *memoryRuns) List() ([]SyncRun, error) {
+	return *m, nil
+}
+
+func TestRunnerShouldNotSyncPlaylistTwiceAtOnce(t *testing.T) {
+	runs := &memoryRuns{}
+	runner := NewRunner(memoryJobs{}, nil, nil).WithHistory(runs)
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Mode = MODE_SYNC
+	// another job is syncing the same playlist
+	other := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	other.Mode = MODE_SYNC
+	runner.acquire(runner.lockKeys(other)...)
+	if !runner.Busy(j) {
+		t.Fatalf("expected the runner to be busy with the playlist")
+	}
+
+	err := runner.Start(context.Background(), j, getMockProvider(t), getMockProvider(t), NoOpPublisher{})
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected the sync not to start but got %v", err)
+	}
+	if len(*runs) != 1 || (*runs)[0].Status != RUN_SKIPPED {
+		t.Fatalf("expected a skipped run to be saved but got %+v", *runs)
+	}
+}
+
+func TestSchedulerShouldSyncScheduledPairs(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+	track := provider.Track{ID: "track", Name: "Song", Artists: []string{"Artist"}}
+	syncs := memorySyncs{}
+	_ = syncs.Save(&SyncState{
+		Origin: "spotify", Destination: "google", PlaylistID: "origin-ID", Name: "playlist",
+		DestinationID: "destination-ID", Tracks: map[string]SyncedTrack{}, Scheduled: true, Owner: "spotify:paulo",
+	})
+	_ = syncs.Save(&SyncState{
+		Origin: "spotify", Destination: "google", PlaylistID: "other-ID", Name: "other",
+		DestinationID: "other-destination-ID", Tracks: map[string]SyncedTrack{},
+	})
+
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{Tracks: []provider.Track{track}}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, "Artist - Song", mock.Anything).Return([]provider.Track{
+		{ID: "video", Name: "Song", Artists: []string{"Artist - Topic"}},
+	}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "video").Return(nil).Once()
+
+	jobs := memoryJobs{}
+	runs := &memoryRuns{}
+	providers := func(j *job.Job) (provider.Provider, provider.Provider, error) {
+		if j.Owner != "spotify:paulo" {
+			t.Fatalf("expected the job to run with the tokens of the owner but got %q", j.Owner)
+		}
+		return origin, destination, nil
+	}
+	runner := NewRunner(jobs, providers, nil).WithSyncs(syncs).WithHistory(runs)
+	pacific, _ := time.LoadLocation("America/Los_Angeles")
+	nightly, _ := schedule.Parse(schedule.DEFAULT_SCHEDULE, pacific)
+	NewScheduler(runner, syncs, nightly).SyncScheduled(context.Background())
+
+	if len(*runs) != 1 {
+		t.Fatalf("expected only the scheduled pair to be synced but got %+v", *runs)
+	}
+	run := (*runs)[0]
+	if !run.Scheduled || run.Status != job.STATUS_DONE || run.Added != 1 {
+		t.Fatalf("expected a scheduled run that added the track but got %+v", run)
+	}
+	state, _ := syncs.Get("spotify", "google", "origin-ID")
+	if state.Tracks["track"].DestinationID != "video" {
+		t.Fatalf("expected the track to be synced but got %+v", state.Tracks)
+	}
+}
+
+func TestDiffBothWays(t *testing.T) {
+	syncedAt := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	state := &TwoWayState{
+		SyncedAt: syncedAt,
+		Links: []Link{
+			{A: "kept-a", B: "kept-b"},
+			{A: "removed-a", B: "removed-b"},
+			{A: "conflict-a", B: "conflict-b"},
+			{A: "gone-a", B: "gone-b"},
+			{A: "unmatched-a"},
+		},
+		Conflicts: []Conflict{{Link: Link{A: "review-a", B: "review-b"}, RemovedFrom: SIDE_A}},
+	}
+	a := []provider.Track{
+		{ID: "kept-a"}, {ID: "unmatched-a"}, {ID: "new-a"}, {ID: "new-a"},
+	}
+	b := []provider.Track{
+		{ID: "kept-b"}, {ID: "removed-b"}, {ID: "review-b"}, {ID: "new-b"},
+		{ID: "conflict-b", AddedAt: syncedAt.Add(time.Hour)},
+	}
+
+	diff := diffBothWays(state, a, b)
+
+	if len(diff.kept) != 2 || diff.kept[0].A != "kept-a" || diff.kept[1].A != "unmatched-a" {
+		t.Fatalf("expected the links on both playlists to be kept but got %+v", diff.kept)
+	}
+	if len(diff.removedA) != 1 || diff.removedA[0].B != "removed-b" || len(diff.removedB) != 0 {
+		t.Fatalf("expected the track removed from A to be removed but got %+v %+v", diff.removedA, diff.removedB)
+	}
+	if len(diff.conflicts) != 1 || diff.conflicts[0].Link.B != "conflict-b" || diff.conflicts[0].RemovedFrom != SIDE_A {
+		t.Fatalf("expected the track added again to B to be a conflict but got %+v", diff.conflicts)
+	}
+	if len(diff.addedA) != 1 || diff.addedA[0].ID != "new-a" || len(diff.addedB) != 1 || diff.addedB[0].ID != "new-b" {
+		t.Fatalf("expected the new tracks to be added once but got %+v %+v", diff.addedA, diff.addedB)
+	}
+}
+
+type memoryTwoWays map[string]TwoWayState
+
+func (m memoryTwoWays) Save(s *TwoWayState) error {
+	s = s.stored()
+	state := *s
+	state.Links = append([]Link{}, s.Links...)
+	state.Conflicts = append([]Conflict{}, s.Conflicts...)
+	m[s.Key()] = state
+	return nil
+}
+
+func (m memoryTwoWays) Get(a string, b string, playlistA provider.PlaylistID) (*TwoWayState, error) {
+	s, ok := m[syncKey(a, b, playlistA)]
+	if !ok {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+func (m memoryTwoWays) List() ([]TwoWayState, error) {
+	states := []TwoWayState{}
+	for _, s := range m {
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+func TestShouldSyncBothWays(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	syncedAt := time.Now().Add(-time.Hour)
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	added := provider.Track{ID: "added-video", Name: "Added", Artists: []string{"Artist - Topic"}}
+	twoWays := memoryTwoWays{
+		syncKey("spotify", "google", "origin-ID"): {
+			A: "spotify", B: "google", PlaylistA: "origin-ID", PlaylistB: "destination-ID",
+			SyncedAt: syncedAt,
+			Links: []Link{
+				{A: "kept", B: "kept-video", Name: "Artist - Kept"},
+				{A: "removed", B: "removed-video", Name: "Artist - Removed"},
+				{A: "conflict", B: "conflict-video", Name: "Artist - Conflict"},
+			},
+		},
+	}
+
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "kept"}, {ID: "conflict", AddedAt: time.Now()}},
+	}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "kept-video"}, {ID: "removed-video"}, added},
+	}, nil).Once()
+	// removed from the origin
+	destination.EXPECT().RemoveFromPlaylist(mock.Anything, "destination-ID", "removed-video").Return(nil).Once()
+	// removed from the destination but added again to the origin, kept with POLICY_UNION
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "conflict-video").Return(nil).Once()
+	// added to the destination
+	origin.EXPECT().SearchTracks(mock.Anything, added.FullName(), mock.Anything).Return([]provider.Track{
+		{ID: "added", Name: "Added", Artists: []string{"Artist"}},
+	}, nil).Once()
+	origin.EXPECT().AddToPlaylist(mock.Anything, "origin-ID", "added").Return(nil).Once()
+
+	j := job.New("spotify", "google", playlists)
+	j.Owner = "spotify:user"
+	jobs := memoryJobs{}
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, jobs).
+		WithTwoWaySync(twoWays, POLICY_UNION).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	state := twoWays[syncKey("spotify", "google", "origin-ID")]
+	linked := map[string]string{}
+	for _, l := range state.Links {
+		linked[l.A] = l.B
+	}
+	if len(linked) != 3 || linked["kept"] != "kept-video" || linked["conflict"] != "conflict-video" || linked["added"] != "added-video" {
+		t.Fatalf("expected the kept, conflicting and added tracks to be linked but got %+v", state.Links)
+	}
+	if len(state.Conflicts) != 0 || !state.SyncedAt.After(syncedAt) {
+		t.Fatalf("expected the conflict to be resolved and the sync time to be updated but got %+v", state)
+	}
+	if state.Owner != j.Owner {
+		t.Fatalf("expected the pair synced before owners were kept to be taken by the job owner but got %q", state.Owner)
+	}
+	if removed := jobs[j.ID].Removed(); removed != 1 {
+		t.Fatalf("expected 1 track to be removed but got %d", removed)
+	}
+}
+
+func TestShouldSyncBothWaysInOrder(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	const total = 20
+	twoWays := memoryTwoWays{
+		syncKey("spotify", "google", "origin-ID"): {
+			A: "spotify", B: "google", PlaylistA: "origin-ID", PlaylistB: "destination-ID",
+			SyncedAt: time.Now().Add(-time.Hour),
+		},
+	}
+	tracks := []provider.Track{}
+	for i := 0; i < total; i++ {
+		tracks = append(tracks, provider.Track{ID: strconv.Itoa(i)})
+	}
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{Tracks: tracks}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	added := []string{}
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", mock.Anything).
+		Run(func(ctx context.Context, playlistId string, trackId string) { added = append(added, trackId) }).
+		Return(nil).Times(total)
+
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithMatcher(slowMatcher{tracks: total}).
+		WithWorkers(5).
+		WithJob(job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}), memoryJobs{}).
+		WithTwoWaySync(twoWays, POLICY_UNION).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	links := twoWays[syncKey("spotify", "google", "origin-ID")].Links
+	for i, id := range added {
+		if id != "matched-"+strconv.Itoa(i) || links[i].A != strconv.Itoa(i) {
+			t.Fatalf("expected tracks to be added and linked in the origin order but got %v, %+v", added, links)
+		}
+	}
+}
+
+func TestShouldSyncBothWaysFromEitherSide(t *testing.T) {
+	google := getMockProvider(t)
+	spotify := getMockProvider(t)
+
+	twoWays := memoryTwoWays{
+		syncKey("spotify", "google", "origin-ID"): {
+			A: "spotify", B: "google", PlaylistA: "origin-ID", PlaylistB: "destination-ID",
+			SyncedAt: time.Now().Add(-time.Hour),
+			Links:    []Link{{A: "kept", B: "kept-video", Name: "Artist - Kept"}},
+		},
+	}
+	// started the other way around
+	j := job.New("google", "spotify", []provider.Playlist{{ID: "destination-ID", Name: "playlist"}})
+	j.Mode = MODE_TWO_WAY
+	other := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	other.Mode = MODE_TWO_WAY
+	runner := NewRunner(memoryJobs{}, nil, nil).WithTwoWaySyncs(twoWays)
+	runner.acquire(runner.lockKeys(other)...)
+	if !runner.Busy(j) {
+		t.Fatalf("expected the pair to be locked both ways")
+	}
+
+	added := provider.Track{ID: "added-video", Name: "Added", Artists: []string{"Artist - Topic"}}
+	google.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "kept-video"}, added},
+	}, nil).Once()
+	spotify.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "kept"}},
+	}, nil).Once()
+	spotify.EXPECT().SearchTracks(mock.Anything, added.FullName(), mock.Anything).Return([]provider.Track{
+		{ID: "added", Name: "Added", Artists: []string{"Artist"}},
+	}, nil).Once()
+	spotify.EXPECT().AddToPlaylist(mock.Anything, "origin-ID", "added").Return(nil).Once()
+
+	err := Transfer().
+		From(google).
+		To(spotify).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, memoryJobs{}).
+		WithTwoWaySync(twoWays, POLICY_UNION).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	state, ok := twoWays[syncKey("spotify", "google", "origin-ID")]
+	if len(twoWays) != 1 || !ok {
+		t.Fatalf("expected both directions to share the same state but got %+v", twoWays)
+	}
+	if len(state.Links) != 2 || state.Links[1].A != "added" || state.Links[1].B != "added-video" {
+		t.Fatalf("expected the added track to be linked on the state but got %+v", state.Links)
+	}
+}
+
+func TestShouldListUnmatchedTracksForReview(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	matched := provider.Track{ID: "matched", Name: "Matched", Artists: []string{"Artist"}}
+	unmatched := provider.Track{ID: "unmatched", Name: "Bohemian Rhapsody", Artists: []string{"Queen"}}
+
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("destination-ID", nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{matched, unmatched},
+	}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, matched.FullName(), mock.Anything).Return([]provider.Track{
+		{ID: "matched-video", Name: "Matched", Artists: []string{"Artist - Topic"}},
+	}, nil).Once()
+	destination.EXPECT().SearchTracks(mock.Anything, unmatched.FullName(), mock.Anything).Return([]provider.Track{
+		{ID: "other-video", Name: "Never Gonna Give You Up", Artists: []string{"Rick Astley"}},
+	}, nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "matched-video").Return(nil).Once()
+
+	j := job.New("spotify", "google", playlists)
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(NoOpPublisher{}).
+		WithJob(j, memoryJobs{}).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	tracks := j.Playlists[0].Tracks
+	if tracks[0].Review != "" {
+		t.Fatalf("expected the matched track not to be reviewed but got %+v", tracks[0])
+	}
+	if tracks[1].Review != job.REVIEW_PENDING || len(tracks[1].Candidates) != 1 || tracks[1].Candidates[0].ID != "other-video" {
+		t.Fatalf("expected the unmatched track to be listed with its candidates but got %+v", tracks[1])
+	}
+}
+
+func TestRunnerShouldApplyReviewResolutions(t *testing.T) {
+	destination := getMockProvider(t)
+	destination.EXPECT().Name().Return("YouTube").Maybe()
+
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Status = job.STATUS_DONE
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{ID: "unmatched"}, State: job.TRACK_SKIPPED, Review: job.REVIEW_RESOLVED, Resolution: "chosen"},
+		{Track: provider.Track{ID: "wrong"}, State: job.TRACK_ADDED, DestinationID: "wrong-video", Review: job.REVIEW_RESOLVED, Resolution: "right"},
+		{Track: provider.Track{ID: "pending"}, State: job.TRACK_SKIPPED, Review: job.REVIEW_PENDING},
+		{Track: provider.Track{ID: "ignored"}, State: job.TRACK_SKIPPED, Review: job.REVIEW_IGNORED},
+	}
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "chosen").Return(nil).Once()
+	destination.EXPECT().RemoveLastFromPlaylist(mock.Anything, "destination-ID", "wrong-video").Return(nil).Once()
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "right").Return(nil).Once()
+
+	jobs := memoryJobs{}
+	err := NewRunner(jobs, nil, nil).ApplyReview(context.Background(), j, destination)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	tracks := jobs[j.ID].Playlists[0].Tracks
+	for _, track := range tracks[:2] {
+		if track.State != job.TRACK_ADDED || track.DestinationID != track.Resolution || track.Review != job.REVIEW_APPLIED {
+			t.Fatalf("expected the resolution to be applied but got %+v", track)
+		}
+	}
+	if tracks[2].Review != job.REVIEW_PENDING || tracks[3].Review != job.REVIEW_IGNORED {
+		t.Fatalf("expected the other tracks to be left as they are but got %+v", tracks[2:])
+	}
+}
+
+func TestRunnerShouldNotApplyReviewWhileSyncingThePlaylist(t *testing.T) {
+	runner := NewRunner(memoryJobs{}, nil, nil)
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	j.Mode = MODE_SYNC
+	j.Playlists[0].DestinationID = "destination-ID"
+	j.Playlists[0].Tracks = []job.Track{
+		{Track: provider.Track{ID: "wrong"}, State: job.TRACK_ADDED, DestinationID: "wrong-video", Review: job.REVIEW_RESOLVED, Resolution: "right"},
+	}
+	// a scheduled sync of the same playlist
+	other := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	other.Mode = MODE_SYNC
+	runner.acquire(runner.lockKeys(other)...)
+
+	err := runner.ApplyReview(context.Background(), j, getMockProvider(t))
+	if !errors.Is(err, ErrAlreadyRunning) {
+		t.Fatalf("expected the review not to be applied but got %v", err)
+	}
+	if j.Playlists[0].Tracks[0].Review != job.REVIEW_RESOLVED {
+		t.Fatalf("expected the resolution to be left to apply but got %+v", j.Playlists[0].Tracks[0])
+	}
+}
+
+type EventRecordingPublisher struct {
+	events *[]ProgressEvent
+}
+
+func (p EventRecordingPublisher) Publish(progressType string, body string) error {
+	return p.PublishEvent(ProgressEvent{Type: progressType, Body: body})
+}
+
+func (p EventRecordingPublisher) PublishEvent(e ProgressEvent) error {
+	*p.events = append(*p.events, e)
+	return nil
+}
+
+func TestShouldPublishTrackEventsWithProgress(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+
+	playlists := []provider.Playlist{{ID: "origin-ID", Name: "playlist"}}
+	present := provider.Track{ID: "present", Name: "Present", Artists: []string{"Artist"}}
+	added := provider.Track{ID: "added", Name: "Added", Artists: []string{"Artist"}}
+
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("destination-ID", nil).Once()
+	origin.EXPECT().GetFullPlaylist(mock.Anything, "origin-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{present, added},
+	}, nil).Once()
+	destination.EXPECT().GetFullPlaylist(mock.Anything, "destination-ID").Return(&provider.FullPlaylist{
+		Tracks: []provider.Track{{ID: "present-video"}},
+	}, nil).Once()
+	for id, track := range map[string]provider.Track{"present-video": present, "added-video": added} {
+		destination.EXPECT().SearchTracks(mock.Anything, track.FullName(), mock.Anything).Return([]provider.Track{
+			{ID: id, Name: track.Name, Artists: []string{"Artist - Topic"}},
+		}, nil).Once()
+	}
+	destination.EXPECT().AddToPlaylist(mock.Anything, "destination-ID", "added-video").Return(nil).Once()
+
+	j := job.New("spotify", "google", playlists)
+	events := []ProgressEvent{}
+	err := Transfer().
+		From(origin).
+		To(destination).
+		WithProgressPublisher(EventRecordingPublisher{events: &events}).
+		WithJob(j, memoryJobs{}).
+		Build().
+		Start(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	tracks := map[string]ProgressEvent{}
+	for _, e := range events {
+		if e.Version != PROTOCOL_VERSION || e.Time.IsZero() {
+			t.Fatalf("expected every event to have a version and time but got %+v", e)
+		}
+		if e.Track != "" {
+			tracks[e.Type] = e
+		}
+	}
+	duplicate, ok := tracks[PROGRESS_TRACK_DUPLICATE]
+	if !ok || duplicate.Track != present.FullName() || duplicate.Match == nil || duplicate.Match.ID != "present-video" {
+		t.Fatalf("expected the track already on the destination to be reported but got %+v", duplicate)
+	}
+	done, ok := tracks[PROGRESS_TRACK_DONE]
+	if !ok || done.JobID != j.ID || done.Playlist == nil || *done.Playlist != 0 || done.PlaylistID != "origin-ID" {
+		t.Fatalf("expected the added track to be reported with its playlist but got %+v", done)
+	}
+	expected := EventCounts{Done: 2, Total: 2, Added: 1, Skipped: 1}
+	if done.Counts == nil || *done.Counts != expected {
+		t.Fatalf("expected counts %+v but got %+v", expected, done.Counts)
+	}
+}
+
+func TestBroadcasterShouldReplayEventsAfterLastEventID(t *testing.T) {
+	b := NewBroadcaster()
+	publisher := b.Publisher("job")
+	_ = publisher.Publish(PROGRESS_STARTED_PLAYLSIT, "playlist")
+	_ = b.Publisher("other").Publish(PROGRESS_STARTED_PLAYLSIT, "other")
+	_ = publisher.Publish(PROGRESS_PLAYLIST_DONE, "")
+
+	missed, _, unsubscribe := b.Subscribe("job", 0)
+	unsubscribe()
+	if len(missed) != 2 || missed[0].JobID != "job" || missed[1].Type != PROGRESS_PLAYLIST_DONE {
+		t.Fatalf("expected every event of the job to be replayed but got %+v", missed)
+	}
+
+	missed, events, unsubscribe := b.Subscribe("job", missed[0].ID)
+	defer unsubscribe()
+	if len(missed) != 1 || missed[0].Type != PROGRESS_PLAYLIST_DONE {
+		t.Fatalf("expected the events after the last one to be replayed but got %+v", missed)
+	}
+	_ = publisher.Publish(PROGRESS_TRANSFER_DONE, "")
+	e := <-events
+	if e.Type != PROGRESS_TRANSFER_DONE || e.ID <= missed[0].ID || !Final(e.ProgressEvent) {
+		t.Fatalf("expected the new event to be sent to the subscriber but got %+v", e)
+	}
+}
+
+func TestBroadcasterShouldDropSubscribersThatFallBehind(t *testing.T) {
+	b := NewBroadcaster()
+	_, events, unsubscribe := b.Subscribe("job", 0)
+	defer unsubscribe()
+	for i := 0; i <= BROADCAST_BUFFER; i++ {
+		_ = b.Publisher("job").Publish(PROGRESS_CACHE_HIT, "")
+	}
+	received := 0
+	var lastID int64
+	for e := range events {
+		received++
+		lastID = e.ID
+	}
+	if received != BROADCAST_BUFFER {
+		t.Fatalf("expected the channel to be closed after %d events but got %d", BROADCAST_BUFFER, received)
+	}
+	missed, _, unsubscribeAgain := b.Subscribe("job", lastID)
+	unsubscribeAgain()
+	if len(missed) != 1 {
+		t.Fatalf("expected the dropped subscriber to get the rest on the next subscription but got %d", len(missed))
+	}
+}
+
+func TestBroadcasterIDsShouldKeepGrowingAfterARestart(t *testing.T) {
+	before := NewBroadcaster()
+	_ = before.Publisher("job").Publish(PROGRESS_STARTED_PLAYLSIT, "playlist")
+	missed, _, unsubscribe := before.Subscribe("job", 0)
+	unsubscribe()
+	if missed[0].ID >= 1<<53 {
+		t.Fatalf("expected IDs that JSON numbers can keep but got %d", missed[0].ID)
+	}
+
+	// IDs start from the time in milliseconds
+	time.Sleep(2 * time.Millisecond)
+	restarted := NewBroadcaster()
+	_ = restarted.Publisher("job").Publish(PROGRESS_STARTED_PLAYLSIT, "playlist")
+	replayed, _, unsubscribe := restarted.Subscribe("job", missed[0].ID)
+	unsubscribe()
+	if len(replayed) != 1 {
+		t.Fatalf("expected the events after the restart to be replayed but got %+v", replayed)
+	}
+}
+
+func TestRunnerShouldBroadcastWhyJobsFailed(t *testing.T) {
+	origin := getMockProvider(t)
+	destination := getMockProvider(t)
+	destination.EXPECT().FindPlaylistByName(mock.Anything, "playlist").Return("", errors.New("boom")).Once()
+
+	b := NewBroadcaster()
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	err := NewRunner(memoryJobs{}, nil, nil).WithBroadcaster(b).Start(context.Background(), j, origin, destination, NoOpPublisher{})
+	if err == nil {
+		t.Fatal("expected the job to fail")
+	}
+	missed, _, unsubscribe := b.Subscribe(j.ID, 0)
+	unsubscribe()
+	last := missed[len(missed)-1]
+	if last.Type != PROGRESS_TRANFER_ERROR || last.Body != "boom" || last.JobID != j.ID {
+		t.Fatalf("expected the error to be the last event but got %+v", missed)
+	}
+}
+
+func TestTerminalPublisherShouldPrintWhatNeedsAttention(t *testing.T) {
+	var out strings.Builder
+	p := NewTerminalProgressPublisher(&out, false)
+	j := job.New("spotify", "google", []provider.Playlist{{ID: "origin-ID", Name: "playlist"}})
+	playlist := &j.Playlists[0]
+	playlist.Tracks = []job.Track{
+		{Track: provider.Track{Name: "Song", Artists: []string{"Artist"}}, State: job.TRACK_ADDED},
+		{Track: provider.Track{Name: "Other", Artists: []string{"Artist"}}, State: job.TRACK_SKIPPED},
+	}
+	_ = p.PublishEvent(playlistEvent(PROGRESS_STARTED_PLAYLSIT, j, playlist))
+	_ = p.PublishEvent(playlistEvent(PROGRESS_TRACK_DONE, j, playlist))
+	unmatched := playlistEvent(PROGRESS_TRACK_UNMATCHED, j, playlist)
+	unmatched.Track = "Artist - Other"
+	_ = p.PublishEvent(unmatched)
+	_ = p.PublishEvent(playlistEvent(PROGRESS_PLAYLIST_DONE, j, playlist))
+	p.Fail(errors.New("boom"))
+
+	expected := "playlist\n  no match: Artist - Other\n  2/2 tracks, 1 added, 1 skipped, 0 failed\nError: boom\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, out.String())
+	}
 }
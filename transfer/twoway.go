@@ -0,0 +1,562 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/paulombcosta/waltz/job"
+	"github.com/paulombcosta/waltz/match"
+	"github.com/paulombcosta/waltz/provider"
+	"github.com/paulombcosta/waltz/store"
+)
+
+const TWO_WAY_BUCKET = "two-way-syncs"
+
+// How two-way syncs resolve conflicts, i.e. tracks removed from one playlist and added
+// again to the other since the last sync.
+const (
+	// POLICY_SOURCE_WINS does what was done on the origin
+	POLICY_SOURCE_WINS = "source-wins"
+	// POLICY_UNION keeps the track on both playlists
+	POLICY_UNION = "union"
+	// POLICY_MANUAL leaves the track as it is until the conflict is reviewed
+	POLICY_MANUAL = "manual"
+)
+
+// The side of a two-way sync, A is the origin and B the destination.
+const (
+	SIDE_A = "a"
+	SIDE_B = "b"
+)
+
+// Resolutions of conflicts that are reviewed manually.
+const (
+	// RESOLUTION_KEEP adds the track back to the playlist it was removed from
+	RESOLUTION_KEEP = "keep"
+	// RESOLUTION_REMOVE removes the track from the playlist it was added to again
+	RESOLUTION_REMOVE = "remove"
+)
+
+// Link is the same track on both playlists of a two-way sync.
+type Link struct {
+	// A and B are the IDs of the track on each playlist, one of them is empty for
+	// tracks without a match, so they aren't searched on every sync
+	A    string `json:"a,omitempty"`
+	B    string `json:"b,omitempty"`
+	Name string `json:"name"`
+}
+
+func (l Link) ID(side string) string {
+	if side == SIDE_A {
+		return l.A
+	}
+	return l.B
+}
+
+type Conflict struct {
+	Link Link `json:"link"`
+	// RemovedFrom is the side the track was removed from, it was added again on the other
+	RemovedFrom string `json:"removedFrom"`
+	// Resolution is set when the conflict is reviewed and applied on the next sync
+	Resolution string `json:"resolution,omitempty"`
+}
+
+// TwoWayState is the snapshot of a two-way sync, compared with both playlists to
+// know what changed on each since the last sync.
+type TwoWayState struct {
+	// A and B are the providers of the job, e.g. "spotify"
+	A         string              `json:"a"`
+	B         string              `json:"b"`
+	PlaylistA provider.PlaylistID `json:"playlistA"`
+	PlaylistB string              `json:"playlistB"`
+	Name      string              `json:"name"`
+	Policy    string              `json:"policy"`
+	Links     []Link              `json:"links"`
+	// Conflicts waiting for a manual review, their tracks aren't synced until then
+	Conflicts []Conflict `json:"conflicts"`
+	SyncedAt  time.Time  `json:"syncedAt"`
+	// Owner is the user who first synced the pair, only they can resolve its conflicts
+	Owner string `json:"owner,omitempty"`
+	// reversed is set on the states of pairs synced from B to A, they're saved the other
+	// way around, so both directions share the same state
+	reversed bool
+}
+
+func (s TwoWayState) Key() string {
+	return syncKey(s.A, s.B, s.PlaylistA)
+}
+
+// reverse returns the state with its sides swapped.
+func (s TwoWayState) reverse() *TwoWayState {
+	r := s
+	r.A, r.B = s.B, s.A
+	r.PlaylistA, r.PlaylistB = provider.PlaylistID(s.PlaylistB), string(s.PlaylistA)
+	r.Links = []Link{}
+	for _, l := range s.Links {
+		r.Links = append(r.Links, Link{A: l.B, B: l.A, Name: l.Name})
+	}
+	r.Conflicts = []Conflict{}
+	for _, c := range s.Conflicts {
+		c.Link = Link{A: c.Link.B, B: c.Link.A, Name: c.Link.Name}
+		c.RemovedFrom = otherSide(c.RemovedFrom)
+		r.Conflicts = append(r.Conflicts, c)
+	}
+	r.reversed = !s.reversed
+	return &r
+}
+
+// stored returns the state the way it's saved.
+func (s *TwoWayState) stored() *TwoWayState {
+	if s.reversed {
+		return s.reverse()
+	}
+	return s
+}
+
+// findTwoWayState returns the state of the pair synced from a to b, whichever way it
+// was first synced, or nil when it never was.
+func findTwoWayState(twoWays TwoWayRepository, a string, b string, playlistA provider.PlaylistID) (*TwoWayState, error) {
+	state, err := twoWays.Get(a, b, playlistA)
+	if err != nil || state != nil {
+		return state, err
+	}
+	states, err := twoWays.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range states {
+		if s.A == b && s.B == a && s.PlaylistB == string(playlistA) {
+			return s.reverse(), nil
+		}
+	}
+	return nil, nil
+}
+
+// lockKeys lock each playlist of the pair, so it isn't synced both ways at once in
+// opposite directions.
+func (s TwoWayState) lockKeys() []string {
+	keys := []string{MODE_TWO_WAY + ":" + s.A + ":" + string(s.PlaylistA)}
+	if s.PlaylistB != "" {
+		keys = append(keys, MODE_TWO_WAY+":"+s.B+":"+s.PlaylistB)
+	}
+	return keys
+}
+
+type TwoWayRepository interface {
+	Save(s *TwoWayState) error
+	// Get returns nil when the playlist was never synced both ways
+	Get(a string, b string, playlistA provider.PlaylistID) (*TwoWayState, error)
+	List() ([]TwoWayState, error)
+}
+
+func NewTwoWayStore(s *store.Store) TwoWayStore {
+	return TwoWayStore{store: s}
+}
+
+// TwoWayStore saves the snapshots of two-way syncs on the application database.
+type TwoWayStore struct {
+	store *store.Store
+}
+
+func (s TwoWayStore) Save(state *TwoWayState) error {
+	state = state.stored()
+	return s.store.Put(TWO_WAY_BUCKET, state.Key(), state)
+}
+
+func (s TwoWayStore) Get(a string, b string, playlistA provider.PlaylistID) (*TwoWayState, error) {
+	var state TwoWayState
+	found, err := s.store.Get(TWO_WAY_BUCKET, syncKey(a, b, playlistA), &state)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// List returns every playlist synced both ways, sorted by name.
+func (s TwoWayStore) List() ([]TwoWayState, error) {
+	states := []TwoWayState{}
+	err := s.store.ForEach(TWO_WAY_BUCKET, func(key string, value []byte) error {
+		var state TwoWayState
+		if err := json.Unmarshal(value, &state); err != nil {
+			return err
+		}
+		states = append(states, state)
+		return nil
+	})
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states, err
+}
+
+// twoWayDiff is what changed on each playlist since the last sync.
+type twoWayDiff struct {
+	// addedA and addedB are the tracks that aren't linked yet
+	addedA []provider.Track
+	addedB []provider.Track
+	// removedA are the links removed from A, whose track is removed from B, and the
+	// other way around
+	removedA  []Link
+	removedB  []Link
+	conflicts []Conflict
+	// kept are the links that are still on both playlists, or on the only side they have
+	kept []Link
+}
+
+// diffBothWays compares the playlists with the snapshot of the last sync. A link that
+// is gone from one playlist was removed there, unless the other playlist has its track
+// added again since the last sync, which is a conflict.
+func diffBothWays(state *TwoWayState, a []provider.Track, b []provider.Track) twoWayDiff {
+	onA := tracksByID(a)
+	onB := tracksByID(b)
+	linkedA := map[string]bool{}
+	linkedB := map[string]bool{}
+	for _, c := range state.Conflicts {
+		linkedA[c.Link.A] = true
+		linkedB[c.Link.B] = true
+	}
+
+	diff := twoWayDiff{}
+	for _, l := range state.Links {
+		linkedA[l.A] = true
+		linkedB[l.B] = true
+		trackA, inA := onA[l.A]
+		trackB, inB := onB[l.B]
+		goneA := l.A != "" && !inA
+		goneB := l.B != "" && !inB
+		if (goneA || l.A == "") && (goneB || l.B == "") {
+			// gone from both, or from the only side it was on
+			continue
+		} else if goneA {
+			if trackB.AddedAt.After(state.SyncedAt) {
+				diff.conflicts = append(diff.conflicts, Conflict{Link: l, RemovedFrom: SIDE_A})
+			} else {
+				diff.removedA = append(diff.removedA, l)
+			}
+		} else if goneB {
+			if trackA.AddedAt.After(state.SyncedAt) {
+				diff.conflicts = append(diff.conflicts, Conflict{Link: l, RemovedFrom: SIDE_B})
+			} else {
+				diff.removedB = append(diff.removedB, l)
+			}
+		} else {
+			diff.kept = append(diff.kept, l)
+		}
+	}
+	diff.addedA = unlinked(a, linkedA)
+	diff.addedB = unlinked(b, linkedB)
+	return diff
+}
+
+func tracksByID(tracks []provider.Track) map[string]provider.Track {
+	byID := map[string]provider.Track{}
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+	return byID
+}
+
+// unlinked returns the tracks that aren't linked, once each.
+func unlinked(tracks []provider.Track, linked map[string]bool) []provider.Track {
+	result := []provider.Track{}
+	seen := map[string]bool{}
+	for _, t := range tracks {
+		if t.ID == "" || linked[t.ID] || seen[t.ID] {
+			continue
+		}
+		seen[t.ID] = true
+		result = append(result, t)
+	}
+	return result
+}
+
+func (t TransferClient) getTwoWayState(j *job.Job, playlist provider.Playlist) (*TwoWayState, error) {
+	state, err := findTwoWayState(t.twoWays, j.Origin, j.Destination, playlist.ID)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		state = &TwoWayState{
+			A:         j.Origin,
+			B:         j.Destination,
+			PlaylistA: playlist.ID,
+			Name:      playlist.Name,
+			Links:     []Link{},
+			Conflicts: []Conflict{},
+		}
+	}
+	if state.Owner == "" {
+		// pairs synced before owners were kept are taken by whoever syncs them next
+		state.Owner = j.Owner
+	}
+	state.Policy = t.policy
+	return state, nil
+}
+
+// syncBothWays adds and removes on each playlist what was added and removed on the
+// other since the last sync. The snapshot is saved after every change, so a sync that
+// stops is continued by running it again.
+func (t TransferClient) syncBothWays(ctx context.Context, j *job.Job, playlist *job.Playlist) error {
+	state, err := t.getTwoWayState(j, playlist.Playlist)
+	if err != nil {
+		return err
+	}
+	if state.PlaylistB == "" {
+		state.PlaylistB, err = getOrCreatePlaylist(ctx, t.destination, playlist.Playlist)
+		if err != nil {
+			return err
+		}
+		if err := t.twoWays.Save(state); err != nil {
+			return err
+		}
+	}
+	if playlist.DestinationID == "" {
+		playlist.DestinationID = state.PlaylistB
+		if err := t.checkpoint(j); err != nil {
+			return err
+		}
+	}
+	t.publishPlaylist(PROGRESS_STARTED_PLAYLSIT, j, playlist)
+	t.publishQuota()
+
+	fullA, err := t.origin.GetFullPlaylist(ctx, string(playlist.ID))
+	if err != nil {
+		return err
+	}
+	fullB, err := t.destination.GetFullPlaylist(ctx, state.PlaylistB)
+	if err != nil {
+		return err
+	}
+	diff := diffBothWays(state, fullA.Tracks, fullB.Tracks)
+	state.Links = diff.kept
+	for _, c := range diff.conflicts {
+		t.publishNamedTrack(PROGRESS_CONFLICT, j, playlist, c.Link.Name)
+		state.Conflicts = append(state.Conflicts, resolveConflict(state.Policy, c))
+	}
+	if err := t.twoWays.Save(state); err != nil {
+		return err
+	}
+
+	s := twoWaySync{client: t, j: j, playlist: playlist, state: state}
+	if err := s.applyResolved(ctx); err != nil {
+		return err
+	}
+	for _, l := range diff.removedA {
+		if err := s.remove(ctx, l, SIDE_B); err != nil {
+			return err
+		}
+	}
+	for _, l := range diff.removedB {
+		if err := s.remove(ctx, l, SIDE_A); err != nil {
+			return err
+		}
+	}
+	if err := s.add(ctx, diff.addedA, SIDE_B, tracksByID(fullB.Tracks)); err != nil {
+		return err
+	}
+	// tracks added on both playlists were linked while adding the ones from A
+	addedB := unlinked(diff.addedB, s.linked(SIDE_B))
+	if err := s.add(ctx, addedB, SIDE_A, tracksByID(fullA.Tracks)); err != nil {
+		return err
+	}
+
+	state.Name = playlist.Name
+	state.SyncedAt = time.Now()
+	if err := t.twoWays.Save(state); err != nil {
+		return err
+	}
+	t.publishPlaylist(PROGRESS_PLAYLIST_DONE, j, playlist)
+	return nil
+}
+
+// resolveConflict sets the resolution of the conflict according to the policy, with
+// POLICY_MANUAL it's left for review.
+func resolveConflict(policy string, c Conflict) Conflict {
+	if policy == POLICY_SOURCE_WINS && c.RemovedFrom == SIDE_A {
+		c.Resolution = RESOLUTION_REMOVE
+	} else if policy == POLICY_SOURCE_WINS || policy == POLICY_UNION {
+		c.Resolution = RESOLUTION_KEEP
+	}
+	return c
+}
+
+func otherSide(side string) string {
+	if side == SIDE_A {
+		return SIDE_B
+	}
+	return SIDE_A
+}
+
+// twoWaySync applies the changes of a two-way sync to the playlists.
+type twoWaySync struct {
+	client   TransferClient
+	j        *job.Job
+	playlist *job.Playlist
+	state    *TwoWayState
+}
+
+func (s twoWaySync) provider(side string) provider.Provider {
+	if side == SIDE_A {
+		return s.client.origin
+	}
+	return s.client.destination
+}
+
+func (s twoWaySync) playlistID(side string) string {
+	if side == SIDE_A {
+		return string(s.state.PlaylistA)
+	}
+	return s.state.PlaylistB
+}
+
+// linked returns the IDs of the tracks linked on the side.
+func (s twoWaySync) linked(side string) map[string]bool {
+	linked := map[string]bool{}
+	for _, l := range s.state.Links {
+		if id := l.ID(side); id != "" {
+			linked[id] = true
+		}
+	}
+	return linked
+}
+
+func (s twoWaySync) save() error {
+	if err := s.client.twoWays.Save(s.state); err != nil {
+		return err
+	}
+	return s.client.checkpoint(s.j)
+}
+
+// applyResolved applies the conflicts that have a resolution, leaving the others for review.
+func (s twoWaySync) applyResolved(ctx context.Context) error {
+	resolved := []Conflict{}
+	pending := []Conflict{}
+	for _, c := range s.state.Conflicts {
+		if c.Resolution == "" {
+			pending = append(pending, c)
+		} else {
+			resolved = append(resolved, c)
+		}
+	}
+	for i, c := range resolved {
+		// removed from the state before it's applied, saved by restore and remove
+		s.state.Conflicts = append(append([]Conflict{}, pending...), resolved[i+1:]...)
+		if c.Resolution == RESOLUTION_KEEP {
+			if err := s.restore(ctx, c.Link, c.RemovedFrom); err != nil {
+				return err
+			}
+		} else if err := s.remove(ctx, c.Link, otherSide(c.RemovedFrom)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore adds the track of the link back to the side it was removed from.
+func (s twoWaySync) restore(ctx context.Context, l Link, side string) error {
+	err := s.provider(side).AddToPlaylist(ctx, s.playlistID(side), l.ID(side))
+	if errors.Is(err, provider.ErrNotFound) {
+		// e.g. the video was deleted, the link is dropped so it's added again if found later
+		s.client.publishNamedTrack(PROGRESS_TRACK_FAILED, s.j, s.playlist, l.Name)
+		return s.save()
+	}
+	if err != nil {
+		return err
+	}
+	s.state.Links = append(s.state.Links, l)
+	s.client.publishNamedTrack(PROGRESS_TRACK_DONE, s.j, s.playlist, l.Name)
+	return s.save()
+}
+
+// remove removes the track of the link from the side, unless another link has the same
+// track, e.g. the same song from a different album.
+func (s twoWaySync) remove(ctx context.Context, l Link, side string) error {
+	id := l.ID(side)
+	if id != "" && !s.linked(side)[id] {
+		err := s.provider(side).RemoveFromPlaylist(ctx, s.playlistID(side), id)
+		if err != nil && !errors.Is(err, provider.ErrNotFound) {
+			return err
+		}
+		s.playlist.Removed++
+		s.client.publishNamedTrack(PROGRESS_TRACK_REMOVED, s.j, s.playlist, l.Name)
+	}
+	return s.save()
+}
+
+// add matches the tracks of the other side on the side, adding the ones that aren't
+// there yet and linking them. existing are the tracks on the side before the sync.
+// Tracks are matched concurrently, but added in the order of the other side.
+func (s twoWaySync) add(ctx context.Context, tracks []provider.Track, side string, existing map[string]provider.Track) error {
+	pending := map[int]provider.Track{}
+	for i, track := range tracks {
+		pending[i] = track
+	}
+	results := make([]match.Result, len(tracks))
+	err := s.client.resolveTracks(ctx, s.provider(side), pending, func(r resolvedTrack) error {
+		if r.err != nil && !errors.Is(r.err, provider.ErrNotFound) {
+			return r.err
+		}
+		results[r.index] = r.result
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	linked := s.linked(side)
+	for i, track := range tracks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result := results[i]
+		t := job.Track{Track: track, Score: result.Score}
+		progress := PROGRESS_TRACK_DONE
+		if !result.Matched {
+			t.State = job.TRACK_SKIPPED
+			t.Reason = "no match found"
+			progress = PROGRESS_TRACK_UNMATCHED
+		} else if _, ok := existing[result.Track.ID]; ok || linked[result.Track.ID] {
+			t.State = job.TRACK_SKIPPED
+			t.DestinationID = result.Track.ID
+			t.Reason = "already in playlist"
+			progress = PROGRESS_TRACK_DUPLICATE
+		} else {
+			t.DestinationID = result.Track.ID
+			err := s.provider(side).AddToPlaylist(ctx, s.playlistID(side), t.DestinationID)
+			if errors.Is(err, provider.ErrNotFound) {
+				// not linked, so it's tried again on the next sync
+				t.State = job.TRACK_FAILED
+				t.Reason = fmt.Sprintf("not found on %s", s.provider(side).Name())
+				s.playlist.Tracks = append(s.playlist.Tracks, t)
+				s.client.publishTrack(PROGRESS_TRACK_FAILED, s.j, s.playlist, &t)
+				if err := s.save(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			t.State = job.TRACK_ADDED
+		}
+		l := Link{Name: track.FullName()}
+		if side == SIDE_B {
+			l.A, l.B = track.ID, t.DestinationID
+		} else {
+			l.A, l.B = t.DestinationID, track.ID
+		}
+		if t.DestinationID != "" {
+			linked[t.DestinationID] = true
+		}
+		s.playlist.Tracks = append(s.playlist.Tracks, t)
+		s.state.Links = append(s.state.Links, l)
+		if err := s.save(); err != nil {
+			return err
+		}
+		s.client.publishTrack(progress, s.j, s.playlist, &t)
+	}
+	return nil
+}
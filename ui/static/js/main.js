@@ -1,4 +1,8 @@
 let socket = undefined;
+let events = undefined;
+
+// the version of the progress events this page understands, see docs/progress-events.schema.json
+const PROTOCOL_VERSION = 1;
 
 function getSelectedPlaylists() {
     return $("#table input[type=checkbox]:checked").map(function() {
@@ -23,21 +27,38 @@ function toggleSelectAll(checked) {
 }
 
 function toggleSubmitButton() {
-    if ($("#table input[type=checkbox]:checked").length == 0) {
-        document.getElementById("submit").classList.add("disabled")
-    } else {
-        document.getElementById("submit").classList.remove("disabled")
+    for (const id of ["submit", "preview"]) {
+        if ($("#table input[type=checkbox]:checked").length == 0) {
+            document.getElementById(id).classList.add("disabled")
+        } else {
+            document.getElementById(id).classList.remove("disabled")
+        }
     }
 }
 
 function setup() {
+    if (document.getElementById("submit") === null) {
+        return;
+    }
+    // the transfer started before the page was refreshed keeps running, follow it again
+    const followed = JSON.parse(sessionStorage.getItem("job"));
+    if (followed !== null) {
+        setupProgress(followed.playlists, "Transfer in Progress");
+        followJob(followed.id);
+        return;
+    }
     $(".checkbox").change(function() {
         toggleSubmitButton();
     })
     document.getElementById("submit").onclick = () => {
         const playlists = getSelectedPlaylists()
-        setupProgress(playlists);
-        startTransfer(playlists);
+        setupProgress(playlists, "Transfer in Progress");
+        startTransfer(playlists, false);
+    }
+    document.getElementById("preview").onclick = () => {
+        const playlists = getSelectedPlaylists()
+        setupProgress(playlists, "Preparing Preview");
+        startTransfer(playlists, true);
     }
     document.getElementById("bulk").onchange = (event) => {
         toggleSelectAll(event.target.checked)
@@ -48,15 +69,56 @@ function stopSocket() {
     if (socket !== undefined) {
         socket.close();
     }
+    if (events !== undefined) {
+        events.close();
+        sessionStorage.removeItem("job");
+    }
 }
 
-function startTransfer(playlists) {
+function cancelTransfer() {
+    if (socket !== undefined && socket.readyState === WebSocket.OPEN) {
+        socket.send(JSON.stringify({"type": "cancel"}));
+    } else if (window.jobId !== undefined) {
+        // the job publishes that it was cancelled on its next checkpoint
+        fetch(`/api/v1/jobs/${window.jobId}/cancel`, {method: "POST"});
+    }
+}
+
+// startTransfer starts a job and follows its events, which keep coming after the page is
+// refreshed. Previews aren't jobs, they run on a socket while the page is open.
+function startTransfer(playlists, dryRun) {
+    const submit = document.getElementById("submit");
+    const payload = {
+        "origin": submit.dataset.origin,
+        "destination": submit.dataset.destination,
+        "order": document.getElementById("mirrorOrder").checked ? "mirror" : "append",
+        "mode": transferMode(),
+        "removeMissing": document.getElementById("removeMissing").checked,
+        "policy": document.getElementById("policy").value,
+        "dryRun": dryRun,
+        "playlists": playlists.map(x => {
+            return {"id": x.id, "name": x.name}
+        })
+    };
+    if (!dryRun) {
+        fetch("/api/v1/jobs", {method: "POST", body: JSON.stringify(payload)})
+            .then(async response => {
+                const body = await response.json();
+                if (!response.ok) {
+                    throw new Error(body.error.message);
+                }
+                return body;
+            })
+            .then(job => {
+                sessionStorage.setItem("job", JSON.stringify({id: job.id, playlists: playlists}));
+                followJob(job.id);
+            })
+            .catch(err => updateProgressEndText(`error: ${err.message}`));
+        return;
+    }
     socket = new WebSocket("ws://localhost:8080/transfer")
-    const payload = playlists.map(x => {
-        return {"id": x.id, "name": x.name}
-    })
     socket.addEventListener('open', (event) => {
-        socket.send(JSON.stringify({"playlists": payload}));
+        socket.send(JSON.stringify(payload));
     });
     
     socket.addEventListener('message', (event) => {
@@ -65,13 +127,85 @@ function startTransfer(playlists) {
     });
 }
 
+// followJob shows the progress of the job from its first event, the browser reconnects
+// on its own sending the last event it got.
+function followJob(id) {
+    window.jobId = id;
+    events = new EventSource(`/jobs/${id}/events`);
+    events.addEventListener('message', (event) => {
+        handleMessage(JSON.parse(event.data));
+    });
+    events.addEventListener('error', (event) => {
+        if (events.readyState === EventSource.CLOSED) {
+            updateProgressEndText("The transfer is no longer running, see the jobs page for its result.")
+        }
+    });
+}
+
+function transferMode() {
+    if (document.getElementById("twoWayMode").checked) {
+        return "two-way"
+    }
+    return document.getElementById("syncMode").checked ? "sync" : "transfer"
+}
+
 function handleMessage(msg) {
+    if (msg.version !== PROTOCOL_VERSION) {
+        updateProgressEndText(`unsupported message version received from server: ${msg.version}`)
+        return
+    }
+    if (msg.counts !== undefined) {
+        updateTrackProgress(msg.playlist, msg.counts)
+    }
     switch (msg.type) {
         case "playlist-start":
-            updatePlaylistName(msg.body)
+            updatePlaylistName(msg.playlistName)
             break;
         case "track-done":
-            increaseTrackProgress()
+            updateRetryStatus("")
+            break;
+        case "track-skipped":
+        case "track-duplicate":
+            break;
+        case "track-unmatched":
+            addUnmatchedTrack(msg.track)
+            break;
+        case "track-failed":
+            addUnmatchedTrack(`${msg.track} (no longer available)`)
+            break;
+        case "cache-hit":
+            updateCacheStats(1, 0)
+            break;
+        case "cache-miss":
+            updateCacheStats(0, 1)
+            break;
+        case "retry":
+            updateRetryStatus(`${pauseReason(msg.code)}, ${msg.body}`)
+            break;
+        case "quota":
+            document.getElementById("quotaRemaining").innerText = `Quota remaining: ${msg.body}`
+            break;
+        case "job":
+            window.jobId = msg.body
+            break;
+        case "paused":
+            updateProgressEndText(`${pauseReason(msg.code)}, paused until ${new Date(msg.body).toLocaleString()}. ` +
+                "The transfer resumes automatically, see the jobs page for its progress.")
+            break;
+        case "track-removed":
+            addRemovedTrack(msg.track)
+            break;
+        case "conflict":
+            addConflict(msg.track)
+            break;
+        case "track-moved":
+            // tracks already on the playlist being put in the original order
+            break;
+        case "cancelled":
+            updateProgressEndText("Cancelled")
+            break;
+        case "plan":
+            showPlan(JSON.parse(msg.body))
             break;
         case "playlist-done":
             increasePlaylistProgress()
@@ -81,7 +215,7 @@ function handleMessage(msg) {
             stopSocket()
             break;
         case "error":
-            updateProgressEndText(`error: ${msg.body}`)
+            updateProgressEndText(errorText(msg))
             break;
         default:
             updateProgressEndText(`invalid message received from server: ${msg.type}`)
@@ -89,10 +223,54 @@ function handleMessage(msg) {
     }
 }
 
-function increaseTrackProgress() {
-    const el = document.getElementById("trackProgressCount");
-    const currentCount = parseInt(el.innerText.split(" ")[2]);
-    el.innerText = `Tracks Transferred: ${currentCount + 1} of ${window.totalTracks}`;
+function pauseReason(code) {
+    switch (code) {
+        case "rate-limited":
+            return "Too many requests"
+        case "transient":
+            return "The service is unavailable"
+        default:
+            return "The daily quota is exhausted"
+    }
+}
+
+function errorText(msg) {
+    switch (msg.code) {
+        case "unauthorized":
+            return "Your login expired, log in again and resume the transfer from the jobs page."
+        case "not-found":
+            return `A playlist was not found: ${msg.body}`
+        default:
+            return `error: ${msg.body}`
+    }
+}
+
+function showPlan(plan) {
+    const count = (key) => plan.playlists.reduce((total, p) => total + p[key].length, 0);
+    const cost = Object.entries(plan.cost).map(([name, units]) => `${units} units on ${name}`);
+    updateProgressEndText(`${count("add")} tracks would be added, ` +
+        `${count("present")} are already there and ${count("unmatched")} have no match. ` +
+        `Estimated quota: ${cost.length > 0 ? cost.join(", ") : "none"}.`)
+    const link = document.createElement("a");
+    link.classList.add("swapDirection");
+    link.href = `/plans/${plan.id}`;
+    link.textContent = "See the details";
+    document.getElementById("progressEndText").appendChild(document.createElement("br"));
+    document.getElementById("progressEndText").appendChild(link);
+}
+
+// updateTrackProgress counts the tracks done on every playlist, the totals are only
+// known once the playlists are read so the ones shown on the table are used until then.
+function updateTrackProgress(playlist, counts) {
+    window.trackCounts[playlist] = counts;
+    let done = 0;
+    let total = 0;
+    window.playlistTotals.forEach((playlistTotal, i) => {
+        const playlistCounts = window.trackCounts[i];
+        done += playlistCounts === undefined ? 0 : playlistCounts.done;
+        total += playlistCounts === undefined ? playlistTotal : playlistCounts.total;
+    });
+    document.getElementById("trackProgressCount").innerText = `Tracks Transferred: ${done} of ${total}`;
 }
 
 function increasePlaylistProgress() {
@@ -101,6 +279,47 @@ function increasePlaylistProgress() {
     el.innerText = `Playlists Transferred: ${currentCount + 1} of ${window.playlistsTotal}`
 }
 
+function addUnmatchedTrack(name) {
+    const el = document.getElementById("unmatchedTracks");
+    const item = document.createElement("li");
+    item.textContent = name;
+    el.appendChild(item);
+    el.classList.remove("disabled");
+}
+
+function addRemovedTrack(name) {
+    const el = document.getElementById("removedTracks");
+    const item = document.createElement("li");
+    item.textContent = name;
+    el.appendChild(item);
+    el.classList.remove("disabled");
+}
+
+function addConflict(name) {
+    const el = document.getElementById("conflicts");
+    const item = document.createElement("li");
+    item.textContent = name;
+    el.appendChild(item);
+    el.classList.remove("disabled");
+}
+
+function updateCacheStats(hits, misses) {
+    window.cacheHits += hits;
+    window.cacheMisses += misses;
+    document.getElementById("cacheStats").innerText =
+        `Cached matches: ${window.cacheHits} hits, ${window.cacheMisses} misses`;
+}
+
+function updateRetryStatus(text) {
+    const el = document.getElementById("retryStatus");
+    el.innerText = text;
+    if (text === "") {
+        el.classList.add("disabled");
+    } else {
+        el.classList.remove("disabled");
+    }
+}
+
 function updatePlaylistName(name) {
     document.getElementById("currentPlaylist").innerText = `Transfering Playlist: ${name}`;
 }
@@ -114,16 +333,17 @@ function updateProgressEndText(text) {
     el.innerText = text
     el.classList.remove("disabled")
     el.classList.add("enabled")
+    document.getElementById("cancelTransfer").classList.add("disabled")
     stopSocket();
 }
 
-function setupProgress(playlists) {
+function setupProgress(playlists, titleText) {
     progressContainer = document.createElement("div");
     progressContainer.classList.add("progressContainer")
 
     title = document.createElement("p");
     title.classList.add("progressTitle")
-    title.textContent = "Transfer in Progress"
+    title.textContent = titleText
 
     currentPlaylist = document.createElement("p");
     currentPlaylist.classList.add("currentPlaylist");
@@ -139,10 +359,52 @@ function setupProgress(playlists) {
     trackProgressCount = document.createElement("p")
     trackProgressCount.classList.add("trackProgressCount")
     trackProgressCount.id = "trackProgressCount"
-    const totalTracks = getTotalOfTracks(playlists);
-    window.totalTracks = totalTracks;
+    window.playlistTotals = getTotalOfTracks(playlists);
+    window.trackCounts = {};
+    const totalTracks = window.playlistTotals.reduce((total, n) => total + n, 0);
     trackProgressCount.textContent = `Tracks Transferred: 0 of ${totalTracks}`
 
+    quotaRemaining = document.createElement("p")
+    quotaRemaining.classList.add("quotaRemaining")
+    quotaRemaining.id = "quotaRemaining"
+    quotaRemaining.textContent = "Quota remaining: -"
+
+    cacheStats = document.createElement("p")
+    cacheStats.classList.add("cacheStats")
+    cacheStats.id = "cacheStats"
+    window.cacheHits = 0;
+    window.cacheMisses = 0;
+    cacheStats.textContent = "Cached matches: 0 hits, 0 misses"
+
+    retryStatus = document.createElement("p")
+    retryStatus.classList.add("retryStatus")
+    retryStatus.classList.add("disabled")
+    retryStatus.id = "retryStatus"
+
+    unmatchedTracks = document.createElement("ul");
+    unmatchedTracks.classList.add("unmatchedTracks");
+    unmatchedTracks.classList.add("disabled");
+    unmatchedTracks.id = "unmatchedTracks";
+    unmatchedTracks.textContent = "Tracks without a match:";
+
+    removedTracks = document.createElement("ul");
+    removedTracks.classList.add("unmatchedTracks");
+    removedTracks.classList.add("disabled");
+    removedTracks.id = "removedTracks";
+    removedTracks.textContent = "Tracks removed:";
+
+    conflicts = document.createElement("ul");
+    conflicts.classList.add("unmatchedTracks");
+    conflicts.classList.add("disabled");
+    conflicts.id = "conflicts";
+    conflicts.textContent = "Removed on one playlist and added again on the other:";
+
+    cancelButton = document.createElement("a");
+    cancelButton.classList.add("cancelTransfer");
+    cancelButton.id = "cancelTransfer";
+    cancelButton.textContent = "Cancel";
+    cancelButton.onclick = cancelTransfer;
+
     progressEndText = document.createElement("p");
     progressEndText.classList.add("progressEndText");
     progressEndText.classList.add("disabled");
@@ -152,6 +414,13 @@ function setupProgress(playlists) {
     progressContainer.appendChild(currentPlaylist);
     progressContainer.appendChild(playlistProgressCount);
     progressContainer.appendChild(trackProgressCount);
+    progressContainer.appendChild(quotaRemaining);
+    progressContainer.appendChild(cacheStats);
+    progressContainer.appendChild(retryStatus);
+    progressContainer.appendChild(unmatchedTracks);
+    progressContainer.appendChild(removedTracks);
+    progressContainer.appendChild(conflicts);
+    progressContainer.appendChild(cancelButton);
     progressContainer.appendChild(progressEndText);
 
     document.getElementsByTagName("body")[0].replaceChildren(progressContainer)